@@ -0,0 +1,198 @@
+package camera
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Arming mode of camera, i.e. when its stream is saved to storage.
+const (
+	ArmArmed    = "armed"
+	ArmDisarmed = "disarmed"
+	ArmSchedule = "schedule"
+)
+
+const armingCheckInterval = 10 * time.Second
+
+// TimeRange is range of time in a day, formatted as "15:04". If End is not
+// after Start, the range continues until End in the next day.
+type TimeRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Arming is the arming setting of a camera. In schedule mode, the camera
+// is armed within the time ranges of each weekday, starting from Sunday.
+type Arming struct {
+	Mode     string         `json:"mode"`
+	Schedule [7][]TimeRange `json:"schedule"`
+}
+
+// Validate checks whether the arming setting is valid.
+func (a Arming) Validate() error {
+	switch a.Mode {
+	case ArmArmed, ArmDisarmed, ArmSchedule:
+	default:
+		return fmt.Errorf("arming mode %s is not supported", a.Mode)
+	}
+
+	for _, ranges := range a.Schedule {
+		for _, r := range ranges {
+			if _, _, err := r.parse(time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Armed returns whether the camera should be armed at the specified time.
+func (a Arming) Armed(now time.Time) bool {
+	switch a.Mode {
+	case ArmArmed:
+		return true
+	case ArmDisarmed:
+		return false
+	}
+
+	// The range from yesterday might continue until today
+	for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+		for _, r := range a.Schedule[day.Weekday()] {
+			start, end, err := r.parse(day)
+			if err == nil && !now.Before(start) && now.Before(end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parse returns start and end of the time range in the specified day.
+func (r TimeRange) parse(day time.Time) (time.Time, time.Time, error) {
+	start, err := time.Parse("15:04", r.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("start time %s must be HH:MM", r.Start)
+	}
+
+	end, err := time.Parse("15:04", r.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("end time %s must be HH:MM", r.End)
+	}
+
+	startTime := time.Date(day.Year(), day.Month(), day.Day(),
+		start.Hour(), start.Minute(), 0, 0, day.Location())
+	endTime := time.Date(day.Year(), day.Month(), day.Day(),
+		end.Hour(), end.Minute(), 0, 0, day.Location())
+	if !endTime.After(startTime) {
+		endTime = endTime.AddDate(0, 0, 1)
+	}
+
+	return startTime, endTime, nil
+}
+
+// LoadArming loads the arming setting of camera from database.
+// By default camera is always armed.
+func LoadArming(db *bolt.DB, cameraID string) Arming {
+	arming := Arming{Mode: ArmArmed}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("arming"))
+		if bucket == nil {
+			return nil
+		}
+
+		if val := bucket.Get([]byte(cameraID)); val != nil {
+			json.Unmarshal(val, &arming)
+		}
+		return nil
+	})
+
+	return arming
+}
+
+// SetArming saves the arming setting of camera, then applies it immediately
+// without restarting the camera.
+func (cam *Camera) SetArming(arming Arming) error {
+	if err := arming.Validate(); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(&arming)
+	if err != nil {
+		return err
+	}
+
+	err = cam.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("arming"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(cam.ID), value)
+	})
+	if err != nil {
+		return err
+	}
+
+	cam.updateArmed()
+	return nil
+}
+
+// Armed returns whether the camera currently saves its stream to storage.
+func (cam *Camera) Armed() bool {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+	return cam.SaveToStorage && cam.armed
+}
+
+// runArming periodically checks the arming schedule until the camera stopped.
+func (cam *Camera) runArming() {
+	for {
+		select {
+		case <-cam.stopChannel():
+			return
+		case <-time.After(armingCheckInterval):
+		}
+
+		cam.updateArmed()
+	}
+}
+
+// updateArmed updates the armed state following the arming setting.
+// The change is picked up by the recorders on the next keyframe.
+func (cam *Camera) updateArmed() {
+	armed := LoadArming(cam.DB, cam.ID).Armed(time.Now())
+
+	cam.mutex.Lock()
+	changed := cam.armed != armed
+	cam.armed = armed
+	cam.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if armed {
+		RecordEvent(cam.DB, cam.ID, EventArming, "camera armed")
+	} else {
+		RecordEvent(cam.DB, cam.ID, EventArming, "camera disarmed")
+	}
+}
+
+// initArmed sets the initial armed state without recording it as event.
+func (cam *Camera) initArmed() {
+	armed := LoadArming(cam.DB, cam.ID).Armed(time.Now())
+
+	cam.mutex.Lock()
+	cam.armed = armed
+	cam.mutex.Unlock()
+
+	if !armed {
+		logrus.WithField("camera", cam.ID).Infoln("camera is disarmed, stream is not saved")
+	}
+}
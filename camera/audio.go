@@ -0,0 +1,193 @@
+package camera
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultAudioSampleRate = 44100
+	defaultAudioBitrate    = 64
+)
+
+// AudioSetting is the setting for capturing audio from ALSA device.
+// Audio is only captured when device specified and not muted.
+type AudioSetting struct {
+	Device     string
+	SampleRate int
+	Bitrate    int
+	Muted      bool
+}
+
+func (as AudioSetting) enabled() bool {
+	return as.Device != "" && !as.Muted
+}
+
+// validateAudioSetting checks the audio setting before it's saved.
+func validateAudioSetting(setting map[string]string) error {
+	if strings.ContainsAny(setting["audioDevice"], " \t\n") {
+		return fmt.Errorf("audio device must not contain whitespace")
+	}
+
+	switch setting["audioSampleRate"] {
+	case "", "8000", "16000", "22050", "32000", "44100", "48000":
+	default:
+		return fmt.Errorf("audio sample rate %s is not supported", setting["audioSampleRate"])
+	}
+
+	if setting["audioBitrate"] != "" {
+		if bitrate, err := strconv.Atoi(setting["audioBitrate"]); err != nil || bitrate < 16 || bitrate > 320 {
+			return fmt.Errorf("audio bitrate must be between 16 and 320 kbps")
+		}
+	}
+
+	return validateSwitch("audio mute", setting["audioMute"])
+}
+
+// loadAudioSetting loads the audio setting of camera from database.
+func loadAudioSetting(db *bolt.DB, cameraID string) AudioSetting {
+	setting := AudioSetting{
+		SampleRate: defaultAudioSampleRate,
+		Bitrate:    defaultAudioBitrate,
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		setting.Device = string(bucket.Get([]byte("audioDevice")))
+		setting.Muted = string(bucket.Get([]byte("audioMute"))) == "on"
+
+		if sampleRate, err := strconv.Atoi(string(bucket.Get([]byte("audioSampleRate")))); err == nil && sampleRate > 0 {
+			setting.SampleRate = sampleRate
+		}
+
+		if bitrate, err := strconv.Atoi(string(bucket.Get([]byte("audioBitrate")))); err == nil && bitrate > 0 {
+			setting.Bitrate = bitrate
+		}
+
+		return nil
+	})
+
+	return setting
+}
+
+// genCmdAudioCapture creates command that captures audio from ALSA device and encodes it
+// as AAC in ADTS container. ALSA device can only be opened once, so the audio is captured
+// by this single process then shared to the other processes through extra pipe.
+func genCmdAudioCapture(setting AudioSetting) *exec.Cmd {
+	return exec.Command("ffmpeg",
+		"-nostdin",
+		"-loglevel", "error",
+		"-f", "alsa",
+		"-ar", strconv.Itoa(setting.SampleRate),
+		"-ac", "1",
+		"-i", setting.Device,
+		"-codec:a", "aac",
+		"-b:a", strconv.Itoa(setting.Bitrate)+"k",
+		"-f", "adts",
+		"pipe:1")
+}
+
+// audioInputArgs is ffmpeg arguments for reading the shared audio, which given as the
+// first extra file of process. Extra file is the fourth file descriptor, after stdin,
+// stdout and stderr.
+var audioInputArgs = []string{"-f", "aac", "-i", "pipe:3"}
+
+// attachAudio gives the process a pipe for reading the shared audio.
+// The returned writer must be closed once the process finished.
+func attachAudio(cmd *exec.Cmd) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.ExtraFiles = []*os.File{r}
+	return w, nil
+}
+
+// releaseAudio closes the read side of audio pipe that already inherited by the process.
+func releaseAudio(cmd *exec.Cmd) {
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+}
+
+// adtsScanner splits AAC stream in ADTS container into frames, so the stream
+// can be joined from the middle without breaking any frame.
+type adtsScanner struct {
+	buf []byte
+}
+
+func (as *adtsScanner) push(p []byte, fn func(frame []byte)) {
+	as.buf = append(as.buf, p...)
+
+	for {
+		// Look for the sync word
+		start := -1
+		for i := 0; i+1 < len(as.buf); i++ {
+			if as.buf[i] == 0xFF && as.buf[i+1]&0xF0 == 0xF0 {
+				start = i
+				break
+			}
+		}
+
+		if start < 0 {
+			// Keep the last byte, it might be the start of sync word
+			if len(as.buf) > 1 {
+				as.buf = as.buf[len(as.buf)-1:]
+			}
+			return
+		}
+
+		as.buf = as.buf[start:]
+		if len(as.buf) < 7 {
+			return
+		}
+
+		// Frame length is 13 bits, starting from the 31st bit of header
+		length := int(as.buf[3]&0x03)<<11 | int(as.buf[4])<<3 | int(as.buf[5])>>5
+		if length < 7 {
+			as.buf = as.buf[1:]
+			continue
+		}
+
+		if len(as.buf) < length {
+			return
+		}
+
+		frame := make([]byte, length)
+		copy(frame, as.buf[:length])
+		as.buf = as.buf[length:]
+		fn(frame)
+	}
+}
+
+// writerFunc is adapter to use function as io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (fn writerFunc) Write(p []byte) (int, error) {
+	return fn(p)
+}
+
+// audioFanout writes the shared audio to the consumers. Unlike io.MultiWriter,
+// failure in one consumer doesn't stop the audio from reaching the others.
+type audioFanout struct {
+	writers []io.Writer
+}
+
+func (af *audioFanout) Write(p []byte) (int, error) {
+	for _, w := range af.writers {
+		w.Write(p)
+	}
+
+	return len(p), nil
+}
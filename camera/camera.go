@@ -8,7 +8,7 @@ import (
 	"os/exec"
 	fp "path/filepath"
 	"strconv"
-	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
@@ -16,10 +16,12 @@ import (
 
 var developmentMode = false
 
-// RaspiCam is controller for Raspberry Pi camera.
-// It's used to capture the camera stream and process it.
-type RaspiCam struct {
-	DB *bolt.DB
+// Camera is controller for the camera pipeline.
+// It's used to receive the stream from camera source and process it.
+type Camera struct {
+	ID     string
+	DB     *bolt.DB
+	Source Source
 
 	SaveToStorage bool
 	StorageDir    string
@@ -27,16 +29,40 @@ type RaspiCam struct {
 	GenerateHlsSegments bool
 	HlsSegmentsDir      string
 
-	fps      int
-	width    int
-	height   int
-	rotation int
-	chStop   chan struct{}
+	mutex  sync.Mutex
+	status Status
+	armed  bool
+	chStop chan struct{}
+	hub    streamHub
+	mjpeg  mjpegStream
+	llhls  *llhlsMuxer
+	rtc    webrtcSessions
+
+	restream restreams
+	sprites  spriteCache
+	snapshot snapshotCache
 }
 
-// Start activates the camera, receive the stream and then process it
-func (cam *RaspiCam) Start() error {
-	logrus.Infoln("starting camera")
+// runPipeline activates the camera source, receive the stream and then process it.
+// It blocks until stop requested or any of the child processes exited.
+func (cam *Camera) runPipeline() (err error) {
+	logrus.WithField("camera", cam.ID).Infoln("starting camera")
+
+	// The source must be exited before the pipeline is restarted, otherwise it will
+	// keep writing into the hub. It's waited after all consumers are killed and
+	// their pipes are closed, so the source never blocks on writing its stream.
+	var chSourceExit chan struct{}
+	defer func() {
+		if chSourceExit == nil {
+			return
+		}
+
+		if waitErr := cam.waitSource(chSourceExit); waitErr != nil && err != nil {
+			err = fmt.Errorf("%v, %v", err, waitErr)
+		} else if waitErr != nil {
+			err = waitErr
+		}
+	}()
 
 	// If the HLS segments dir is not empty, remove its contents
 	dirItems, err := ioutil.ReadDir(cam.HlsSegmentsDir)
@@ -53,143 +79,252 @@ func (cam *RaspiCam) Start() error {
 		}
 	}
 
-	// Create channels
-	cam.chStop = make(chan struct{})
+	// Load settings from source
+	setting := cam.Source.Setting()
+	motionSetting := loadMotionSetting(cam.DB, cam.ID)
+	recordingSetting := loadRecordingSetting(cam.DB, cam.ID)
+	recordContinuous := cam.SaveToStorage && recordingSetting.Mode != RecordMotion
+	recordMotion := cam.SaveToStorage && recordingSetting.Mode != RecordContinuous
+	audioSetting := loadAudioSetting(cam.DB, cam.ID)
+	withAudio := audioSetting.enabled()
+	lowRendition := loadLowRendition(cam.DB, cam.ID)
+
+	// Prepare the consumers of camera stream. The audio, if any,
+	// is only muxed into live stream and continuous recording.
+	var consumers []*childProcess
+	var hlsConsumers []*childProcess
+	var audioConsumers []io.Writer
+	if cam.GenerateHlsSegments {
+		hlsConsumers = append(hlsConsumers,
+			newChildProcess(cam.ID, "hls", cam.genCmdHlsSegments(setting, withAudio)))
+	}
+
+	// Low rendition for client with slow connection
+	if cam.GenerateHlsSegments && lowRendition.Enabled {
+		err = os.MkdirAll(cam.LowSegmentsDir(), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create low rendition dir: %v", err)
+		}
+
+		hlsConsumers = append(hlsConsumers, newChildProcess(cam.ID, "hls-low",
+			cam.genCmdLowRendition(setting, lowRendition, withAudio)))
+	}
+
+	for _, hls := range hlsConsumers {
+		if withAudio {
+			audioPipe, err := attachAudio(hls.cmd)
+			if err != nil {
+				return fmt.Errorf("failed to create audio pipe: %v", err)
+			}
+
+			defer audioPipe.Close()
+			audioConsumers = append(audioConsumers, audioPipe)
+		}
+
+		consumers = append(consumers, hls)
+	}
+
+	// Storage is only written while armed, which might
+	// change anytime without restarting the source
+	var outConsumers []io.Writer
+	if recordContinuous {
+		storage := newStorageRecorder(cam.ID, cam.Armed, func() *exec.Cmd {
+			return cam.genCmdSaveToStorage(setting, withAudio)
+		}, withAudio)
+		defer storage.Close()
+
+		outConsumers = append(outConsumers, storage)
+		if withAudio {
+			audioConsumers = append(audioConsumers, storage.audioWriter())
+		}
+	}
+
+	// Low latency stream is packaged in memory, since
+	// ffmpeg segmenter can't create partial segments
+	if cam.GenerateHlsSegments && loadLowLatency(cam.DB, cam.ID) {
+		muxer := newLLHLSMuxer(cam.ID, setting)
+		cam.setLowLatencyMuxer(muxer)
+		defer func() {
+			cam.setLowLatencyMuxer(nil)
+			muxer.Close()
+		}()
+
+		outConsumers = append(outConsumers, muxer)
+	}
+
+	// Motion clips need the motion detector, so it's
+	// enabled as well regardless of its setting
+	onMotionStart := func() {}
+	onMotionEnd := cam.handleMotionEvent
+
+	if recordMotion {
+		recorder := newClipRecorder(cam.ID, cam.StorageDir, setting.FPS, recordingSetting, cam.Armed)
+		defer recorder.Close()
+
+		outConsumers = append(outConsumers, recorder)
+		onMotionStart = recorder.motionStarted
+		onMotionEnd = func(event MotionEvent) {
+			cam.handleMotionEvent(event)
+			recorder.motionEnded(event.End)
+		}
+	}
+
+	if motionSetting.Enabled || recordMotion {
+		detector := newMotionDetector(motionSetting.Threshold, onMotionStart, onMotionEnd)
+		defer detector.Flush()
 
-	// Load settings from database
-	cam.loadSetting()
+		motion := newChildProcess(cam.ID, "motion", cam.genCmdMotionFrames(setting))
+		motion.cmd.Stdout = detector
+		consumers = append(consumers, motion)
+	}
 
-	// Create cmd for child process
-	cmdRaspivid := cam.genCmdRaspivid()
-	cmdHlsSegments := cam.genCmdHlsSegments()
-	cmdSaveToStorage := cam.genCmdSaveToStorage()
+	// Create pipe for directing source to the consumers. Unlike io.Pipe,
+	// it's broken once the process exited, so writing never blocks forever.
+	for _, consumer := range consumers {
+		stdin, err := consumer.cmd.StdinPipe()
+		if err != nil {
+			return fmt.Errorf("failed to create pipe for %s: %v", consumer.component, err)
+		}
 
-	// Create pipe for directing raspivid to save storage and HLS segments
-	inHlsSegments, outHlsSegments := io.Pipe()
-	inSaveToStorage, outSaveToStorage := io.Pipe()
-	outRaspivid := io.MultiWriter(outHlsSegments, outSaveToStorage)
+		outConsumers = append(outConsumers, stdin)
+	}
 
-	cmdRaspivid.Stdout = outRaspivid
-	cmdHlsSegments.Stdin = inHlsSegments
-	cmdSaveToStorage.Stdin = inSaveToStorage
+	// The stream is broadcast by the hub, so a stalled consumer only loses its
+	// own frames instead of blocking the source and the other consumers. The
+	// on demand consumers, e.g. WebRTC and restream, attach to it by themselves.
+	cam.hub.reset()
+	var outSource io.Writer = &cam.hub
+
+	// If there are privacy masks, the masked regions are blacked out
+	// before the stream reaches any of the consumers. Without masks
+	// the stream is not re-encoded, to keep the CPU usage low.
+	if masks := Masks(cam.DB, cam.ID); len(masks) > 0 {
+		mask := newChildProcess(cam.ID, "mask", cam.genCmdPrivacyMask(setting, masks))
+		mask.cmd.Stdout = outSource
+
+		inMask, outMask := io.Pipe()
+		mask.cmd.Stdin = inMask
+		defer outMask.Close()
+
+		consumers = append(consumers, mask)
+		outSource = outMask
+	}
 
+	// Audio is captured separately, since ALSA device
+	// can't be opened by more than one process.
+	if withAudio {
+		audio := newChildProcess(cam.ID, "audio", genCmdAudioCapture(audioSetting))
+		audio.cmd.Stdout = &audioFanout{writers: audioConsumers}
+		consumers = append(consumers, audio)
+	}
+
+	// Run child process for processing the camera streams.
+	// Make sure to kill all of them when this function finished,
+	// before detaching them from the hub.
+	var readers []*hubReader
 	defer func() {
-		outHlsSegments.Close()
-		outSaveToStorage.Close()
+		for _, reader := range readers {
+			cam.hub.detach(reader)
+		}
 	}()
 
-	// Run child process for processing the camera streams
-	err = cmdRaspivid.Start()
-	if err != nil {
-		return fmt.Errorf("fail to start raspivid: %v", err)
+	chExit := make(chan error, len(consumers)+1)
+	defer func() {
+		for _, consumer := range consumers {
+			consumer.kill()
+			releaseAudio(consumer.cmd)
+		}
+	}()
+
+	for _, consumer := range consumers {
+		err = consumer.start()
+		releaseAudio(consumer.cmd)
+		if err != nil {
+			return err
+		}
+
+		go func(consumer *childProcess) {
+			chExit <- consumer.wait()
+		}(consumer)
 	}
-	logrus.Infoln("raspivid started")
 
-	err = cmdHlsSegments.Start()
-	if err != nil {
-		return fmt.Errorf("fail to start HLS segmenter: %v", err)
+	for _, consumer := range outConsumers {
+		readers = append(readers, cam.hub.attachWriter(consumer))
 	}
-	logrus.Infoln("HLS segmenter started")
 
-	err = cmdSaveToStorage.Start()
-	if err != nil {
-		return fmt.Errorf("fail to start video saver: %v", err)
+	// Run the camera source in background. The source is reset here instead
+	// of in the goroutine, so stop request that comes early is not lost.
+	if source, ok := cam.Source.(resettableSource); ok {
+		source.reset()
 	}
-	logrus.Infoln("video saver started")
 
-	// Block until stop request received
+	chSourceExit = make(chan struct{})
+	go func() {
+		defer close(chSourceExit)
+		err := cam.Source.Start(outSource)
+		chExit <- fmt.Errorf("%s stopped: %v", cam.Source.Name(), err)
+	}()
+
+	// Block until stop request received or any process exited
 	select {
-	case <-cam.chStop:
-		cmdRaspivid.Process.Kill()
-		cmdHlsSegments.Process.Kill()
-		cmdSaveToStorage.Process.Kill()
+	case <-cam.stopChannel():
+		cam.Source.Stop()
+		err = nil
+	case err = <-chExit:
+		cam.Source.Stop()
 	}
 
-	logrus.Infoln("camera stopped")
-	return nil
-}
-
-// Stop stops the camera streams.
-func (cam *RaspiCam) Stop() {
-	cam.chStop <- struct{}{}
+	logrus.WithField("camera", cam.ID).Infoln("camera stopped")
+	return err
 }
 
-func (cam *RaspiCam) loadSetting() {
-	setting := make(map[string]string)
-	cam.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("camera"))
+// IDs returns ID of all cameras that registered in database.
+func IDs(db *bolt.DB) []string {
+	ids := []string{}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cameras"))
 		if bucket == nil {
 			return nil
 		}
 
-		bucket.ForEach(func(key, val []byte) error {
-			setting[string(key)] = string(val)
+		return bucket.ForEach(func(key, val []byte) error {
+			if val == nil {
+				ids = append(ids, string(key))
+			}
 			return nil
 		})
-
-		return nil
 	})
 
-	fps, _ := strconv.Atoi(setting["fps"])
-	rotation, _ := strconv.Atoi(setting["rotation"])
-	resolutionParts := strings.SplitN(setting["resolution"], "x", 2)
-
-	if fps <= 0 {
-		fps = 30
-	}
-
-	switch rotation {
-	case 0, 90, 180, 270:
-	default:
-		rotation = 0
-	}
-
-	width := 800
-	height := 600
-	if len(resolutionParts) == 2 {
-		width, _ = strconv.Atoi(resolutionParts[0])
-		height, _ = strconv.Atoi(resolutionParts[1])
+	return ids
+}
 
-		if width <= 0 || height <= 0 {
-			width = 800
-			height = 600
-		}
+// Bucket returns the setting bucket for camera with specified ID.
+// Returns nil if the camera doesn't exist.
+func Bucket(tx *bolt.Tx, cameraID string) *bolt.Bucket {
+	bucket := tx.Bucket([]byte("cameras"))
+	if bucket == nil {
+		return nil
 	}
 
-	cam.fps = fps
-	cam.width = width
-	cam.height = height
-	cam.rotation = rotation
+	return bucket.Bucket([]byte(cameraID))
 }
 
-func (cam *RaspiCam) genCmdRaspivid() *exec.Cmd {
-	if developmentMode {
-		return exec.Command("nc", "-l", "-p", "5000")
-	}
-
-	cmdArgs := []string{
-		"-t", "0",
-		"-b", "0",
-		"-qp", "30",
-		"-ae", "16",
-		"-a", "1036",
-		"-a", "%Y-%m-%d %X",
-		"-ex", "night",
-		"-w", strconv.Itoa(cam.width),
-		"-h", strconv.Itoa(cam.height),
-		"-fps", strconv.Itoa(cam.fps),
-		"-rot", strconv.Itoa(cam.rotation),
-		"-vs", "-o", "-"}
-
-	return exec.Command("raspivid", cmdArgs...)
-}
+func (cam *Camera) genCmdSaveToStorage(setting Setting, withAudio bool) *exec.Cmd {
+	cmdArgs := []string{"-y",
+		"-loglevel", "error",
+		"-framerate", strconv.Itoa(setting.FPS),
+		"-i", "pipe:0"}
+
+	if withAudio {
+		cmdArgs = append(cmdArgs, audioInputArgs...)
+		cmdArgs = append(cmdArgs,
+			"-map", "0:v",
+			"-map", "1:a",
+			"-bsf:a", "aac_adtstoasc")
+	}
 
-func (cam *RaspiCam) genCmdSaveToStorage() *exec.Cmd {
 	outputPath := fp.Join(cam.StorageDir, "%Y-%m-%d-%H:%M:%S.mp4")
-	return exec.Command("ffmpeg", "-y",
-		"-loglevel", "fatal",
-		"-framerate", strconv.Itoa(cam.fps),
-		"-i", "pipe:0",
+	cmdArgs = append(cmdArgs,
 		"-codec", "copy",
 		"-f", "segment",
 		"-strftime", "1",
@@ -197,23 +332,35 @@ func (cam *RaspiCam) genCmdSaveToStorage() *exec.Cmd {
 		"-segment_format", "mp4",
 		"-segment_format_options", "movflags=frag_keyframe+empty_moov",
 		outputPath)
+
+	return exec.Command("ffmpeg", cmdArgs...)
 }
 
-func (cam *RaspiCam) genCmdHlsSegments() *exec.Cmd {
+func (cam *Camera) genCmdHlsSegments(setting Setting, withAudio bool) *exec.Cmd {
+	cmdArgs := []string{"-y",
+		"-loglevel", "error",
+		"-framerate", strconv.Itoa(setting.FPS),
+		"-i", "pipe:0"}
+
+	if withAudio {
+		cmdArgs = append(cmdArgs, audioInputArgs...)
+		cmdArgs = append(cmdArgs, "-map", "0:v", "-map", "1:a")
+	} else {
+		cmdArgs = append(cmdArgs, "-map", "0")
+	}
+
 	playlistPath := fp.Join(cam.HlsSegmentsDir, "playlist.m3u8")
 	segmentPath := fp.Join(cam.HlsSegmentsDir, "%d.ts")
-	return exec.Command("ffmpeg", "-y",
-		"-loglevel", "fatal",
-		"-framerate", strconv.Itoa(cam.fps),
-		"-i", "pipe:0",
+	cmdArgs = append(cmdArgs,
 		"-codec", "copy",
-		"-bsf", "h264_mp4toannexb",
-		"-map", "0",
+		"-bsf:v", "h264_mp4toannexb",
 		"-hls_wrap", "10",
 		"-hls_list_size", "10",
-		"-hls_base_url", "/live/stream/",
+		"-hls_base_url", "/live/"+cam.ID+"/stream/",
 		"-hls_segment_filename", segmentPath,
 		"-hls_segment_type", "mpegts",
 		"-hls_flags", "delete_segments+temp_file",
 		playlistPath)
+
+	return exec.Command("ffmpeg", cmdArgs...)
 }
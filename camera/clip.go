@@ -0,0 +1,296 @@
+package camera
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	fp "path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Recording mode of camera.
+const (
+	RecordContinuous = "continuous"
+	RecordMotion     = "motion"
+	RecordBoth       = "both"
+)
+
+const (
+	defaultPreRoll  = 10 * time.Second
+	defaultPostRoll = 10 * time.Second
+	maxRoll         = time.Minute
+)
+
+// RecordingSetting is the setting for saving camera stream to storage.
+type RecordingSetting struct {
+	Mode     string
+	PreRoll  time.Duration
+	PostRoll time.Duration
+}
+
+// gop is group of pictures, i.e. NAL units starting from a keyframe
+// until before the next keyframe.
+type gop struct {
+	time time.Time
+	data []byte
+}
+
+// clipRecorder receives H.264 stream and keeps its last few seconds in memory.
+// When motion detected, it saves the buffered stream along with the following
+// stream into a video clip, until a while after the motion ended.
+type clipRecorder struct {
+	mutex      sync.Mutex
+	cameraID   string
+	storageDir string
+	fps        int
+	preRoll    time.Duration
+	postRoll   time.Duration
+	armed      func() bool
+
+	scanner nalScanner
+	tracker h264Tracker
+	gops    []gop
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  io.Closer
+	inClip  bool
+	clipEnd time.Time
+}
+
+func newClipRecorder(cameraID, storageDir string, fps int, setting RecordingSetting, armed func() bool) *clipRecorder {
+	return &clipRecorder{
+		cameraID:   cameraID,
+		storageDir: storageDir,
+		fps:        fps,
+		preRoll:    setting.PreRoll,
+		postRoll:   setting.PostRoll,
+		armed:      armed,
+	}
+}
+
+// Write receives the H.264 stream from camera source. It never returns error,
+// since failure in saving clip must not stop the other consumers.
+func (cr *clipRecorder) Write(p []byte) (int, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	cr.scanner.push(p, cr.handleNAL)
+	return len(p), nil
+}
+
+func (cr *clipRecorder) handleNAL(nal []byte) {
+	now := time.Now()
+	keyframe, ok := cr.tracker.track(nal)
+	if !ok {
+		return
+	}
+
+	if keyframe {
+		cr.gops = append(cr.gops, gop{time: now})
+
+		// Remove the old GOP, but make sure buffer always started by keyframe
+		limit := now.Add(-cr.preRoll)
+		for len(cr.gops) > 1 && !cr.gops[1].time.After(limit) {
+			cr.gops = cr.gops[1:]
+		}
+
+		// Finish the clip if post roll already passed, or the camera disarmed
+		postRollPassed := !cr.clipEnd.IsZero() && now.After(cr.clipEnd)
+		if cr.inClip && (postRollPassed || !cr.armed()) {
+			cr.finishClip()
+		}
+	}
+
+	// Stream before the first keyframe is useless
+	if len(cr.gops) == 0 {
+		return
+	}
+
+	last := &cr.gops[len(cr.gops)-1]
+	last.data = append(last.data, nal...)
+
+	if cr.inClip {
+		cr.writeClip(nal)
+	}
+}
+
+// motionStarted starts new clip, or extends the current one.
+func (cr *clipRecorder) motionStarted() {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	cr.clipEnd = time.Time{}
+	if cr.inClip || len(cr.gops) == 0 || !cr.armed() {
+		return
+	}
+
+	// Start the encoder
+	logger := logrus.WithField("camera", cr.cameraID)
+	startTime := cr.gops[0].time
+	outputPath := fp.Join(cr.storageDir, startTime.Format("2006-01-02-15:04:05")+"-motion.mp4")
+
+	cmd := exec.Command("ffmpeg", "-y",
+		"-loglevel", "error",
+		"-framerate", strconv.Itoa(cr.fps),
+		"-i", "pipe:0",
+		"-codec", "copy",
+		"-movflags", "frag_keyframe+empty_moov",
+		"-f", "mp4",
+		outputPath)
+	stderr := newStderrWriter(cr.cameraID, "clip")
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger.Warnln("failed to create clip:", err)
+		return
+	}
+
+	if err = cmd.Start(); err != nil {
+		logger.Warnln("failed to create clip:", err)
+		stderr.Close()
+		return
+	}
+
+	logger.Infoln("saving motion clip", fp.Base(outputPath))
+	cr.cmd = cmd
+	cr.stdin = stdin
+	cr.stderr = stderr
+	cr.inClip = true
+
+	// Write the pre roll
+	for _, parameterSet := range cr.tracker.parameterSets() {
+		cr.writeClip(parameterSet)
+	}
+
+	for _, g := range cr.gops {
+		cr.writeClip(g.data)
+	}
+}
+
+// motionEnded schedules the current clip to be finished after the post roll.
+// The motion event is only ended after the motion cooldown, so the post roll
+// is counted from the last motion instead of from now.
+func (cr *clipRecorder) motionEnded(lastMotion time.Time) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if cr.inClip {
+		cr.clipEnd = lastMotion.Add(cr.postRoll)
+	}
+}
+
+// Close finishes the current clip, if any.
+func (cr *clipRecorder) Close() error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	cr.finishClip()
+	return nil
+}
+
+func (cr *clipRecorder) writeClip(data []byte) {
+	if !cr.inClip {
+		return
+	}
+
+	if _, err := cr.stdin.Write(data); err != nil {
+		logrus.WithField("camera", cr.cameraID).Warnln("failed to write clip:", err)
+		cr.finishClip()
+	}
+}
+
+func (cr *clipRecorder) finishClip() {
+	if !cr.inClip {
+		return
+	}
+
+	cmd, stderr := cr.cmd, cr.stderr
+	cr.stdin.Close()
+	cr.cmd = nil
+	cr.stdin = nil
+	cr.stderr = nil
+	cr.inClip = false
+	cr.clipEnd = time.Time{}
+
+	// Let the encoder finish the file in background
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			logrus.WithField("camera", cr.cameraID).Warnln("failed to finish clip:", err)
+		}
+		stderr.Close()
+	}()
+}
+
+// validateRecordingSetting checks the recording setting before it's saved.
+func validateRecordingSetting(setting map[string]string) error {
+	switch setting["recording"] {
+	case "", RecordContinuous, RecordMotion, RecordBoth:
+	default:
+		return fmt.Errorf("recording mode %s is not supported", setting["recording"])
+	}
+
+	maxSeconds := int(maxRoll / time.Second)
+	for _, key := range []string{"preRoll", "postRoll"} {
+		if setting[key] == "" {
+			continue
+		}
+
+		if roll, err := strconv.Atoi(setting[key]); err != nil || roll < 0 || roll > maxSeconds {
+			return fmt.Errorf("%s must be between 0 and %d seconds", key, maxSeconds)
+		}
+	}
+
+	return nil
+}
+
+// loadRecordingSetting loads the recording setting of camera from database.
+func loadRecordingSetting(db *bolt.DB, cameraID string) RecordingSetting {
+	setting := RecordingSetting{
+		Mode:     RecordContinuous,
+		PreRoll:  defaultPreRoll,
+		PostRoll: defaultPostRoll,
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		switch mode := string(bucket.Get([]byte("recording"))); mode {
+		case RecordMotion, RecordBoth:
+			setting.Mode = mode
+		}
+
+		if preRoll, err := strconv.Atoi(string(bucket.Get([]byte("preRoll")))); err == nil {
+			setting.PreRoll = limitRoll(time.Duration(preRoll) * time.Second)
+		}
+
+		if postRoll, err := strconv.Atoi(string(bucket.Get([]byte("postRoll")))); err == nil {
+			setting.PostRoll = limitRoll(time.Duration(postRoll) * time.Second)
+		}
+
+		return nil
+	})
+
+	return setting
+}
+
+func limitRoll(roll time.Duration) time.Duration {
+	if roll < 0 {
+		return 0
+	}
+
+	if roll > maxRoll {
+		return maxRoll
+	}
+
+	return roll
+}
@@ -0,0 +1,102 @@
+package camera
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Type of camera event.
+const (
+	EventProfile = "profile"
+	EventArming  = "arming"
+)
+
+const (
+	eventKeyFormat = "20060102150405.000000"
+	eventRetention = 30 * 24 * time.Hour
+)
+
+// Event is something notable that happened to a camera, e.g. its profile is switched.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Camera  string    `json:"camera"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+}
+
+// RecordEvent saves new event of the camera to database.
+// The events that older than 30 days are removed.
+func RecordEvent(db *bolt.DB, cameraID, eventType, message string) {
+	logger := logrus.WithField("camera", cameraID)
+	logger.Infoln(message)
+
+	event := Event{
+		Time:    time.Now(),
+		Camera:  cameraID,
+		Type:    eventType,
+		Message: message,
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("events"))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(&event)
+		if err != nil {
+			return err
+		}
+
+		key := event.Time.UTC().Format(eventKeyFormat) + "-" + cameraID
+		if err = bucket.Put([]byte(key), value); err != nil {
+			return err
+		}
+
+		// Remove the old events
+		var oldKeys [][]byte
+		limit := time.Now().Add(-eventRetention).UTC().Format(eventKeyFormat)
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && string(key) < limit; key, _ = cursor.Next() {
+			oldKeys = append(oldKeys, key)
+		}
+
+		for _, key := range oldKeys {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logger.Warnln("failed to save event:", err)
+	}
+}
+
+// Events returns the latest events of all cameras, newest first.
+func Events(db *bolt.DB, limit int) []Event {
+	events := []Event{}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("events"))
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, val := cursor.Last(); key != nil && len(events) < limit; key, val = cursor.Prev() {
+			var event Event
+			if err := json.Unmarshal(val, &event); err == nil {
+				events = append(events, event)
+			}
+		}
+
+		return nil
+	})
+
+	return events
+}
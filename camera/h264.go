@@ -0,0 +1,319 @@
+package camera
+
+import "fmt"
+
+// Type of H.264 NAL units that needed for finding keyframes and frames.
+const (
+	nalSlice = 1
+	nalIDR   = 5
+	nalSEI   = 6
+	nalSPS   = 7
+	nalPPS   = 8
+	nalAUD   = 9
+)
+
+// nalScanner splits H.264 Annex B byte stream into NAL units.
+type nalScanner struct {
+	buf []byte
+	pos int
+}
+
+// push adds p to the stream, then calls fn for every complete NAL unit.
+// The NAL unit passed to fn still contains its start code.
+func (ns *nalScanner) push(p []byte, fn func(nal []byte)) {
+	ns.buf = append(ns.buf, p...)
+	for {
+		idx := ns.nextStartCode()
+		if idx < 0 {
+			return
+		}
+
+		fn(ns.buf[:idx])
+		ns.buf = ns.buf[idx:]
+		ns.pos = 0
+	}
+}
+
+// nextStartCode returns the position of start code after the current NAL unit.
+// Returns -1 if it's not found yet.
+func (ns *nalScanner) nextStartCode() int {
+	// Skip the start code of current NAL unit
+	start := ns.pos
+	if start < 3 {
+		start = 3
+	}
+
+	for i := start; i+2 < len(ns.buf); i++ {
+		if ns.buf[i] != 0 || ns.buf[i+1] != 0 || ns.buf[i+2] != 1 {
+			continue
+		}
+
+		// Four bytes start code
+		if ns.buf[i-1] == 0 {
+			return i - 1
+		}
+
+		return i
+	}
+
+	// Next time, continue from the last possible start code
+	ns.pos = len(ns.buf) - 2
+	return -1
+}
+
+// nalType returns type of the NAL unit and offset of its header.
+// Returns -1 if the data is not a valid NAL unit.
+func nalType(nal []byte) (int, int) {
+	for i, b := range nal {
+		if b == 0 {
+			continue
+		}
+
+		if b != 1 || i < 2 || i+1 >= len(nal) {
+			return -1, 0
+		}
+
+		return int(nal[i+1] & 0x1F), i + 1
+	}
+
+	return -1, 0
+}
+
+// isFirstSlice checks whether the slice NAL unit is the first slice of a frame,
+// i.e. its first_mb_in_slice is zero.
+func isFirstSlice(nal []byte, header int) bool {
+	return header+1 < len(nal) && nal[header+1]&0x80 != 0
+}
+
+// h264Tracker tracks H.264 stream to find the start of keyframes,
+// and keeps the latest parameter sets so the stream can be decoded
+// even when the source doesn't repeat it on every keyframe.
+type h264Tracker struct {
+	sps []byte
+	pps []byte
+
+	// inHeader is true when keyframe already started by its
+	// parameter sets, but its first slice is not received yet
+	inHeader bool
+}
+
+// track checks the NAL unit, and returns true if it's the start of new keyframe.
+// The returned ok is false if the data is not a valid NAL unit.
+func (ht *h264Tracker) track(nal []byte) (keyframe bool, ok bool) {
+	typ, header := nalType(nal)
+	if typ < 0 {
+		return false, false
+	}
+
+	// Keyframe started by its parameter sets or its first slice
+	switch typ {
+	case nalSPS:
+		ht.sps = append(ht.sps[:0], nal...)
+		keyframe = !ht.inHeader
+		ht.inHeader = true
+	case nalPPS:
+		ht.pps = append(ht.pps[:0], nal...)
+	case nalIDR:
+		keyframe = !ht.inHeader && isFirstSlice(nal, header)
+		ht.inHeader = false
+	case nalSlice:
+		ht.inHeader = false
+	}
+
+	return keyframe, true
+}
+
+// parameterSets returns the latest SPS and PPS, or nil if they're not found yet.
+func (ht *h264Tracker) parameterSets() [][]byte {
+	if len(ht.sps) == 0 || len(ht.pps) == 0 {
+		return nil
+	}
+
+	return [][]byte{ht.sps, ht.pps}
+}
+
+// frameSplitter finds the boundary of frames in H.264 stream. Since
+// the stream doesn't have any timestamp, a frame is only known ended
+// when the next one started, i.e. by its parameter sets, SEI, access
+// unit delimiter or its first slice.
+type frameSplitter struct {
+	hasSlice bool
+}
+
+// split checks whether the NAL unit is the start of new frame. The keyframe
+// is the one returned by h264Tracker for the same NAL unit.
+func (fs *frameSplitter) split(nal []byte, keyframe bool) bool {
+	typ, header := nalType(nal)
+	isSlice := typ == nalSlice || typ == nalIDR
+
+	newFrame := false
+	if fs.hasSlice {
+		switch {
+		case keyframe, typ == nalSEI, typ == nalAUD,
+			isSlice && isFirstSlice(nal, header):
+			newFrame = true
+			fs.hasSlice = false
+		}
+	}
+
+	fs.hasSlice = fs.hasSlice || isSlice
+	return newFrame
+}
+
+// spsSize returns the frame size that written in SPS. The SPS given
+// without its start code, i.e. started by its NAL header.
+func spsSize(sps []byte) (int, int, error) {
+	// Remove the emulation prevention bytes
+	rbsp := make([]byte, 0, len(sps))
+	for i := 1; i < len(sps); i++ {
+		if i >= 3 && sps[i] == 3 && sps[i-1] == 0 && sps[i-2] == 0 {
+			continue
+		}
+		rbsp = append(rbsp, sps[i])
+	}
+
+	br := &bitReader{data: rbsp}
+	profile := br.bits(8)
+	br.bits(16) // constraint flags and level
+	br.ue()     // seq_parameter_set_id
+
+	chromaFormat := uint(1)
+	separateColourPlane := uint(0)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = br.ue()
+		if chromaFormat == 3 {
+			separateColourPlane = br.bits(1)
+		}
+
+		br.ue()    // bit_depth_luma_minus8
+		br.ue()    // bit_depth_chroma_minus8
+		br.bits(1) // qpprime_y_zero_transform_bypass_flag
+
+		if br.bits(1) == 1 {
+			nLists := 8
+			if chromaFormat == 3 {
+				nLists = 12
+			}
+
+			for i := 0; i < nLists; i++ {
+				if br.bits(1) == 0 {
+					continue
+				}
+
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+
+				lastScale, nextScale := 8, 8
+				for j := 0; j < size && nextScale != 0; j++ {
+					nextScale = (lastScale + br.se() + 256) % 256
+					if nextScale != 0 {
+						lastScale = nextScale
+					}
+				}
+			}
+		}
+	}
+
+	br.ue() // log2_max_frame_num_minus4
+	switch br.ue() {
+	case 0:
+		br.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		br.bits(1) // delta_pic_order_always_zero_flag
+		br.se()    // offset_for_non_ref_pic
+		br.se()    // offset_for_top_to_bottom_field
+		for n := br.ue(); n > 0 && br.err == nil; n-- {
+			br.se()
+		}
+	}
+
+	br.ue()    // max_num_ref_frames
+	br.bits(1) // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := int(br.ue()) + 1
+	heightInMapUnits := int(br.ue()) + 1
+	frameMbsOnly := int(br.bits(1))
+	if frameMbsOnly == 0 {
+		br.bits(1) // mb_adaptive_frame_field_flag
+	}
+	br.bits(1) // direct_8x8_inference_flag
+
+	width := widthInMbs * 16
+	height := (2 - frameMbsOnly) * heightInMapUnits * 16
+	if br.bits(1) == 1 {
+		cropLeft, cropRight := int(br.ue()), int(br.ue())
+		cropTop, cropBottom := int(br.ue()), int(br.ue())
+
+		cropUnitX, cropUnitY := 1, 2-frameMbsOnly
+		if chromaFormat != 0 && separateColourPlane == 0 {
+			if chromaFormat != 3 {
+				cropUnitX = 2
+			}
+			if chromaFormat == 1 {
+				cropUnitY *= 2
+			}
+		}
+
+		width -= (cropLeft + cropRight) * cropUnitX
+		height -= (cropTop + cropBottom) * cropUnitY
+	}
+
+	if br.err != nil {
+		return 0, 0, br.err
+	}
+
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid frame size %dx%d", width, height)
+	}
+
+	return width, height, nil
+}
+
+// bitReader reads bits and Exp-Golomb codes that used in H.264 headers.
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (br *bitReader) bits(n int) uint {
+	var val uint
+	for i := 0; i < n; i++ {
+		if br.pos >= len(br.data)*8 {
+			br.err = fmt.Errorf("unexpected end of data")
+			return 0
+		}
+
+		bit := br.data[br.pos/8] >> (7 - uint(br.pos%8)) & 1
+		val = val<<1 | uint(bit)
+		br.pos++
+	}
+
+	return val
+}
+
+// ue reads unsigned Exp-Golomb code.
+func (br *bitReader) ue() uint {
+	leadingZeros := 0
+	for br.bits(1) == 0 {
+		if br.err != nil || leadingZeros >= 32 {
+			br.err = fmt.Errorf("invalid exp-golomb code")
+			return 0
+		}
+		leadingZeros++
+	}
+
+	return 1<<uint(leadingZeros) - 1 + br.bits(leadingZeros)
+}
+
+// se reads signed Exp-Golomb code.
+func (br *bitReader) se() int {
+	val := br.ue()
+	if val%2 == 0 {
+		return -int(val / 2)
+	}
+
+	return int(val+1) / 2
+}
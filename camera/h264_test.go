@@ -0,0 +1,442 @@
+package camera
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// NAL units used in tests, started by four bytes start code.
+var (
+	testAUD       = testNAL(0x09, 0xf0)
+	testSEI       = testNAL(0x06, 0x05, 0x80)
+	testSPS       = testNAL(0x67, 0x42, 0xe0, 0x1f, 0xda)
+	testPPS       = testNAL(0x68, 0xce, 0x38, 0x80)
+	testIDR       = testNAL(0x65, 0x88, 0x84)
+	testIDRNext   = testNAL(0x65, 0x40, 0x84)
+	testSlice     = testNAL(0x41, 0x9a, 0x02)
+	testSliceNext = testNAL(0x41, 0x40, 0x02)
+)
+
+func testNAL(header byte, payload ...byte) []byte {
+	return append([]byte{0, 0, 0, 1, header}, payload...)
+}
+
+// shortStartCode returns the NAL unit with three bytes start code.
+func shortStartCode(nal []byte) []byte {
+	return nal[1:]
+}
+
+func TestNALScanner(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{{
+		name:   "four bytes start codes",
+		chunks: []string{"\x00\x00\x00\x01\x09\xf0\x00\x00\x00\x01\x67\x42\x00\x00\x00\x01\x68\xce"},
+		want:   []string{"\x00\x00\x00\x01\x09\xf0", "\x00\x00\x00\x01\x67\x42"},
+	}, {
+		name:   "three bytes start codes",
+		chunks: []string{"\x00\x00\x01\x09\xf0\x00\x00\x01\x67\x42\x00\x00\x01\x68\xce"},
+		want:   []string{"\x00\x00\x01\x09\xf0", "\x00\x00\x01\x67\x42"},
+	}, {
+		name:   "mixed start codes",
+		chunks: []string{"\x00\x00\x00\x01\x67\x42\x00\x00\x01\x68\xce\x00\x00\x00\x01\x65\x88"},
+		want:   []string{"\x00\x00\x00\x01\x67\x42", "\x00\x00\x01\x68\xce"},
+	}, {
+		name:   "start code split across writes",
+		chunks: []string{"\x00\x00\x00\x01\x09\xf0\x00", "\x00", "\x00\x01\x67\x42\x00\x00", "\x01\x68"},
+		want:   []string{"\x00\x00\x00\x01\x09\xf0", "\x00\x00\x00\x01\x67\x42"},
+	}, {
+		name:   "one byte per write",
+		chunks: strings.Split("\x00\x00\x00\x01\x09\xf0\x00\x00\x01\x67\x42\x00\x00\x00\x01\x68", ""),
+		want:   []string{"\x00\x00\x00\x01\x09\xf0", "\x00\x00\x01\x67\x42"},
+	}, {
+		name:   "incomplete NAL unit",
+		chunks: []string{"\x00\x00\x00\x01\x09\xf0\x00\x00"},
+		want:   nil,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var ns nalScanner
+			for _, chunk := range tt.chunks {
+				ns.push([]byte(chunk), func(nal []byte) {
+					got = append(got, string(nal))
+				})
+			}
+
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNALType(t *testing.T) {
+	tests := []struct {
+		name       string
+		nal        []byte
+		wantType   int
+		wantHeader int
+	}{
+		{"four bytes start code", testSPS, nalSPS, 4},
+		{"three bytes start code", shortStartCode(testIDR), nalIDR, 3},
+		{"without start code", []byte{0x67, 0x42}, -1, 0},
+		{"start code only", []byte{0, 0, 1}, -1, 0},
+		{"empty", nil, -1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, header := nalType(tt.nal)
+			if typ != tt.wantType || header != tt.wantHeader {
+				t.Errorf("got (%d, %d), want (%d, %d)", typ, header, tt.wantType, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestH264Tracker(t *testing.T) {
+	tests := []struct {
+		name string
+		nals [][]byte
+		want []bool
+	}{{
+		name: "keyframe started by parameter sets",
+		nals: [][]byte{testSPS, testPPS, testIDR, testSlice, testSPS, testPPS, testIDR},
+		want: []bool{true, false, false, false, true, false, false},
+	}, {
+		name: "keyframe without parameter sets",
+		nals: [][]byte{testIDR, testIDRNext, testSlice, testIDR},
+		want: []bool{true, false, false, true},
+	}, {
+		name: "repeated SPS before the slice",
+		nals: [][]byte{testSPS, testSPS, testPPS, testIDR},
+		want: []bool{true, false, false, false},
+	}, {
+		name: "non IDR slices",
+		nals: [][]byte{testAUD, testSEI, testSlice, testSliceNext},
+		want: []bool{false, false, false, false},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ht h264Tracker
+			for i, nal := range tt.nals {
+				keyframe, ok := ht.track(nal)
+				if !ok || keyframe != tt.want[i] {
+					t.Errorf("NAL %d: got (%v, %v), want (%v, true)", i, keyframe, ok, tt.want[i])
+				}
+			}
+		})
+	}
+
+	t.Run("invalid NAL unit", func(t *testing.T) {
+		var ht h264Tracker
+		if _, ok := ht.track([]byte{0x41, 0x9a}); ok {
+			t.Error("NAL unit without start code is accepted")
+		}
+	})
+
+	t.Run("parameter sets", func(t *testing.T) {
+		var ht h264Tracker
+		ht.track(testSPS)
+		if ps := ht.parameterSets(); ps != nil {
+			t.Errorf("got %x before PPS received, want nil", ps)
+		}
+
+		ht.track(testPPS)
+		ps := ht.parameterSets()
+		if len(ps) != 2 || !bytes.Equal(ps[0], testSPS) || !bytes.Equal(ps[1], testPPS) {
+			t.Errorf("got %x, want SPS and PPS", ps)
+		}
+	})
+}
+
+func TestFrameSplitter(t *testing.T) {
+	tests := []struct {
+		name string
+		nals [][]byte
+		want []bool
+	}{{
+		name: "access unit delimiter",
+		nals: [][]byte{testAUD, testSPS, testPPS, testIDR, testAUD, testSlice, testAUD, testSlice},
+		want: []bool{false, false, false, false, true, false, true, false},
+	}, {
+		name: "SEI",
+		nals: [][]byte{testSPS, testPPS, testSEI, testIDR, testSEI, testSlice, testSEI, testSlice},
+		want: []bool{false, false, false, false, true, false, true, false},
+	}, {
+		name: "first slice without delimiter",
+		nals: [][]byte{testSPS, testPPS, testIDR, testSlice, testSlice},
+		want: []bool{false, false, false, true, true},
+	}, {
+		name: "multiple slices per frame",
+		nals: [][]byte{testIDR, testIDRNext, testSlice, testSliceNext, testSlice},
+		want: []bool{false, false, true, false, true},
+	}, {
+		name: "parameter sets after slice",
+		nals: [][]byte{testSlice, testSPS, testPPS, testIDR, testSlice},
+		want: []bool{false, true, false, false, true},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ht h264Tracker
+			var fs frameSplitter
+			for i, nal := range tt.nals {
+				keyframe, _ := ht.track(nal)
+				if got := fs.split(nal, keyframe); got != tt.want[i] {
+					t.Errorf("NAL %d: got %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSPSSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		sps        testSPSFields
+		wantWidth  int
+		wantHeight int
+	}{{
+		name:       "baseline 1280x720",
+		sps:        testSPSFields{profile: 66, widthInMbs: 80, heightInMapUnits: 45, frameMbsOnly: true},
+		wantWidth:  1280,
+		wantHeight: 720,
+	}, {
+		name: "baseline 1920x1080 with cropping",
+		sps: testSPSFields{profile: 66, widthInMbs: 120, heightInMapUnits: 68, frameMbsOnly: true,
+			crop: []uint{0, 0, 0, 4}},
+		wantWidth:  1920,
+		wantHeight: 1080,
+	}, {
+		name: "high 640x480 with scaling matrix",
+		sps: testSPSFields{profile: 100, chromaFormat: 1, scalingMatrix: true,
+			pocType: 1, widthInMbs: 40, heightInMapUnits: 30, frameMbsOnly: true},
+		wantWidth:  640,
+		wantHeight: 480,
+	}, {
+		name: "high interlaced 720x576",
+		sps: testSPSFields{profile: 100, chromaFormat: 1, pocType: 2,
+			widthInMbs: 45, heightInMapUnits: 18},
+		wantWidth:  720,
+		wantHeight: 576,
+	}, {
+		name: "emulation prevention bytes",
+		sps: testSPSFields{profile: 66, spsID: 1, widthInMbs: 4096, heightInMapUnits: 4096,
+			frameMbsOnly: true},
+		wantWidth:  65536,
+		wantHeight: 65536,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, err := spsSize(tt.sps.encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("got %dx%d, want %dx%d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+
+	t.Run("emulation prevention bytes is used", func(t *testing.T) {
+		sps := tests[len(tests)-1].sps.encode()
+		if !bytes.Contains(sps, []byte{0, 0, 3}) {
+			t.Errorf("SPS %x doesn't contain emulation prevention bytes", sps)
+		}
+	})
+
+	t.Run("truncated SPS", func(t *testing.T) {
+		sps := testSPSFields{profile: 66, widthInMbs: 80, heightInMapUnits: 45, frameMbsOnly: true}.encode()
+		if _, _, err := spsSize(sps[:5]); err == nil {
+			t.Error("truncated SPS is accepted")
+		}
+	})
+}
+
+func TestBitReader(t *testing.T) {
+	tests := []struct {
+		bits    string
+		wantUE  uint
+		wantSE  int
+		wantErr bool
+	}{
+		{bits: "1", wantUE: 0, wantSE: 0},
+		{bits: "010", wantUE: 1, wantSE: 1},
+		{bits: "011", wantUE: 2, wantSE: -1},
+		{bits: "00100", wantUE: 3, wantSE: 2},
+		{bits: "00101", wantUE: 4, wantSE: -2},
+		{bits: "0001000", wantUE: 7, wantSE: 4},
+		{bits: "00000000", wantErr: true},
+		{bits: "00000001", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bits, func(t *testing.T) {
+			var bw testBitWriter
+			for _, bit := range tt.bits {
+				bw.bits(uint(bit-'0'), 1)
+			}
+
+			br := &bitReader{data: bw.data}
+			ue := br.ue()
+			if tt.wantErr {
+				if br.err == nil {
+					t.Errorf("got %d, want error", ue)
+				}
+				return
+			}
+
+			if br.err != nil || ue != tt.wantUE {
+				t.Errorf("ue: got (%d, %v), want %d", ue, br.err, tt.wantUE)
+			}
+
+			br = &bitReader{data: bw.data}
+			if se := br.se(); br.err != nil || se != tt.wantSE {
+				t.Errorf("se: got (%d, %v), want %d", se, br.err, tt.wantSE)
+			}
+		})
+	}
+}
+
+// testSPSFields is the fields of SPS that affect its frame size.
+type testSPSFields struct {
+	profile          uint
+	spsID            uint
+	chromaFormat     uint
+	scalingMatrix    bool
+	pocType          uint
+	widthInMbs       uint
+	heightInMapUnits uint
+	frameMbsOnly     bool
+	crop             []uint
+}
+
+// encode returns the SPS as NAL unit without start code.
+func (f testSPSFields) encode() []byte {
+	var bw testBitWriter
+	bw.bits(f.profile, 8)
+	bw.bits(0x001f, 16) // constraint flags and level
+	bw.ue(f.spsID)
+
+	if f.profile == 100 {
+		bw.ue(f.chromaFormat)
+		bw.ue(0)      // bit_depth_luma_minus8
+		bw.ue(0)      // bit_depth_chroma_minus8
+		bw.bits(0, 1) // qpprime_y_zero_transform_bypass_flag
+
+		if !f.scalingMatrix {
+			bw.bits(0, 1)
+		} else {
+			// First list is explicitly set to flat, the others use default
+			bw.bits(1, 1)
+			bw.bits(1, 1)
+			bw.se(8)
+			for i := 1; i < 16; i++ {
+				bw.se(0)
+			}
+			for i := 1; i < 8; i++ {
+				bw.bits(0, 1)
+			}
+		}
+	}
+
+	bw.ue(0) // log2_max_frame_num_minus4
+	bw.ue(f.pocType)
+	switch f.pocType {
+	case 0:
+		bw.ue(0) // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		bw.bits(0, 1) // delta_pic_order_always_zero_flag
+		bw.se(-1)     // offset_for_non_ref_pic
+		bw.se(1)      // offset_for_top_to_bottom_field
+		bw.ue(2)      // num_ref_frames_in_pic_order_cnt_cycle
+		bw.se(3)
+		bw.se(-3)
+	}
+
+	bw.ue(1)      // max_num_ref_frames
+	bw.bits(0, 1) // gaps_in_frame_num_value_allowed_flag
+	bw.ue(f.widthInMbs - 1)
+	bw.ue(f.heightInMapUnits - 1)
+	if f.frameMbsOnly {
+		bw.bits(1, 1)
+	} else {
+		bw.bits(0, 2)
+	}
+	bw.bits(1, 1) // direct_8x8_inference_flag
+
+	if len(f.crop) == 0 {
+		bw.bits(0, 1)
+	} else {
+		bw.bits(1, 1)
+		for _, offset := range f.crop {
+			bw.ue(offset)
+		}
+	}
+
+	bw.bits(0, 1) // vui_parameters_present_flag
+	bw.bits(1, 1) // rbsp_stop_one_bit
+
+	// Add the NAL header and emulation prevention bytes
+	nal := []byte{0x67}
+	zeros := 0
+	for _, b := range bw.data {
+		if zeros >= 2 && b <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+
+		nal = append(nal, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return nal
+}
+
+// testBitWriter writes bits and Exp-Golomb codes, the reverse of bitReader.
+type testBitWriter struct {
+	data []byte
+	pos  int
+}
+
+func (bw *testBitWriter) bits(val uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if bw.pos%8 == 0 {
+			bw.data = append(bw.data, 0)
+		}
+
+		bw.data[len(bw.data)-1] |= byte(val>>uint(i)&1) << (7 - uint(bw.pos%8))
+		bw.pos++
+	}
+}
+
+func (bw *testBitWriter) ue(val uint) {
+	n := 0
+	for (val+1)>>uint(n+1) != 0 {
+		n++
+	}
+
+	bw.bits(0, n)
+	bw.bits(val+1, n+1)
+}
+
+func (bw *testBitWriter) se(val int) {
+	if val > 0 {
+		bw.ue(uint(2*val - 1))
+	} else {
+		bw.ue(uint(-2 * val))
+	}
+}
@@ -0,0 +1,267 @@
+package camera
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const hubQueueSize = 90
+
+// accessUnit is a frame of H.264 stream, i.e. all NAL units that belong
+// to the same picture. The NAL units still contain their start code.
+type accessUnit struct {
+	nals     [][]byte
+	keyframe bool
+}
+
+// data returns the access unit as H.264 Annex B byte stream.
+func (au *accessUnit) data() []byte {
+	return bytes.Join(au.nals, nil)
+}
+
+// writeTo writes the NAL units of access unit into w.
+func (au *accessUnit) writeTo(w io.Writer) error {
+	for _, nal := range au.nals {
+		if _, err := w.Write(nal); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// streamHub broadcasts H.264 stream from camera source to its consumers.
+// The stream is split into access units, and every consumer has its own
+// bounded queue, so a stalled consumer never blocks the source or the
+// other consumers. Consumers can be attached and detached anytime, and
+// each of them receives the stream started from a keyframe, preceded by
+// the parameter sets.
+type streamHub struct {
+	mutex    sync.Mutex
+	scanner  nalScanner
+	tracker  h264Tracker
+	splitter frameSplitter
+	current  *accessUnit
+	readers  map[*hubReader]struct{}
+
+	// The latest keyframe, numbered so its
+	// user knows when it's replaced
+	keyframe     *accessUnit
+	keyframeSeq  uint64
+	keyframeTime time.Time
+}
+
+// hubReader receives access units from stream hub. If it can't keep up with
+// the stream, the access units will be dropped until the next keyframe.
+type hubReader struct {
+	chUnit  chan *accessUnit
+	chDone  chan struct{}
+	started bool
+}
+
+// Write receives the H.264 stream from camera source. It never blocks,
+// so slow consumer doesn't affect the others.
+func (sh *streamHub) Write(p []byte) (int, error) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.scanner.push(p, sh.handleNAL)
+	return len(p), nil
+}
+
+func (sh *streamHub) handleNAL(nal []byte) {
+	keyframe, ok := sh.tracker.track(nal)
+	if !ok {
+		return
+	}
+
+	// Access unit is only known complete when the next one started
+	if sh.splitter.split(nal, keyframe) && sh.current != nil {
+		sh.broadcast(sh.current)
+		sh.current = nil
+	}
+
+	if sh.current == nil {
+		sh.current = &accessUnit{}
+	}
+
+	// Parameter sets might be sent before non IDR slice, so the
+	// access unit is only a keyframe if it contains IDR slice
+	typ, _ := nalType(nal)
+	sh.current.nals = append(sh.current.nals, append([]byte(nil), nal...))
+	sh.current.keyframe = sh.current.keyframe || typ == nalIDR
+}
+
+func (sh *streamHub) broadcast(unit *accessUnit) {
+	// Make sure keyframe is decodable by itself, since
+	// the source might not repeat the parameter sets
+	var keyframe *accessUnit
+	if unit.keyframe {
+		keyframe = sh.withParameterSets(unit)
+		if keyframe != nil {
+			sh.keyframe = keyframe
+			sh.keyframeSeq++
+			sh.keyframeTime = time.Now()
+		}
+	}
+
+	for reader := range sh.readers {
+		if !reader.started {
+			if keyframe == nil {
+				continue
+			}
+
+			reader.started = true
+			reader.send(keyframe)
+			continue
+		}
+
+		reader.send(unit)
+	}
+}
+
+// withParameterSets returns the keyframe started by the latest parameter sets,
+// or nil if they're not found yet.
+func (sh *streamHub) withParameterSets(unit *accessUnit) *accessUnit {
+	parameterSets := sh.tracker.parameterSets()
+	if len(parameterSets) == 0 {
+		return nil
+	}
+
+	// The parameter sets must be put after access unit delimiter, if any
+	pos := 0
+	for i, nal := range unit.nals {
+		switch typ, _ := nalType(nal); typ {
+		case nalSPS:
+			return unit
+		case nalAUD:
+			if i == 0 {
+				pos = 1
+			}
+		}
+	}
+
+	nals := append([][]byte(nil), unit.nals[:pos]...)
+	for _, parameterSet := range parameterSets {
+		nals = append(nals, append([]byte(nil), parameterSet...))
+	}
+
+	return &accessUnit{
+		nals:     append(nals, unit.nals[pos:]...),
+		keyframe: true,
+	}
+}
+
+// reset clears the parsing state, e.g. when the source restarted, so the new
+// stream is not mixed with the leftover of the old one. The attached readers
+// will continue from the next keyframe of the new stream.
+func (sh *streamHub) reset() {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.scanner = nalScanner{}
+	sh.tracker = h264Tracker{}
+	sh.splitter = frameSplitter{}
+	sh.current = nil
+	sh.keyframe = nil
+
+	for reader := range sh.readers {
+		reader.started = false
+	}
+}
+
+// attach registers new reader to the hub.
+func (sh *streamHub) attach() *hubReader {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if sh.readers == nil {
+		sh.readers = make(map[*hubReader]struct{})
+	}
+
+	reader := &hubReader{chUnit: make(chan *accessUnit, hubQueueSize)}
+	sh.readers[reader] = struct{}{}
+	return reader
+}
+
+// attachWriter registers new reader that writes the stream into w in background.
+// If w returns error, the rest of stream is discarded until it's detached.
+func (sh *streamHub) attachWriter(w io.Writer) *hubReader {
+	reader := sh.attach()
+	reader.chDone = make(chan struct{})
+
+	go func() {
+		defer close(reader.chDone)
+
+		var err error
+		for unit := range reader.chUnit {
+			if err == nil {
+				err = unit.writeTo(w)
+			}
+		}
+	}()
+
+	return reader
+}
+
+// detach removes the reader from the hub, then closes its channel. For reader
+// created by attachWriter, it waits until the pending writes finished.
+func (sh *streamHub) detach(reader *hubReader) {
+	sh.mutex.Lock()
+	if _, exist := sh.readers[reader]; exist {
+		delete(sh.readers, reader)
+		close(reader.chUnit)
+	}
+	sh.mutex.Unlock()
+
+	if reader.chDone != nil {
+		<-reader.chDone
+	}
+}
+
+// latestKeyframe returns the latest keyframe of live stream as H.264 Annex B
+// byte stream, preceded by its parameter sets, along with its sequence number
+// and the time it's received. Returns nil if there are none yet.
+func (sh *streamHub) latestKeyframe() ([]byte, uint64, time.Time) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if sh.keyframe == nil {
+		return nil, 0, time.Time{}
+	}
+
+	return sh.keyframe.data(), sh.keyframeSeq, sh.keyframeTime
+}
+
+// profileLevelID returns the H.264 profile and level of stream as hex string,
+// which taken from the latest SPS. It's used for SDP, e.g. "42e01f".
+func (sh *streamHub) profileLevelID() (string, error) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	parameterSets := sh.tracker.parameterSets()
+	if len(parameterSets) == 0 {
+		return "", fmt.Errorf("live stream is not available yet")
+	}
+
+	sps := parameterSets[0]
+	_, header := nalType(sps)
+	if header+4 > len(sps) {
+		return "", fmt.Errorf("invalid SPS")
+	}
+
+	return hex.EncodeToString(sps[header+1 : header+4]), nil
+}
+
+func (hr *hubReader) send(unit *accessUnit) {
+	select {
+	case hr.chUnit <- unit:
+	default:
+		// Reader is too slow, wait for the next keyframe
+		hr.started = false
+	}
+}
@@ -0,0 +1,285 @@
+package camera
+
+import (
+	"bytes"
+	"testing"
+)
+
+func joinNALs(nals ...[]byte) []byte {
+	return bytes.Join(nals, nil)
+}
+
+// writeChunks writes the stream into hub, split into chunks with the specified size.
+func writeChunks(sh *streamHub, stream []byte, size int) {
+	for len(stream) > 0 {
+		n := size
+		if n > len(stream) {
+			n = len(stream)
+		}
+
+		sh.Write(stream[:n])
+		stream = stream[n:]
+	}
+}
+
+// receiveUnits returns the access units that already queued for the reader.
+func receiveUnits(reader *hubReader) [][]byte {
+	var units [][]byte
+	for {
+		select {
+		case unit := <-reader.chUnit:
+			units = append(units, unit.data())
+		default:
+			return units
+		}
+	}
+}
+
+func TestStreamHubSplitAccessUnits(t *testing.T) {
+	// Access unit is only complete when the next one started, so the
+	// last two delimiters are used to flush the final frame from hub
+	withAUD := joinNALs(
+		testAUD, testSPS, testPPS, testIDR,
+		testAUD, testSlice,
+		testAUD, testSlice, testSliceNext,
+		testAUD, testAUD)
+	wantWithAUD := [][]byte{
+		joinNALs(testAUD, testSPS, testPPS, testIDR),
+		joinNALs(testAUD, testSlice),
+		joinNALs(testAUD, testSlice, testSliceNext),
+	}
+
+	withSEI := joinNALs(
+		testSEI, testSPS, testPPS, testIDR,
+		testSEI, testSlice,
+		testSEI, testSEI)
+	wantWithSEI := [][]byte{
+		joinNALs(testSEI, testSPS, testPPS, testIDR),
+		joinNALs(testSEI, testSlice),
+	}
+
+	short := shortStartCode
+	withShortStartCode := joinNALs(
+		testSPS, short(testPPS), short(testIDR),
+		short(testSlice), testSlice,
+		short(testAUD), short(testAUD))
+	wantWithShortStartCode := [][]byte{
+		joinNALs(testSPS, short(testPPS), short(testIDR)),
+		short(testSlice),
+		testSlice,
+	}
+
+	tests := []struct {
+		name      string
+		stream    []byte
+		chunkSize int
+		want      [][]byte
+	}{
+		{"delimited by AUD", withAUD, len(withAUD), wantWithAUD},
+		{"delimited by AUD, one byte per write", withAUD, 1, wantWithAUD},
+		{"delimited by AUD, split inside start code", withAUD, 3, wantWithAUD},
+		{"delimited by SEI", withSEI, len(withSEI), wantWithSEI},
+		{"delimited by SEI, split inside start code", withSEI, 7, wantWithSEI},
+		{"three bytes start codes", withShortStartCode, len(withShortStartCode), wantWithShortStartCode},
+		{"three bytes start codes, one byte per write", withShortStartCode, 1, wantWithShortStartCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sh streamHub
+			reader := sh.attach()
+			writeChunks(&sh, tt.stream, tt.chunkSize)
+
+			got := receiveUnits(reader)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d access units, want %d: %x", len(got), len(tt.want), got)
+			}
+
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("access unit %d: got %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStreamHubLateJoiner(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyframe  []byte
+		wantFirst []byte
+	}{{
+		name:      "parameter sets not repeated",
+		keyframe:  testIDR,
+		wantFirst: joinNALs(testSPS, testPPS, testIDR),
+	}, {
+		name:      "parameter sets not repeated, with AUD",
+		keyframe:  joinNALs(testAUD, testIDR),
+		wantFirst: joinNALs(testAUD, testSPS, testPPS, testIDR),
+	}, {
+		name:      "parameter sets repeated",
+		keyframe:  joinNALs(testSPS, testPPS, testIDR),
+		wantFirst: joinNALs(testSPS, testPPS, testIDR),
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sh streamHub
+			sh.Write(joinNALs(testSPS, testPPS, testIDR, testSlice, testSlice))
+
+			// Reader attached in the middle of GOP must wait for the next keyframe
+			reader := sh.attach()
+			sh.Write(joinNALs(testSlice, testSlice))
+			if got := receiveUnits(reader); len(got) != 0 {
+				t.Fatalf("got %x before keyframe, want nothing", got)
+			}
+
+			sh.Write(joinNALs(tt.keyframe, testSlice, testSlice, testSlice))
+			got := receiveUnits(reader)
+			want := [][]byte{tt.wantFirst, testSlice}
+			if len(got) != len(want) || !bytes.Equal(got[0], want[0]) || !bytes.Equal(got[1], want[1]) {
+				t.Errorf("got %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestStreamHubSlowReader(t *testing.T) {
+	var sh streamHub
+	reader := sh.attach()
+	sh.Write(joinNALs(testSPS, testPPS, testIDR))
+
+	// Overflow the queue, so the reader is dropped until the next keyframe
+	for i := 0; i < hubQueueSize+10; i++ {
+		sh.Write(testSlice)
+	}
+
+	got := receiveUnits(reader)
+	if len(got) != hubQueueSize {
+		t.Fatalf("got %d access units, want %d", len(got), hubQueueSize)
+	}
+
+	if !bytes.Equal(got[0], joinNALs(testSPS, testPPS, testIDR)) {
+		t.Errorf("first access unit is %x, want keyframe", got[0])
+	}
+
+	// The reader has caught up, but the P-frames are useless without
+	// the dropped frames, so it only resumes from the next keyframe
+	sh.Write(joinNALs(testSlice, testSlice))
+	if got := receiveUnits(reader); len(got) != 0 {
+		t.Fatalf("got %d access units before keyframe, want nothing", len(got))
+	}
+
+	sh.Write(joinNALs(testIDR, testSlice, testSlice, testSlice))
+	got = receiveUnits(reader)
+	want := [][]byte{joinNALs(testSPS, testPPS, testIDR), testSlice}
+	if len(got) != len(want) || !bytes.Equal(got[0], want[0]) || !bytes.Equal(got[1], want[1]) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	// Other readers are not affected by the slow one
+	fast := sh.attach()
+	sh.Write(joinNALs(testIDR, testSlice))
+	for i := 0; i < hubQueueSize+10; i++ {
+		receiveUnits(fast)
+		sh.Write(testSlice)
+	}
+
+	if got := receiveUnits(fast); len(got) != 1 {
+		t.Errorf("fast reader got %d access units, want 1", len(got))
+	}
+}
+
+func TestStreamHubDetach(t *testing.T) {
+	var sh streamHub
+	var buf bytes.Buffer
+	reader := sh.attachWriter(&buf)
+	sh.Write(joinNALs(testSPS, testPPS, testIDR, testSlice, testSlice, testSlice))
+
+	// Detach waits until the queued access units are written
+	sh.detach(reader)
+	want := joinNALs(testSPS, testPPS, testIDR, testSlice)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", buf.Bytes(), want)
+	}
+
+	sh.Write(joinNALs(testSlice, testSlice))
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("detached reader still receives stream: %x", buf.Bytes())
+	}
+}
+
+func TestLatestKeyframe(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  []byte
+		want    []byte
+		wantSeq uint64
+	}{{
+		name:   "no stream",
+		stream: nil,
+		want:   nil,
+	}, {
+		name:   "before the first IDR",
+		stream: joinNALs(testSPS, testPPS, testSlice, testSlice, testSlice),
+		want:   nil,
+	}, {
+		name:   "IDR without parameter sets",
+		stream: joinNALs(testIDR, testSlice, testSlice),
+		want:   nil,
+	}, {
+		name:   "incomplete IDR",
+		stream: joinNALs(testSPS, testPPS, testIDR),
+		want:   nil,
+	}, {
+		name:    "complete IDR",
+		stream:  joinNALs(testSPS, testPPS, testIDR, testSlice, testSlice),
+		want:    joinNALs(testSPS, testPPS, testIDR),
+		wantSeq: 1,
+	}, {
+		name:    "latest of several IDR",
+		stream:  joinNALs(testSPS, testPPS, testIDR, testSlice, testIDR, testIDRNext, testSlice, testSlice),
+		want:    joinNALs(testSPS, testPPS, testIDR, testIDRNext),
+		wantSeq: 2,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sh streamHub
+			sh.Write(tt.stream)
+			got, gotSeq, _ := sh.latestKeyframe()
+			if !bytes.Equal(got, tt.want) || gotSeq != tt.wantSeq {
+				t.Errorf("got (%x, %d), want (%x, %d)", got, gotSeq, tt.want, tt.wantSeq)
+			}
+		})
+	}
+
+	t.Run("after reset", func(t *testing.T) {
+		var sh streamHub
+		sh.Write(joinNALs(testSPS, testPPS, testIDR, testSlice, testSlice))
+		sh.reset()
+		if got, _, _ := sh.latestKeyframe(); got != nil {
+			t.Errorf("got %x, want nil", got)
+		}
+
+		// Sequence keeps increasing, so the old keyframe is never mistaken as the new one
+		sh.Write(joinNALs(testSPS, testPPS, testIDR, testSlice, testSlice))
+		if _, gotSeq, _ := sh.latestKeyframe(); gotSeq != 2 {
+			t.Errorf("got sequence %d, want 2", gotSeq)
+		}
+	})
+}
+
+func TestProfileLevelID(t *testing.T) {
+	var sh streamHub
+	if _, err := sh.profileLevelID(); err == nil {
+		t.Error("profile level ID returned before SPS received")
+	}
+
+	sh.Write(joinNALs(testSPS, testPPS, testIDR))
+	got, err := sh.profileLevelID()
+	if err != nil || got != "42e01f" {
+		t.Errorf("got (%q, %v), want 42e01f", got, err)
+	}
+}
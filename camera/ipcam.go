@@ -0,0 +1,413 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	nurl "net/url"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// IPCam is source for IP camera that serves H.264 stream over RTSP or HTTP.
+// Both are received directly, then HTTP stream is demuxed using ffmpeg.
+// If the stream dropped, it will be reconnected until the source stopped.
+//
+// The stream URL and its credentials are saved in the camera bucket, in key
+// "url", "username" and "password". For testing, any RTSP server can be used
+// as the camera, e.g. mediamtx that fed by ffmpeg :
+//
+//	ffmpeg -re -stream_loop -1 -i video.mp4 -c copy -f rtsp rtsp://localhost:8554/cam
+type IPCam struct {
+	DB       *bolt.DB
+	CameraID string
+
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	client *gortsplib.Client
+	cancel context.CancelFunc
+	chStop chan struct{}
+}
+
+// NewIPCam returns new IPCam for the camera.
+func NewIPCam(db *bolt.DB, cameraID string) *IPCam {
+	return &IPCam{
+		DB:       db,
+		CameraID: cameraID,
+		chStop:   make(chan struct{}),
+	}
+}
+
+// Name returns the name of the source.
+func (cam *IPCam) Name() string {
+	return "rtsp"
+}
+
+// Setting returns the setting that will be used by the source.
+// Since the stream is produced by the IP camera, only its framerate is used.
+func (cam *IPCam) Setting() Setting {
+	return loadSetting(cam.DB, cam.CameraID)
+}
+
+// Capabilities returns the settings that supported by IP camera.
+// The resolution and rotation are decided by the camera itself,
+// so there are nothing to choose here.
+func (cam *IPCam) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// Start receives the stream from IP camera and writes it to w.
+// If the stream dropped, it will try to reconnect until Stop is called.
+func (cam *IPCam) Start(w io.Writer) error {
+	cam.mutex.Lock()
+	if cam.chStop == nil {
+		cam.chStop = make(chan struct{})
+	}
+	chStop := cam.chStop
+	cam.mutex.Unlock()
+
+	streamURL, err := cam.streamURL()
+	if err != nil {
+		return err
+	}
+
+	delay := time.Second
+	for {
+		startTime := time.Now()
+		if streamURL.Scheme == "rtsp" {
+			err = cam.receiveRTSP(streamURL, w, chStop)
+		} else {
+			err = cam.receiveHTTP(streamURL, w, chStop)
+		}
+
+		// If the stream ran long enough, reset the reconnect delay
+		if time.Since(startTime) > time.Minute {
+			delay = time.Second
+		}
+
+		// If the stream stopped because of stop request, we are done
+		select {
+		case <-chStop:
+			return nil
+		default:
+		}
+
+		logrus.WithField("camera", cam.CameraID).Warnf("IP camera stream dropped (%v), reconnecting in %s", err, delay)
+		select {
+		case <-chStop:
+			return nil
+		case <-time.After(delay):
+		}
+
+		if delay < 30*time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// Stop kills the receiver and stops reconnecting.
+func (cam *IPCam) Stop() {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+
+	if cam.chStop == nil {
+		cam.chStop = make(chan struct{})
+	}
+
+	select {
+	case <-cam.chStop:
+		return
+	default:
+		close(cam.chStop)
+	}
+
+	if cam.cmd != nil && cam.cmd.Process != nil {
+		cam.cmd.Process.Kill()
+	}
+
+	if cam.client != nil {
+		cam.client.Close()
+	}
+
+	if cam.cancel != nil {
+		cam.cancel()
+	}
+}
+
+// reset makes the stopped source can be started again. It's called by camera
+// before starting the source in background, so the stop request that comes
+// before the source actually started is not lost.
+func (cam *IPCam) reset() {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+
+	if cam.chStop == nil {
+		cam.chStop = make(chan struct{})
+		return
+	}
+
+	select {
+	case <-cam.chStop:
+		cam.chStop = make(chan struct{})
+	default:
+	}
+}
+
+// receiveRTSP receives the stream from RTSP camera until it dropped. Unlike
+// using ffmpeg, the credentials are not exposed in the process arguments.
+func (cam *IPCam) receiveRTSP(streamURL *nurl.URL, w io.Writer, chStop chan struct{}) error {
+	u, err := base.ParseURL(streamURL.String())
+	if err != nil {
+		return err
+	}
+
+	transport := gortsplib.TransportTCP
+	client := &gortsplib.Client{Transport: &transport}
+
+	cam.mutex.Lock()
+	select {
+	case <-chStop:
+		cam.mutex.Unlock()
+		return nil
+	default:
+	}
+
+	err = client.Start(u.Scheme, u.Host)
+	if err == nil {
+		cam.client = client
+	}
+	cam.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("fail to connect IP camera: %v", err)
+	}
+	defer client.Close()
+	logrus.WithField("camera", cam.CameraID).Infoln("IP camera receiver started")
+
+	desc, _, err := client.Describe(u)
+	if err != nil {
+		return err
+	}
+
+	var h264 *format.H264
+	media := desc.FindFormat(&h264)
+	if media == nil {
+		return fmt.Errorf("IP camera doesn't have H.264 stream")
+	}
+
+	decoder, err := h264.CreateDecoder()
+	if err != nil {
+		return err
+	}
+
+	if _, err = client.Setup(desc.BaseURL, media, 0, 0); err != nil {
+		return err
+	}
+
+	// The parameter sets might be only sent in SDP
+	sps, pps := h264.SafeParams()
+	chErr := make(chan error, 2)
+	client.OnPacketRTP(media, h264, func(pkt *rtp.Packet) {
+		nals, err := decoder.Decode(pkt)
+		if err != nil {
+			return
+		}
+
+		frame := annexBFrame(nals, &sps, &pps)
+		if _, err := w.Write(frame); err != nil {
+			select {
+			case chErr <- err:
+			default:
+			}
+		}
+	})
+
+	if _, err = client.Play(nil); err != nil {
+		return err
+	}
+
+	go func() {
+		chErr <- client.Wait()
+	}()
+
+	return <-chErr
+}
+
+// receiveHTTP receives the stream from HTTP camera until it dropped. The stream is
+// fetched here and piped into ffmpeg, so the credentials are not exposed in its
+// process arguments, then ffmpeg extracts the H.264 stream from its container.
+func (cam *IPCam) receiveHTTP(streamURL *nurl.URL, w io.Writer, chStop chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The credentials are sent as basic auth instead of in URL
+	var username, password string
+	if streamURL.User != nil {
+		username = streamURL.User.Username()
+		password, _ = streamURL.User.Password()
+	}
+
+	reqURL := *streamURL
+	reqURL.User = nil
+	req, err := http.NewRequest("GET", reqURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	cam.mutex.Lock()
+	select {
+	case <-chStop:
+		cam.mutex.Unlock()
+		return nil
+	default:
+	}
+
+	cam.cancel = cancel
+	cam.mutex.Unlock()
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("fail to connect IP camera: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("IP camera responded with %s", resp.Status)
+	}
+
+	stderrLogger := newStderrWriter(cam.CameraID, cam.Name())
+	defer stderrLogger.Close()
+
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-i", "pipe:0",
+		"-an",
+		"-codec:v", "copy",
+		"-f", "h264",
+		"pipe:1")
+	cmd.Stdout = w
+	cmd.Stderr = stderrLogger
+
+	// Use our own pipe instead of the body as stdin, so waiting for ffmpeg
+	// doesn't wait for the body that might be stalled. The body read is
+	// cancelled once this function finished.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	cam.mutex.Lock()
+	select {
+	case <-chStop:
+		cam.mutex.Unlock()
+		return nil
+	default:
+	}
+
+	cam.cmd = cmd
+	err = cmd.Start()
+	cam.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("fail to start IP camera receiver: %v", err)
+	}
+	logrus.WithField("camera", cam.CameraID).Infoln("IP camera receiver started")
+
+	go func() {
+		io.Copy(stdin, resp.Body)
+		stdin.Close()
+	}()
+
+	return cmd.Wait()
+}
+
+func (cam *IPCam) streamURL() (*nurl.URL, error) {
+	var strURL, username, password string
+	cam.DB.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cam.CameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		strURL = string(bucket.Get([]byte("url")))
+		username = string(bucket.Get([]byte("username")))
+		password = string(bucket.Get([]byte("password")))
+		return nil
+	})
+
+	if strURL == "" {
+		return nil, fmt.Errorf("IP camera URL is not specified")
+	}
+
+	url, err := nurl.Parse(strURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP camera URL: %v", err)
+	}
+
+	switch url.Scheme {
+	case "rtsp", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported IP camera URL scheme: %s", url.Scheme)
+	}
+
+	if username != "" {
+		url.User = nurl.UserPassword(username, password)
+	}
+
+	return url, nil
+}
+
+// annexBFrame returns the NAL units of a frame as H.264 Annex B byte stream.
+// The keyframe is preceded by the latest parameter sets, if it doesn't have it.
+func annexBFrame(nals [][]byte, sps, pps *[]byte) []byte {
+	hasParameterSets := false
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+
+		switch nal[0] & 0x1F {
+		case nalSPS:
+			*sps = nal
+			hasParameterSets = true
+		case nalPPS:
+			*pps = nal
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	startCode := []byte{0, 0, 0, 1}
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+
+		isIDR := nal[0]&0x1F == nalIDR
+		if isIDR && !hasParameterSets && len(*sps) > 0 && len(*pps) > 0 {
+			buf.Write(startCode)
+			buf.Write(*sps)
+			buf.Write(startCode)
+			buf.Write(*pps)
+			hasParameterSets = true
+		}
+
+		buf.Write(startCode)
+		buf.Write(nal)
+	}
+
+	return buf.Bytes()
+}
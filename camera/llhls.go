@@ -0,0 +1,619 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	llhlsTimescale       = 90000
+	llhlsPartDuration    = 200 * time.Millisecond
+	llhlsSegmentDuration = time.Second
+	llhlsSegmentCount    = 7
+	llhlsPartSegments    = 2
+	llhlsWaitTimeout     = 6 * time.Second
+)
+
+// errLLHLSNotReady is returned by the wait condition
+// when the requested media is not produced yet.
+var errLLHLSNotReady = fmt.Errorf("media is not ready")
+
+// llhlsSample is a single frame in AVCC format, i.e. its
+// NAL units are prefixed by length instead of start code.
+type llhlsSample struct {
+	data     []byte
+	keyframe bool
+}
+
+// llhlsPart is partial segment, i.e. fMP4 fragment of a few frames.
+type llhlsPart struct {
+	data        []byte
+	frames      int
+	independent bool
+}
+
+// llhlsSegment is media segment that composed of partial segments.
+// It's always started by keyframe.
+type llhlsSegment struct {
+	msn      int
+	time     time.Time
+	parts    []*llhlsPart
+	frames   int
+	complete bool
+}
+
+// llhlsMuxer packages H.264 stream from camera source into low latency HLS.
+// Unlike the ffmpeg segmenter, the stream is divided into fMP4 partial
+// segments of a few frames which served as soon as they are ready, so the
+// player doesn't need to wait for the whole segment. The segments are only
+// kept in memory, and the audio is not included.
+type llhlsMuxer struct {
+	mutex         sync.Mutex
+	cameraID      string
+	setting       Setting
+	frameDuration uint32
+	partFrames    int
+
+	scanner   nalScanner
+	tracker   h264Tracker
+	splitter  frameSplitter
+	frameData []byte
+	frameKey  bool
+
+	init       []byte
+	samples    []llhlsSample
+	decodeTime uint64
+	sequence   uint32
+	segments   []*llhlsSegment
+	nextMSN    int
+	chUpdate   chan struct{}
+	closed     bool
+}
+
+func newLLHLSMuxer(cameraID string, setting Setting) *llhlsMuxer {
+	fps := setting.FPS
+	if fps <= 0 {
+		fps = 1
+	}
+
+	// Part can't be shorter than a single frame
+	partFrames := int(llhlsPartDuration.Seconds() * float64(fps))
+	if partFrames < 1 {
+		partFrames = 1
+	}
+
+	return &llhlsMuxer{
+		cameraID:      cameraID,
+		setting:       setting,
+		frameDuration: uint32(llhlsTimescale / fps),
+		partFrames:    partFrames,
+		chUpdate:      make(chan struct{}),
+
+		// Muxer is recreated whenever the camera restarted, so media sequence
+		// is started from the wall clock to make sure the segment URLs of the
+		// new stream never collide with the ones of previous stream.
+		nextMSN: int(time.Now().Unix()),
+	}
+}
+
+// validateLowLatency checks the low latency setting before it's saved.
+func validateLowLatency(setting map[string]string) error {
+	return validateSwitch("low latency", setting["lowLatency"])
+}
+
+// loadLowLatency loads whether low latency live stream is enabled for camera.
+func loadLowLatency(db *bolt.DB, cameraID string) bool {
+	enabled := false
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket != nil {
+			enabled = string(bucket.Get([]byte("lowLatency"))) == "on"
+		}
+		return nil
+	})
+
+	return enabled
+}
+
+// LowLatency returns true if camera serves low latency live stream.
+func (cam *Camera) LowLatency() bool {
+	return cam.GenerateHlsSegments && loadLowLatency(cam.DB, cam.ID)
+}
+
+// LowLatencyPlaylist returns the media playlist of low latency live stream.
+// If msn is not negative, it blocks until the segment with that media sequence
+// number is ready, or only its part if part is not negative as well.
+func (cam *Camera) LowLatencyPlaylist(ctx context.Context, msn, part int) ([]byte, error) {
+	muxer, err := cam.lowLatencyMuxer()
+	if err != nil {
+		return nil, err
+	}
+
+	return muxer.mediaPlaylist(ctx, msn, part)
+}
+
+// LowLatencyInit returns the fMP4 initialization segment of low latency live stream.
+func (cam *Camera) LowLatencyInit(ctx context.Context) ([]byte, error) {
+	muxer, err := cam.lowLatencyMuxer()
+	if err != nil {
+		return nil, err
+	}
+
+	return muxer.wait(ctx, func() ([]byte, error) {
+		if muxer.init == nil {
+			return nil, errLLHLSNotReady
+		}
+		return muxer.init, nil
+	})
+}
+
+// LowLatencySegment returns segment or partial segment of low latency live stream.
+// The name is "<msn>.m4s" for segment and "<msn>.<part>.m4s" for partial segment.
+// If it's not ready yet, it blocks until it's ready.
+func (cam *Camera) LowLatencySegment(ctx context.Context, name string) ([]byte, error) {
+	muxer, err := cam.lowLatencyMuxer()
+	if err != nil {
+		return nil, err
+	}
+
+	return muxer.media(ctx, name)
+}
+
+func (cam *Camera) lowLatencyMuxer() (*llhlsMuxer, error) {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+
+	if cam.llhls == nil {
+		return nil, fmt.Errorf("low latency stream of camera %s is not available", cam.ID)
+	}
+
+	return cam.llhls, nil
+}
+
+// setLowLatencyMuxer registers the muxer that currently used by camera pipeline.
+func (cam *Camera) setLowLatencyMuxer(muxer *llhlsMuxer) {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+
+	cam.llhls = muxer
+}
+
+// Write receives the H.264 stream from camera source. It never returns error,
+// since it's only kept in memory.
+func (m *llhlsMuxer) Write(p []byte) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.scanner.push(p, m.handleNAL)
+	return len(p), nil
+}
+
+// Close stops the muxer and releases the requests that still waiting.
+func (m *llhlsMuxer) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.closed = true
+	m.notify()
+	return nil
+}
+
+func (m *llhlsMuxer) handleNAL(nal []byte) {
+	keyframe, ok := m.tracker.track(nal)
+	if !ok {
+		return
+	}
+
+	if m.splitter.split(nal, keyframe) {
+		m.finishFrame()
+	}
+
+	// Parameter sets might be sent before non IDR slice, so
+	// the frame is only a keyframe if it contains IDR slice
+	typ, header := nalType(nal)
+	m.frameKey = m.frameKey || typ == nalIDR
+
+	// Parameter sets are already in the initialization segment
+	switch typ {
+	case nalSPS, nalPPS, nalAUD:
+		return
+	}
+
+	payload := bytes.TrimRight(nal[header:], "\x00")
+	m.frameData = append(m.frameData, be(uint32(len(payload)))...)
+	m.frameData = append(m.frameData, payload...)
+}
+
+func (m *llhlsMuxer) finishFrame() {
+	sample := llhlsSample{data: m.frameData, keyframe: m.frameKey}
+	m.frameData = nil
+	m.frameKey = false
+
+	// Stream must be started by keyframe with its parameter sets
+	if m.init == nil {
+		parameterSets := m.tracker.parameterSets()
+		if !sample.keyframe || len(parameterSets) == 0 {
+			return
+		}
+
+		m.init = m.initSegment(parameterSets[0], parameterSets[1])
+	}
+
+	// New segment is started on keyframe, once the current one is long enough
+	segment := m.currentSegment()
+	if sample.keyframe {
+		if segment == nil || m.duration(segment.frames+len(m.samples)) >= llhlsSegmentDuration {
+			m.flushPart()
+			m.startSegment()
+		}
+	}
+
+	m.samples = append(m.samples, sample)
+	if len(m.samples) >= m.partFrames {
+		m.flushPart()
+	}
+}
+
+func (m *llhlsMuxer) currentSegment() *llhlsSegment {
+	if len(m.segments) == 0 {
+		return nil
+	}
+
+	return m.segments[len(m.segments)-1]
+}
+
+func (m *llhlsMuxer) startSegment() {
+	if segment := m.currentSegment(); segment != nil {
+		segment.complete = true
+	}
+
+	m.segments = append(m.segments, &llhlsSegment{
+		msn:  m.nextMSN,
+		time: time.Now(),
+	})
+	m.nextMSN++
+
+	// Keep the in progress segment along with the latest complete segments
+	if len(m.segments) > llhlsSegmentCount+1 {
+		m.segments = m.segments[len(m.segments)-llhlsSegmentCount-1:]
+	}
+
+	m.notify()
+}
+
+func (m *llhlsMuxer) flushPart() {
+	segment := m.currentSegment()
+	if segment == nil || len(m.samples) == 0 {
+		return
+	}
+
+	m.sequence++
+	part := &llhlsPart{
+		data:        m.fragment(),
+		frames:      len(m.samples),
+		independent: m.samples[0].keyframe,
+	}
+
+	segment.parts = append(segment.parts, part)
+	segment.frames += part.frames
+	m.decodeTime += uint64(part.frames) * uint64(m.frameDuration)
+	m.samples = nil
+	m.notify()
+}
+
+// notify wakes up the requests that waiting for new media.
+func (m *llhlsMuxer) notify() {
+	close(m.chUpdate)
+	m.chUpdate = make(chan struct{})
+}
+
+// wait calls fn until it returns anything other than errLLHLSNotReady.
+// The fn is called while the muxer is locked.
+func (m *llhlsMuxer) wait(ctx context.Context, fn func() ([]byte, error)) ([]byte, error) {
+	timeout := time.NewTimer(llhlsWaitTimeout)
+	defer timeout.Stop()
+
+	for {
+		m.mutex.Lock()
+		data, err := fn()
+		closed, chUpdate := m.closed, m.chUpdate
+		m.mutex.Unlock()
+
+		switch {
+		case err != errLLHLSNotReady:
+			return data, err
+		case closed:
+			return nil, fmt.Errorf("low latency stream of camera %s is stopped", m.cameraID)
+		}
+
+		select {
+		case <-chUpdate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, fmt.Errorf("low latency stream of camera %s is not ready", m.cameraID)
+		}
+	}
+}
+
+func (m *llhlsMuxer) duration(frames int) time.Duration {
+	return time.Duration(frames) * time.Duration(m.frameDuration) * time.Second / llhlsTimescale
+}
+
+func (m *llhlsMuxer) findSegment(msn int) *llhlsSegment {
+	for _, segment := range m.segments {
+		if segment.msn == msn {
+			return segment
+		}
+	}
+
+	return nil
+}
+
+// mediaPlaylist returns the media playlist, after the requested segment
+// or part is ready. Negative msn means the playlist is returned immediately.
+func (m *llhlsMuxer) mediaPlaylist(ctx context.Context, msn, part int) ([]byte, error) {
+	if msn < 0 && part >= 0 {
+		return nil, fmt.Errorf("_HLS_part requires _HLS_msn")
+	}
+
+	return m.wait(ctx, func() ([]byte, error) {
+		current := m.currentSegment()
+		if current == nil {
+			return nil, errLLHLSNotReady
+		}
+
+		switch {
+		case msn < 0, msn < m.segments[0].msn:
+		case msn > current.msn+2:
+			return nil, fmt.Errorf("segment %d is too far in the future", msn)
+		case msn > current.msn:
+			return nil, errLLHLSNotReady
+		default:
+			segment := m.findSegment(msn)
+			if !segment.complete && (part < 0 || part >= len(segment.parts)) {
+				return nil, errLLHLSNotReady
+			}
+		}
+
+		return m.playlist(), nil
+	})
+}
+
+// media returns segment or partial segment with the specified name.
+func (m *llhlsMuxer) media(ctx context.Context, name string) ([]byte, error) {
+	fields := strings.Split(strings.TrimSuffix(name, ".m4s"), ".")
+	msn, err := strconv.Atoi(fields[0])
+	if err != nil || len(fields) > 2 {
+		return nil, fmt.Errorf("segment %s is not valid", name)
+	}
+
+	part := -1
+	if len(fields) == 2 {
+		if part, err = strconv.Atoi(fields[1]); err != nil || part < 0 {
+			return nil, fmt.Errorf("segment %s is not valid", name)
+		}
+	}
+
+	return m.wait(ctx, func() ([]byte, error) {
+		segment := m.findSegment(msn)
+		if segment == nil {
+			// Only the upcoming segment is worth to wait
+			if current := m.currentSegment(); current == nil || msn == current.msn+1 {
+				return nil, errLLHLSNotReady
+			}
+			return nil, fmt.Errorf("segment %s is not exist", name)
+		}
+
+		switch {
+		case part < 0 && segment.complete:
+			buffer := new(bytes.Buffer)
+			for _, p := range segment.parts {
+				buffer.Write(p.data)
+			}
+			return buffer.Bytes(), nil
+		case part >= 0 && part < len(segment.parts):
+			return segment.parts[part].data, nil
+		case segment.complete:
+			return nil, fmt.Errorf("segment %s is not exist", name)
+		default:
+			return nil, errLLHLSNotReady
+		}
+	})
+}
+
+// playlist creates the media playlist. Parts are only listed for the
+// latest segments, while the older ones are listed as whole segments.
+func (m *llhlsMuxer) playlist() []byte {
+	baseURL := "/live/" + m.cameraID + "/ll"
+	partTarget := m.duration(m.partFrames).Seconds()
+
+	targetDuration := 1
+	for _, segment := range m.segments {
+		duration := int(math.Ceil(m.duration(segment.frames).Seconds()))
+		if duration > targetDuration {
+			targetDuration = duration
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	fmt.Fprintln(buffer, "#EXTM3U")
+	fmt.Fprintln(buffer, "#EXT-X-VERSION:6")
+	fmt.Fprintf(buffer, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	fmt.Fprintf(buffer, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", partTarget*3)
+	fmt.Fprintf(buffer, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	fmt.Fprintf(buffer, "#EXT-X-MEDIA-SEQUENCE:%d\n", m.segments[0].msn)
+	fmt.Fprintf(buffer, "#EXT-X-MAP:URI=\"%s/init.mp4\"\n", baseURL)
+
+	for i, segment := range m.segments {
+		fmt.Fprintf(buffer, "#EXT-X-PROGRAM-DATE-TIME:%s\n", segment.time.Format("2006-01-02T15:04:05.000Z07:00"))
+
+		if i >= len(m.segments)-llhlsPartSegments-1 {
+			for j, part := range segment.parts {
+				independent := ""
+				if part.independent {
+					independent = ",INDEPENDENT=YES"
+				}
+
+				fmt.Fprintf(buffer, "#EXT-X-PART:DURATION=%.3f,URI=\"%s/stream/%d.%d.m4s\"%s\n",
+					m.duration(part.frames).Seconds(), baseURL, segment.msn, j, independent)
+			}
+		}
+
+		if segment.complete {
+			fmt.Fprintf(buffer, "#EXTINF:%.3f,\n", m.duration(segment.frames).Seconds())
+			fmt.Fprintf(buffer, "%s/stream/%d.m4s\n", baseURL, segment.msn)
+		}
+	}
+
+	current := m.currentSegment()
+	fmt.Fprintf(buffer, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s/stream/%d.%d.m4s\"\n",
+		baseURL, current.msn, len(current.parts))
+
+	return buffer.Bytes()
+}
+
+// initSegment creates fMP4 initialization segment, which contains
+// a single video track described by the parameter sets.
+func (m *llhlsMuxer) initSegment(sps, pps []byte) []byte {
+	_, spsHeader := nalType(sps)
+	_, ppsHeader := nalType(pps)
+	sps, pps = sps[spsHeader:], pps[ppsHeader:]
+
+	width, height, err := spsSize(sps)
+	if err != nil {
+		width, height = m.setting.Width, m.setting.Height
+	}
+
+	matrix := []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+	ftyp := mp4Box("ftyp", []byte("iso5"), be(uint32(512)), []byte("iso5iso6mp41"))
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		be(uint32(0), uint32(0), uint32(1000), uint32(0)), // times, timescale, duration
+		be(uint32(0x00010000), uint16(0x0100), uint16(0)), // rate, volume
+		make([]byte, 8), be(matrix), make([]byte, 24),
+		be(uint32(2))) // next track ID
+
+	tkhd := mp4FullBox("tkhd", 0, 3,
+		be(uint32(0), uint32(0), uint32(1), uint32(0), uint32(0)), // times, track ID, duration
+		make([]byte, 8), be(uint16(0), uint16(0), uint16(0), uint16(0)), be(matrix),
+		be(uint32(width)<<16, uint32(height)<<16))
+
+	mdhd := mp4FullBox("mdhd", 0, 0,
+		be(uint32(0), uint32(0), uint32(llhlsTimescale), uint32(0)),
+		be(uint16(0x55C4), uint16(0))) // language "und"
+
+	hdlr := mp4FullBox("hdlr", 0, 0,
+		be(uint32(0)), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+
+	avcC := mp4Box("avcC",
+		[]byte{1, sps[1], sps[2], sps[3], 0xFF, 0xE1},
+		be(uint16(len(sps))), sps,
+		[]byte{1}, be(uint16(len(pps))), pps)
+
+	compressorName := make([]byte, 32)
+	avc1 := mp4Box("avc1",
+		make([]byte, 6), be(uint16(1)), // reserved, data reference index
+		make([]byte, 16),
+		be(uint16(width), uint16(height), uint32(0x00480000), uint32(0x00480000)),
+		be(uint32(0), uint16(1)), compressorName,
+		be(uint16(0x0018), uint16(0xFFFF)),
+		avcC)
+
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, be(uint32(1)), avc1),
+		mp4FullBox("stts", 0, 0, be(uint32(0))),
+		mp4FullBox("stsc", 0, 0, be(uint32(0))),
+		mp4FullBox("stsz", 0, 0, be(uint32(0), uint32(0))),
+		mp4FullBox("stco", 0, 0, be(uint32(0))))
+
+	minf := mp4Box("minf",
+		mp4FullBox("vmhd", 0, 1, make([]byte, 8)),
+		mp4Box("dinf", mp4FullBox("dref", 0, 0, be(uint32(1)), mp4FullBox("url ", 0, 1))),
+		stbl)
+
+	mvex := mp4Box("mvex", mp4FullBox("trex", 0, 0,
+		be(uint32(1), uint32(1), uint32(0), uint32(0), uint32(0))))
+
+	moov := mp4Box("moov", mvhd,
+		mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, minf)),
+		mvex)
+
+	return append(ftyp, moov...)
+}
+
+// fragment creates fMP4 fragment, i.e. moof and mdat, from the pending samples.
+func (m *llhlsMuxer) fragment() []byte {
+	var mdatData []byte
+	for _, sample := range m.samples {
+		mdatData = append(mdatData, sample.data...)
+	}
+
+	moof := func(dataOffset uint32) []byte {
+		var entries []byte
+		for _, sample := range m.samples {
+			flags := uint32(0x01010000) // depends on other frame, not sync sample
+			if sample.keyframe {
+				flags = 0x02000000
+			}
+
+			entries = append(entries, be(m.frameDuration, uint32(len(sample.data)), flags)...)
+		}
+
+		// Sample duration, size and flags are written, and
+		// the data offset is relative to the start of moof
+		return mp4Box("moof",
+			mp4FullBox("mfhd", 0, 0, be(m.sequence)),
+			mp4Box("traf",
+				mp4FullBox("tfhd", 0, 0x020000, be(uint32(1))),
+				mp4FullBox("tfdt", 1, 0, be(m.decodeTime)),
+				mp4FullBox("trun", 0, 0x000701, be(uint32(len(m.samples)), dataOffset), entries)))
+	}
+
+	header := moof(0)
+	header = moof(uint32(len(header) + 8))
+	return append(header, mp4Box("mdat", mdatData)...)
+}
+
+// mp4Box creates ISO BMFF box with the specified type and payload.
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	box := make([]byte, 0, size)
+	box = append(box, be(uint32(size))...)
+	box = append(box, boxType...)
+	for _, p := range payload {
+		box = append(box, p...)
+	}
+
+	return box
+}
+
+// mp4FullBox creates ISO BMFF box which has version and flags.
+func mp4FullBox(boxType string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(boxType, append([][]byte{header}, payload...)...)
+}
+
+// be encodes the fixed size values in big endian.
+func be(values ...interface{}) []byte {
+	buffer := new(bytes.Buffer)
+	for _, value := range values {
+		binary.Write(buffer, binary.BigEndian, value)
+	}
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,243 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFrames returns stream with the specified count of frames, started by keyframe
+// on every gop frames. It's followed by a pending frame, so all of the frames
+// are already finished once the stream written into muxer.
+func testFrames(count, gop int) []byte {
+	var nals [][]byte
+	for i := 0; i < count; i++ {
+		if i%gop == 0 {
+			nals = append(nals, testSPS, testPPS, testIDR)
+		} else {
+			nals = append(nals, testSlice)
+		}
+	}
+
+	nals = append(nals, testSlice, testSlice)
+	return joinNALs(nals...)
+}
+
+// newTestMuxer returns muxer with 10 FPS, i.e. a part is two frames and
+// a segment is ten frames, which already received 25 frames with 5 frames GOP.
+// Its segments are two complete segments and the third one that in progress.
+func newTestMuxer() *llhlsMuxer {
+	m := newLLHLSMuxer("test", Setting{FPS: 10})
+	m.Write(testFrames(25, 5))
+	return m
+}
+
+func TestLLHLSSegmentation(t *testing.T) {
+	m := newLLHLSMuxer("test", Setting{FPS: 10})
+	if m.partFrames != 2 {
+		t.Fatalf("got %d frames per part, want 2", m.partFrames)
+	}
+
+	// Frames before the first keyframe can't be decoded, so they are dropped
+	m.Write(joinNALs(testSlice, testSlice, testSlice))
+	if m.init != nil || len(m.segments) != 0 || len(m.samples) != 0 {
+		t.Fatal("stream is started before keyframe")
+	}
+
+	// Keyframes in the middle of segment don't start a new one,
+	// since the segment is not long enough yet
+	m.Write(testFrames(25, 5))
+	if m.init == nil {
+		t.Fatal("initialization segment is not created")
+	}
+
+	wantFrames := []int{10, 10, 4}
+	wantParts := []int{5, 5, 2}
+	if len(m.segments) != len(wantFrames) {
+		t.Fatalf("got %d segments, want %d", len(m.segments), len(wantFrames))
+	}
+
+	for i, segment := range m.segments {
+		if segment.msn != m.segments[0].msn+i {
+			t.Errorf("segment %d: got msn %d, want %d", i, segment.msn, m.segments[0].msn+i)
+		}
+
+		if segment.frames != wantFrames[i] || len(segment.parts) != wantParts[i] {
+			t.Errorf("segment %d: got %d frames in %d parts, want %d frames in %d parts",
+				i, segment.frames, len(segment.parts), wantFrames[i], wantParts[i])
+		}
+
+		if wantComplete := i < len(m.segments)-1; segment.complete != wantComplete {
+			t.Errorf("segment %d: got complete %v, want %v", i, segment.complete, wantComplete)
+		}
+
+		for j, part := range segment.parts {
+			if part.independent != (j == 0) {
+				t.Errorf("segment %d part %d: got independent %v, want %v", i, j, part.independent, j == 0)
+			}
+		}
+	}
+
+	// The last frame is waiting for the next one to fill the part
+	if len(m.samples) != 1 {
+		t.Fatalf("got %d pending samples, want 1", len(m.samples))
+	}
+
+	// Completed segments are limited, along with the one in progress
+	m.Write(testFrames(200, 10))
+	if len(m.segments) != llhlsSegmentCount+1 {
+		t.Errorf("got %d segments, want %d", len(m.segments), llhlsSegmentCount+1)
+	}
+}
+
+func TestLLHLSMedia(t *testing.T) {
+	m := newTestMuxer()
+	msn := m.segments[0].msn
+	segment := m.segments[0]
+
+	wantSegment := new(bytes.Buffer)
+	for _, part := range segment.parts {
+		wantSegment.Write(part.data)
+	}
+
+	tests := []struct {
+		name      string
+		media     string
+		want      []byte
+		wantErr   bool
+		wantBlock bool
+	}{
+		{"complete segment", fmt.Sprintf("%d.m4s", msn), wantSegment.Bytes(), false, false},
+		{"part of complete segment", fmt.Sprintf("%d.1.m4s", msn), segment.parts[1].data, false, false},
+		{"part of current segment", fmt.Sprintf("%d.1.m4s", msn+2), m.segments[2].parts[1].data, false, false},
+		{"not a number", "abc.m4s", nil, true, false},
+		{"too many fields", fmt.Sprintf("%d.1.2.m4s", msn), nil, true, false},
+		{"invalid part", fmt.Sprintf("%d.x.m4s", msn), nil, true, false},
+		{"negative part", fmt.Sprintf("%d.-1.m4s", msn), nil, true, false},
+		{"removed segment", fmt.Sprintf("%d.m4s", msn-1), nil, true, false},
+		{"part after end of complete segment", fmt.Sprintf("%d.5.m4s", msn), nil, true, false},
+		{"segment too far in the future", fmt.Sprintf("%d.m4s", msn+4), nil, true, false},
+		{"current segment", fmt.Sprintf("%d.m4s", msn+2), nil, false, true},
+		{"next part of current segment", fmt.Sprintf("%d.2.m4s", msn+2), nil, false, true},
+		{"part of next segment", fmt.Sprintf("%d.0.m4s", msn+3), nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			got, err := m.media(ctx, tt.media)
+			switch {
+			case tt.wantBlock:
+				if err != context.DeadlineExceeded {
+					t.Errorf("got error %v, want it blocked", err)
+				}
+			case (err != nil) != tt.wantErr:
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			case !bytes.Equal(got, tt.want):
+				t.Errorf("got %d bytes, want %d bytes", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestLLHLSMediaPlaylist(t *testing.T) {
+	m := newTestMuxer()
+	msn := m.segments[0].msn
+
+	tests := []struct {
+		name      string
+		msn       int
+		part      int
+		wantErr   bool
+		wantBlock bool
+	}{
+		{"no blocking", -1, -1, false, false},
+		{"part without msn", -1, 0, true, false},
+		{"removed segment", msn - 1, -1, false, false},
+		{"complete segment", msn, -1, false, false},
+		{"part of complete segment", msn, 7, false, false},
+		{"ready part of current segment", msn + 2, 1, false, false},
+		{"next part of current segment", msn + 2, 2, false, true},
+		{"current segment", msn + 2, -1, false, true},
+		{"next segment", msn + 3, -1, false, true},
+		{"segment after next", msn + 4, 0, false, true},
+		{"segment too far in the future", msn + 5, -1, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			playlist, err := m.mediaPlaylist(ctx, tt.msn, tt.part)
+			switch {
+			case tt.wantBlock:
+				if err != context.DeadlineExceeded {
+					t.Errorf("got error %v, want it blocked", err)
+				}
+			case (err != nil) != tt.wantErr:
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			case err == nil && !strings.Contains(string(playlist), fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", msn)):
+				t.Errorf("got invalid playlist:\n%s", playlist)
+			}
+		})
+	}
+}
+
+func TestLLHLSBlockingRelease(t *testing.T) {
+	m := newLLHLSMuxer("test", Setting{FPS: 10})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Playlist is blocked until the first segment is started
+	chPlaylist := make(chan error, 1)
+	go func() {
+		_, err := m.mediaPlaylist(ctx, -1, -1)
+		chPlaylist <- err
+	}()
+
+	m.Write(testFrames(25, 5))
+	if err := <-chPlaylist; err != nil {
+		t.Fatalf("playlist is not released: %v", err)
+	}
+
+	// Pending part is released once it's filled
+	msn := m.segments[2].msn
+	chMedia := make(chan error, 1)
+	go func() {
+		_, err := m.media(ctx, fmt.Sprintf("%d.2.m4s", msn))
+		chMedia <- err
+	}()
+
+	m.Write(joinNALs(testSlice, testSlice))
+	if err := <-chMedia; err != nil {
+		t.Fatalf("part is not released: %v", err)
+	}
+
+	// Current segment is released once it's complete
+	go func() {
+		_, err := m.mediaPlaylist(ctx, msn, -1)
+		chPlaylist <- err
+	}()
+
+	m.Write(testFrames(10, 5))
+	if err := <-chPlaylist; err != nil {
+		t.Fatalf("segment is not released: %v", err)
+	}
+
+	// Closed muxer releases everything that still waiting
+	go func() {
+		_, err := m.mediaPlaylist(ctx, msn+3, -1)
+		chPlaylist <- err
+	}()
+
+	m.Close()
+	if err := <-chPlaylist; err == nil || err == context.DeadlineExceeded {
+		t.Fatalf("got error %v, want stopped stream", err)
+	}
+}
@@ -0,0 +1,104 @@
+package camera
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Mask is privacy mask, i.e. region of video that blacked out before the stream
+// is saved or served. The position and size are normalized between 0 and 1.
+type Mask struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Validate checks whether the mask is inside the frame.
+func (m Mask) Validate() error {
+	for _, val := range []float64{m.X, m.Y, m.Width, m.Height} {
+		if val < 0 || val > 1 {
+			return fmt.Errorf("mask position and size must be between 0 and 1")
+		}
+	}
+
+	if m.Width == 0 || m.Height == 0 || m.X+m.Width > 1 || m.Y+m.Height > 1 {
+		return fmt.Errorf("mask must be inside the frame")
+	}
+
+	return nil
+}
+
+// Masks returns the privacy masks of camera.
+func Masks(db *bolt.DB, cameraID string) []Mask {
+	masks := []Mask{}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("masks"))
+		if bucket == nil {
+			return nil
+		}
+
+		if val := bucket.Get([]byte(cameraID)); val != nil {
+			json.Unmarshal(val, &masks)
+		}
+		return nil
+	})
+
+	return masks
+}
+
+// SaveMasks validates then saves the privacy masks of camera.
+// The camera must be restarted for the masks to take effect.
+func SaveMasks(db *bolt.DB, cameraID string, masks []Mask) error {
+	for _, mask := range masks {
+		if err := mask.Validate(); err != nil {
+			return err
+		}
+	}
+
+	value, err := json.Marshal(&masks)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("masks"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(cameraID), value)
+	})
+}
+
+// genCmdPrivacyMask creates command that blacks out the masked regions. Since
+// the frames must be modified, the stream is re-encoded with keyframe every
+// two seconds, so the HLS segmenter and recorders still able to split it.
+func (cam *Camera) genCmdPrivacyMask(setting Setting, masks []Mask) *exec.Cmd {
+	var filters []string
+	for _, mask := range masks {
+		filters = append(filters, fmt.Sprintf(
+			"drawbox=x=iw*%f:y=ih*%f:w=iw*%f:h=ih*%f:color=black:t=fill",
+			mask.X, mask.Y, mask.Width, mask.Height))
+	}
+
+	return exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-framerate", strconv.Itoa(setting.FPS),
+		"-f", "h264",
+		"-i", "pipe:0",
+		"-an",
+		"-vf", strings.Join(filters, ","),
+		"-codec:v", "libx264",
+		"-preset", "ultrafast",
+		"-tune", "zerolatency",
+		"-pix_fmt", "yuv420p",
+		"-g", strconv.Itoa(setting.FPS*2),
+		"-f", "h264",
+		"pipe:1")
+}
@@ -0,0 +1,243 @@
+package camera
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultMJPEGFrameRate = 2
+	maxMJPEGFrameRate     = 15
+)
+
+var (
+	jpegStart = []byte{0xFF, 0xD8}
+	jpegEnd   = []byte{0xFF, 0xD9}
+)
+
+// mjpegStream is MJPEG transcoder for the live stream of camera.
+// The transcoder only runs while there are clients connected.
+type mjpegStream struct {
+	mutex   sync.Mutex
+	clients map[chan []byte]struct{}
+	chStop  chan struct{}
+}
+
+// SubscribeMJPEG returns channel that receives JPEG frames of the live stream,
+// and function to stop the subscription. The MJPEG transcoder is started when
+// the first client subscribed, and stopped when the last client unsubscribed.
+func (cam *Camera) SubscribeMJPEG() (chan []byte, func()) {
+	chFrame := make(chan []byte, 1)
+
+	cam.mjpeg.mutex.Lock()
+	if cam.mjpeg.clients == nil {
+		cam.mjpeg.clients = make(map[chan []byte]struct{})
+	}
+
+	cam.mjpeg.clients[chFrame] = struct{}{}
+	if len(cam.mjpeg.clients) == 1 {
+		cam.mjpeg.chStop = make(chan struct{})
+		go cam.runMJPEG(cam.mjpeg.chStop)
+	}
+	cam.mjpeg.mutex.Unlock()
+
+	unsubscribe := func() {
+		cam.mjpeg.mutex.Lock()
+		defer cam.mjpeg.mutex.Unlock()
+
+		delete(cam.mjpeg.clients, chFrame)
+		if len(cam.mjpeg.clients) == 0 && cam.mjpeg.chStop != nil {
+			close(cam.mjpeg.chStop)
+			cam.mjpeg.chStop = nil
+		}
+	}
+
+	return chFrame, unsubscribe
+}
+
+// runMJPEG runs the MJPEG transcoder until chStop closed or camera stopped.
+// If the transcoder exited by itself, it will be restarted.
+func (cam *Camera) runMJPEG(chStop chan struct{}) {
+	logger := logrus.WithField("camera", cam.ID)
+	logger.Infoln("mjpeg started")
+	defer logger.Infoln("mjpeg stopped")
+
+	for {
+		if err := cam.transcodeMJPEG(chStop); err != nil {
+			logger.Warnln("mjpeg failed:", err)
+		}
+
+		select {
+		case <-chStop:
+			return
+		case <-cam.stopChannel():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (cam *Camera) transcodeMJPEG(chStop chan struct{}) error {
+	// Attach to the live stream
+	reader := cam.hub.attach()
+	defer cam.hub.detach(reader)
+
+	// Start the transcoder
+	frameRate := loadMJPEGFrameRate(cam.DB, cam.ID)
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "h264",
+		"-i", "pipe:0",
+		"-an",
+		"-vf", "fps="+strconv.Itoa(frameRate),
+		"-codec:v", "mjpeg",
+		"-q:v", "5",
+		"-f", "image2pipe",
+		"pipe:1")
+
+	stderr := newStderrWriter(cam.ID, "mjpeg")
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Feed the stream to transcoder, and kill it when stop requested
+	chDone := make(chan struct{})
+	defer close(chDone)
+
+	go func() {
+		defer stdin.Close()
+		for {
+			select {
+			case <-chDone:
+				return
+			case <-chStop:
+				cmd.Process.Kill()
+				return
+			case <-cam.stopChannel():
+				cmd.Process.Kill()
+				return
+			case unit, ok := <-reader.chUnit:
+				if !ok {
+					return
+				}
+
+				if err := unit.writeTo(stdin); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	splitJPEG(stdout, cam.broadcastMJPEG)
+	return cmd.Wait()
+}
+
+// broadcastMJPEG sends the frame to all clients. Client that can't keep up
+// will skip its pending frame, so it always receives the newest frame.
+func (cam *Camera) broadcastMJPEG(frame []byte) {
+	cam.mjpeg.mutex.Lock()
+	defer cam.mjpeg.mutex.Unlock()
+
+	for chFrame := range cam.mjpeg.clients {
+		select {
+		case <-chFrame:
+		default:
+		}
+
+		select {
+		case chFrame <- frame:
+		default:
+		}
+	}
+}
+
+// splitJPEG reads the concatenated JPEG images from r,
+// and calls fn for every complete image.
+func splitJPEG(r io.Reader, fn func([]byte)) error {
+	buf := []byte{}
+	chunk := make([]byte, 32*1024)
+
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		for {
+			start := bytes.Index(buf, jpegStart)
+			if start < 0 {
+				// Keep the last byte, in case it's part of the start marker
+				if len(buf) > 1 {
+					buf = buf[len(buf)-1:]
+				}
+				break
+			}
+
+			end := bytes.Index(buf[start+2:], jpegEnd)
+			if end < 0 {
+				buf = buf[start:]
+				break
+			}
+
+			end += start + 4
+			fn(append([]byte(nil), buf[start:end]...))
+			buf = buf[end:]
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// validateMJPEGFrameRate checks frame rate of MJPEG stream before it's saved.
+func validateMJPEGFrameRate(setting map[string]string) error {
+	if setting["mjpegFps"] == "" {
+		return nil
+	}
+
+	if value, err := strconv.Atoi(setting["mjpegFps"]); err != nil || value < 1 || value > maxMJPEGFrameRate {
+		return fmt.Errorf("MJPEG framerate must be between 1 and %d", maxMJPEGFrameRate)
+	}
+
+	return nil
+}
+
+// loadMJPEGFrameRate loads frame rate of MJPEG stream from database.
+func loadMJPEGFrameRate(db *bolt.DB, cameraID string) int {
+	frameRate := defaultMJPEGFrameRate
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		value, err := strconv.Atoi(string(bucket.Get([]byte("mjpegFps"))))
+		if err == nil && value > 0 && value <= maxMJPEGFrameRate {
+			frameRate = value
+		}
+
+		return nil
+	})
+
+	return frameRate
+}
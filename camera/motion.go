@@ -0,0 +1,282 @@
+package camera
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	motionFrameWidth  = 64
+	motionFrameHeight = 48
+	motionFrameSize   = motionFrameWidth * motionFrameHeight
+	motionFrameRate   = 5
+
+	// Minimum brightness difference for a pixel to be considered changed
+	motionPixelThreshold = 25
+
+	// Motion event is ended after there is no motion for this duration
+	motionCooldown = 5 * time.Second
+
+	// Motion events older than this are removed from database
+	motionRetention = 30 * 24 * time.Hour
+
+	motionKeyFormat        = "20060102150405.000"
+	defaultMotionThreshold = 2.0
+)
+
+// MotionEvent is a period of time where motion detected in camera.
+// Score is the highest percentage of changed pixels during the event.
+type MotionEvent struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Score float64   `json:"score"`
+}
+
+// MotionSetting is the setting for motion detector of a camera.
+type MotionSetting struct {
+	Enabled   bool
+	Threshold float64
+}
+
+// motionDetector receives low resolution grayscale frames, compares each frame
+// with the previous one and reports the motion events.
+type motionDetector struct {
+	mutex      sync.Mutex
+	threshold  float64
+	onStart    func()
+	onEvent    func(MotionEvent)
+	buf        []byte
+	prevFrame  []byte
+	prevMean   int
+	event      *MotionEvent
+	lastMotion time.Time
+}
+
+func newMotionDetector(threshold float64, onStart func(), onEvent func(MotionEvent)) *motionDetector {
+	return &motionDetector{
+		threshold: threshold,
+		onStart:   onStart,
+		onEvent:   onEvent,
+	}
+}
+
+// Write receives raw grayscale frames from the decoder.
+func (md *motionDetector) Write(p []byte) (int, error) {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+
+	md.buf = append(md.buf, p...)
+	for len(md.buf) >= motionFrameSize {
+		md.processFrame(md.buf[:motionFrameSize], time.Now())
+		md.buf = append(md.buf[:0], md.buf[motionFrameSize:]...)
+	}
+
+	return len(p), nil
+}
+
+// Flush ends the ongoing motion event, if any.
+func (md *motionDetector) Flush() {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+
+	if md.event != nil {
+		md.onEvent(*md.event)
+		md.event = nil
+	}
+
+	md.buf = nil
+	md.prevFrame = nil
+}
+
+func (md *motionDetector) processFrame(frame []byte, now time.Time) {
+	// Calculate mean brightness, so the change of lighting
+	// (e.g. cloud or auto exposure) doesn't count as motion
+	mean := 0
+	for _, pixel := range frame {
+		mean += int(pixel)
+	}
+	mean /= len(frame)
+
+	if md.prevFrame == nil {
+		md.prevFrame = make([]byte, motionFrameSize)
+		copy(md.prevFrame, frame)
+		md.prevMean = mean
+		return
+	}
+
+	// Count the changed pixels
+	nChanged := 0
+	meanDiff := mean - md.prevMean
+	for i, pixel := range frame {
+		diff := int(pixel) - int(md.prevFrame[i]) - meanDiff
+		if diff < 0 {
+			diff = -diff
+		}
+
+		if diff > motionPixelThreshold {
+			nChanged++
+		}
+	}
+
+	copy(md.prevFrame, frame)
+	md.prevMean = mean
+
+	// Update the motion event
+	score := float64(nChanged) * 100 / float64(len(frame))
+	switch {
+	case score >= md.threshold:
+		if md.event == nil {
+			md.event = &MotionEvent{Start: now}
+			md.onStart()
+		}
+
+		md.event.End = now
+		if score > md.event.Score {
+			md.event.Score = score
+		}
+
+		md.lastMotion = now
+	case md.event != nil && now.Sub(md.lastMotion) > motionCooldown:
+		md.onEvent(*md.event)
+		md.event = nil
+	}
+}
+
+// handleMotionEvent saves the motion event to database.
+func (cam *Camera) handleMotionEvent(event MotionEvent) {
+	logger := logrus.WithField("camera", cam.ID)
+	logger.Infof("motion detected from %s until %s, score %.1f",
+		event.Start.Format("15:04:05"), event.End.Format("15:04:05"), event.Score)
+
+	err := cam.DB.Update(func(tx *bolt.Tx) error {
+		motion, err := tx.CreateBucketIfNotExists([]byte("motion"))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := motion.CreateBucketIfNotExists([]byte(cam.ID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(&event)
+		if err != nil {
+			return err
+		}
+
+		key := event.Start.UTC().Format(motionKeyFormat)
+		if err = bucket.Put([]byte(key), value); err != nil {
+			return err
+		}
+
+		// Remove the old events
+		var oldKeys [][]byte
+		limit := time.Now().Add(-motionRetention).UTC().Format(motionKeyFormat)
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && string(key) < limit; key, _ = cursor.Next() {
+			oldKeys = append(oldKeys, key)
+		}
+
+		for _, key := range oldKeys {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logger.Warnln("failed to save motion event:", err)
+	}
+}
+
+func (cam *Camera) genCmdMotionFrames(setting Setting) *exec.Cmd {
+	return exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-framerate", strconv.Itoa(setting.FPS),
+		"-i", "pipe:0",
+		"-an",
+		"-vf", "fps="+strconv.Itoa(motionFrameRate)+
+			",scale="+strconv.Itoa(motionFrameWidth)+":"+strconv.Itoa(motionFrameHeight),
+		"-pix_fmt", "gray",
+		"-f", "rawvideo",
+		"pipe:1")
+}
+
+// MotionEvents returns the motion events of camera which started within the specified time range.
+func MotionEvents(db *bolt.DB, cameraID string, from, to time.Time) []MotionEvent {
+	events := []MotionEvent{}
+	db.View(func(tx *bolt.Tx) error {
+		motion := tx.Bucket([]byte("motion"))
+		if motion == nil {
+			return nil
+		}
+
+		bucket := motion.Bucket([]byte(cameraID))
+		if bucket == nil {
+			return nil
+		}
+
+		min := []byte(from.UTC().Format(motionKeyFormat))
+		max := to.UTC().Format(motionKeyFormat)
+		cursor := bucket.Cursor()
+		for key, val := cursor.Seek(min); key != nil && string(key) <= max; key, val = cursor.Next() {
+			var event MotionEvent
+			if err := json.Unmarshal(val, &event); err == nil {
+				events = append(events, event)
+			}
+		}
+
+		return nil
+	})
+
+	return events
+}
+
+// validateMotionSetting checks the motion detector setting before it's saved.
+func validateMotionSetting(setting map[string]string) error {
+	if err := validateSwitch("motion detection", setting["motion"]); err != nil {
+		return err
+	}
+
+	if setting["motionThreshold"] != "" {
+		threshold, err := strconv.ParseFloat(setting["motionThreshold"], 64)
+		if err != nil || threshold <= 0 || threshold > 100 {
+			return fmt.Errorf("motion threshold must be between 0 and 100")
+		}
+	}
+
+	return nil
+}
+
+// loadMotionSetting loads the motion detector setting of camera from database.
+func loadMotionSetting(db *bolt.DB, cameraID string) MotionSetting {
+	setting := MotionSetting{
+		Enabled:   false,
+		Threshold: defaultMotionThreshold,
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		setting.Enabled = string(bucket.Get([]byte("motion"))) == "on"
+		if threshold, err := strconv.ParseFloat(string(bucket.Get([]byte("motionThreshold"))), 64); err == nil && threshold > 0 {
+			setting.Threshold = threshold
+		}
+
+		return nil
+	})
+
+	return setting
+}
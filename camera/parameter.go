@@ -0,0 +1,142 @@
+package camera
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Type of source parameter.
+const (
+	ParamInt  = "int"
+	ParamEnum = "enum"
+	ParamBool = "bool"
+	ParamText = "text"
+	ParamROI  = "roi"
+)
+
+// Parameter is definition of a source specific setting. It's used to validate
+// the setting before it's saved, and to render its input in web interface.
+type Parameter struct {
+	Key         string   `json:"key"`
+	Label       string   `json:"label"`
+	Type        string   `json:"type"`
+	Min         int      `json:"min"`
+	Max         int      `json:"max"`
+	Options     []string `json:"options,omitempty"`
+	Default     string   `json:"default"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Validate checks whether the value is valid for this parameter.
+// Empty value is always valid, since it means the default value is used.
+func (p Parameter) Validate(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	switch p.Type {
+	case ParamInt:
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", p.Label)
+		}
+
+		if intValue < p.Min || intValue > p.Max {
+			return fmt.Errorf("%s must be between %d and %d", p.Label, p.Min, p.Max)
+		}
+	case ParamEnum:
+		if !containsString(p.Options, value) {
+			return fmt.Errorf("%s must be one of %s", p.Label, strings.Join(p.Options, ", "))
+		}
+	case ParamBool:
+		if value != "on" && value != "off" {
+			return fmt.Errorf("%s must be either on or off", p.Label)
+		}
+	case ParamROI:
+		if _, err := parseROI(value); err != nil {
+			return fmt.Errorf("%s is not valid: %v", p.Label, err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateParameters checks the setting against the list of parameters.
+func ValidateParameters(params []Parameter, setting map[string]string) error {
+	for _, param := range params {
+		if err := param.Validate(setting[param.Key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parameterValues is the values of source parameters, loaded from database.
+type parameterValues map[string]string
+
+// loadParameters loads values of the parameters from database.
+// If the value is not saved yet, its default value will be used.
+func loadParameters(db *bolt.DB, cameraID string, params []Parameter) parameterValues {
+	values := make(parameterValues)
+	for _, param := range params {
+		values[param.Key] = param.Default
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		for _, param := range params {
+			if value := string(bucket.Get([]byte(param.Key))); value != "" {
+				values[param.Key] = value
+			}
+		}
+
+		return nil
+	})
+
+	return values
+}
+
+func (pv parameterValues) String(key string) string {
+	return pv[key]
+}
+
+func (pv parameterValues) Int(key string) int {
+	value, _ := strconv.Atoi(pv[key])
+	return value
+}
+
+func (pv parameterValues) Bool(key string) bool {
+	return pv[key] == "on"
+}
+
+// parseROI parses region of interest which formatted as "x,y,w,h",
+// where each of them is normalized between 0 and 1.
+func parseROI(value string) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("it must be formatted as x,y,w,h")
+	}
+
+	roi := make([]float64, 4)
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || number < 0 || number > 1 {
+			return nil, fmt.Errorf("each value must be between 0 and 1")
+		}
+		roi[i] = number
+	}
+
+	if roi[2] == 0 || roi[3] == 0 || roi[0]+roi[2] > 1 || roi[1]+roi[3] > 1 {
+		return nil, fmt.Errorf("the region must be inside the frame")
+	}
+
+	return roi, nil
+}
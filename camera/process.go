@@ -0,0 +1,209 @@
+package camera
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/RadhiFadlillah/cygnus/logs"
+	"github.com/sirupsen/logrus"
+)
+
+// processSource is helper for source whose stream produced by a single child process.
+// Once it's stopped, it can't be run again until it's reset, so the stop request that
+// comes before the process started is not lost.
+type processSource struct {
+	mutex   sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+}
+
+// run starts the cmd, writes its output to w and then wait until it exited.
+func (ps *processSource) run(cameraID, component string, cmd *exec.Cmd, w io.Writer) error {
+	stderr := &tailBuffer{}
+	stderrLogger := newStderrWriter(cameraID, component)
+	defer stderrLogger.Close()
+
+	cmd.Stdout = w
+	cmd.Stderr = io.MultiWriter(stderr, stderrLogger)
+
+	ps.mutex.Lock()
+	if ps.stopped {
+		ps.mutex.Unlock()
+		return fmt.Errorf("%s is stopped before started", component)
+	}
+
+	ps.cmd = cmd
+	err := cmd.Start()
+	ps.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("fail to start %s: %v", component, err)
+	}
+	logrus.WithField("camera", cameraID).Infoln(component, "started")
+
+	// Once exited, the process must not be killed since its PID might be reused
+	err = cmd.Wait()
+	ps.mutex.Lock()
+	ps.cmd = nil
+	ps.mutex.Unlock()
+
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%v: %s", err, stderr)
+	}
+
+	return err
+}
+
+// kill kills the running process, and prevents the next one from being started.
+func (ps *processSource) kill() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.stopped = true
+	if ps.cmd != nil && ps.cmd.Process != nil {
+		ps.cmd.Process.Kill()
+	}
+}
+
+// reset makes the killed source can be run again.
+func (ps *processSource) reset() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.stopped = false
+	ps.cmd = nil
+}
+
+// childProcess is child process that consumes camera stream.
+type childProcess struct {
+	cameraID     string
+	component    string
+	cmd          *exec.Cmd
+	stderr       *tailBuffer
+	stderrLogger *logs.LineWriter
+	replacer     *strings.Replacer
+}
+
+func newChildProcess(cameraID, component string, cmd *exec.Cmd) *childProcess {
+	stderr := &tailBuffer{}
+	stderrLogger := newStderrWriter(cameraID, component)
+	cmd.Stderr = io.MultiWriter(stderr, stderrLogger)
+
+	return &childProcess{
+		cameraID:     cameraID,
+		component:    component,
+		cmd:          cmd,
+		stderr:       stderr,
+		stderrLogger: stderrLogger,
+	}
+}
+
+func (cp *childProcess) start() error {
+	err := cp.cmd.Start()
+	if err != nil {
+		return fmt.Errorf("fail to start %s: %v", cp.component, err)
+	}
+
+	logrus.WithField("camera", cp.cameraID).Infoln(cp.component, "started")
+	return nil
+}
+
+// redact hides the secrets, e.g. stream key in URL, from the
+// logged stderr and from the error returned by wait.
+func (cp *childProcess) redact(secrets ...string) {
+	var oldnew []string
+	for _, secret := range secrets {
+		if secret != "" {
+			oldnew = append(oldnew, secret, "xxxxx")
+		}
+	}
+
+	cp.replacer = strings.NewReplacer(oldnew...)
+	cp.stderrLogger.Redact(secrets...)
+}
+
+// wait waits until the process exited. The returned error
+// always non nil, since the process should never exit by itself.
+func (cp *childProcess) wait() error {
+	err := cp.cmd.Wait()
+	cp.stderrLogger.Close()
+
+	if err == nil {
+		err = fmt.Errorf("exited")
+	}
+
+	if cp.stderr.Len() > 0 {
+		stderr := cp.stderr.String()
+		if cp.replacer != nil {
+			stderr = cp.replacer.Replace(stderr)
+		}
+
+		return fmt.Errorf("%s stopped: %v: %s", cp.component, err, stderr)
+	}
+
+	return fmt.Errorf("%s stopped: %v", cp.component, err)
+}
+
+func (cp *childProcess) kill() {
+	if cp.cmd.Process != nil {
+		cp.cmd.Process.Kill()
+	}
+}
+
+// newStderrWriter returns writer that logs the stderr of child process line by line.
+func newStderrWriter(cameraID, component string) *logs.LineWriter {
+	return logs.NewLineWriter(logrus.Fields{
+		"camera":    cameraID,
+		"component": component,
+	})
+}
+
+// tailBuffer is writer that only keeps the last few bytes written to it.
+// It's used to keep the last error messages of child process.
+type tailBuffer struct {
+	mutex sync.Mutex
+	buf   []byte
+}
+
+const maxTailBufferSize = 2048
+
+func (tb *tailBuffer) Write(p []byte) (int, error) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.buf = append(tb.buf, p...)
+	if len(tb.buf) > maxTailBufferSize {
+		tb.buf = tb.buf[len(tb.buf)-maxTailBufferSize:]
+	}
+
+	return len(p), nil
+}
+
+func (tb *tailBuffer) Len() int {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return len(tb.buf)
+}
+
+func (tb *tailBuffer) String() string {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return strings.TrimSpace(string(tb.buf))
+}
+
+// rotationFilter returns ffmpeg video filter for rotating the video.
+func rotationFilter(rotation int) string {
+	switch rotation {
+	case 90:
+		return "transpose=1"
+	case 180:
+		return "hflip,vflip"
+	case 270:
+		return "transpose=2"
+	default:
+		return ""
+	}
+}
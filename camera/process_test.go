@@ -0,0 +1,51 @@
+package camera
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestProcessSourceStop(t *testing.T) {
+	var ps processSource
+
+	// Stop request that comes before the process started must not be lost
+	ps.kill()
+	buf := new(bytes.Buffer)
+	err := ps.run("test", "source", exec.Command("echo", "started"), buf)
+	if err == nil || buf.Len() > 0 {
+		t.Fatalf("stopped source is started: %q, %v", buf.String(), err)
+	}
+
+	// Once reset, it can be run again
+	ps.reset()
+	if err = ps.run("test", "source", exec.Command("echo", "started"), buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "started\n" {
+		t.Errorf("got output %q, want %q", buf.String(), "started\n")
+	}
+
+	// Running process is killed
+	ps.reset()
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- ps.run("test", "source", exec.Command("sleep", "60"), new(bytes.Buffer))
+	}()
+
+	for started := false; !started; {
+		time.Sleep(10 * time.Millisecond)
+		ps.mutex.Lock()
+		started = ps.cmd != nil
+		ps.mutex.Unlock()
+	}
+
+	ps.kill()
+	select {
+	case <-chErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("running process is not killed")
+	}
+}
@@ -0,0 +1,142 @@
+package camera
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Profile is named set of camera parameters, e.g. "day" and "night",
+// which can be switched manually or by schedule.
+type Profile struct {
+	Name    string            `json:"name"`
+	Setting map[string]string `json:"setting"`
+}
+
+// ProfileKeys returns keys of the camera setting that stored in profile.
+func ProfileKeys(source Source) []string {
+	keys := []string{"format", "resolution", "fps", "rotation"}
+	for _, param := range source.Capabilities().Parameters {
+		keys = append(keys, param.Key)
+	}
+
+	return keys
+}
+
+// Profiles returns all profiles of the camera, sorted by name.
+func Profiles(db *bolt.DB, cameraID string) []Profile {
+	profiles := []Profile{}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := profileBucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, val []byte) error {
+			profile := Profile{Name: string(key)}
+			if err := json.Unmarshal(val, &profile.Setting); err == nil {
+				profiles = append(profiles, profile)
+			}
+			return nil
+		})
+	})
+
+	return profiles
+}
+
+// SaveProfile saves the profile of camera. If the profile already exists, it will be replaced.
+func SaveProfile(db *bolt.DB, cameraID string, profile Profile) error {
+	if profile.Name == "" {
+		return fmt.Errorf("profile name must not empty")
+	}
+
+	value, err := json.Marshal(&profile.Setting)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		profiles, err := tx.CreateBucketIfNotExists([]byte("profiles"))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := profiles.CreateBucketIfNotExists([]byte(cameraID))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(profile.Name), value)
+	})
+}
+
+// DeleteProfile removes the profile of camera.
+func DeleteProfile(db *bolt.DB, cameraID, name string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := profileBucket(tx, cameraID)
+		if bucket == nil || bucket.Get([]byte(name)) == nil {
+			return fmt.Errorf("profile %s is not exist", name)
+		}
+
+		return bucket.Delete([]byte(name))
+	})
+}
+
+// ActiveProfile returns name of the profile that currently used by camera.
+func ActiveProfile(db *bolt.DB, cameraID string) string {
+	var name string
+	db.View(func(tx *bolt.Tx) error {
+		if bucket := Bucket(tx, cameraID); bucket != nil {
+			name = string(bucket.Get([]byte("profile")))
+		}
+		return nil
+	})
+
+	return name
+}
+
+// ActivateProfile copies the profile into camera setting, then records it in event log.
+// The camera must be restarted for the new setting to take effect.
+func ActivateProfile(db *bolt.DB, cameraID, name, reason string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return fmt.Errorf("camera %s is not exist", cameraID)
+		}
+
+		profiles := profileBucket(tx, cameraID)
+		if profiles == nil || profiles.Get([]byte(name)) == nil {
+			return fmt.Errorf("profile %s is not exist", name)
+		}
+
+		var setting map[string]string
+		err := json.Unmarshal(profiles.Get([]byte(name)), &setting)
+		if err != nil {
+			return err
+		}
+
+		for key, val := range setting {
+			if err = bucket.Put([]byte(key), []byte(val)); err != nil {
+				return err
+			}
+		}
+
+		return bucket.Put([]byte("profile"), []byte(name))
+	})
+	if err != nil {
+		return err
+	}
+
+	RecordEvent(db, cameraID, EventProfile, fmt.Sprintf("profile switched to %s (%s)", name, reason))
+	return nil
+}
+
+func profileBucket(tx *bolt.Tx, cameraID string) *bolt.Bucket {
+	bucket := tx.Bucket([]byte("profiles"))
+	if bucket == nil {
+		return nil
+	}
+
+	return bucket.Bucket([]byte(cameraID))
+}
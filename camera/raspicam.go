@@ -0,0 +1,193 @@
+package camera
+
+import (
+	"io"
+	"os/exec"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RaspiCam is source for Raspberry Pi camera module.
+// It uses raspivid to capture the camera stream.
+type RaspiCam struct {
+	DB       *bolt.DB
+	CameraID string
+
+	processSource
+}
+
+// Name returns the name of the source.
+func (cam *RaspiCam) Name() string {
+	return "raspivid"
+}
+
+// Setting returns the setting that will be used by raspivid.
+func (cam *RaspiCam) Setting() Setting {
+	return loadSetting(cam.DB, cam.CameraID)
+}
+
+// Capabilities returns the settings that supported by Raspberry Pi camera.
+func (cam *RaspiCam) Capabilities() Capabilities {
+	return Capabilities{
+		Resolutions: []string{
+			"640x480", "800x600", "960x720", "1024x768",
+			"1280x960", "1296x972", "1440x1080"},
+		Rotations:  []int{0, 90, 180, 270},
+		MaxFPS:     49,
+		Parameters: raspividParameters,
+	}
+}
+
+// Start runs raspivid and writes its stream to w.
+func (cam *RaspiCam) Start(w io.Writer) error {
+	return cam.run(cam.CameraID, cam.Name(), cam.genCmdRaspivid(cam.Setting()), w)
+}
+
+// Stop kills the raspivid process.
+func (cam *RaspiCam) Stop() {
+	cam.kill()
+}
+
+func (cam *RaspiCam) genCmdRaspivid(setting Setting) *exec.Cmd {
+	if developmentMode {
+		return exec.Command("nc", "-l", "-p", "5000")
+	}
+
+	params := loadParameters(cam.DB, cam.CameraID, raspividParameters)
+	cmdArgs := []string{
+		"-t", "0",
+		"-b", strconv.Itoa(params.Int("bitrate")),
+		"-qp", strconv.Itoa(params.Int("qp")),
+		"-ex", params.String("exposure"),
+		"-awb", params.String("awb"),
+		"-mm", params.String("metering"),
+		"-sh", strconv.Itoa(params.Int("sharpness")),
+		"-co", strconv.Itoa(params.Int("contrast")),
+		"-br", strconv.Itoa(params.Int("brightness")),
+		"-sa", strconv.Itoa(params.Int("saturation")),
+		"-w", strconv.Itoa(setting.Width),
+		"-h", strconv.Itoa(setting.Height),
+		"-fps", strconv.Itoa(setting.FPS),
+		"-rot", strconv.Itoa(setting.Rotation),
+
+		// Keyframe every two seconds, preceded by the parameter sets,
+		// like the other sources, so the late joiner starts quickly
+		"-g", strconv.Itoa(setting.FPS * 2),
+		"-ih"}
+
+	// Annotation with black background
+	if text := params.String("annotation"); text != "" {
+		cmdArgs = append(cmdArgs,
+			"-ae", strconv.Itoa(params.Int("annotationSize")),
+			"-a", "1024",
+			"-a", text)
+	}
+
+	if params.Bool("hflip") {
+		cmdArgs = append(cmdArgs, "-hf")
+	}
+
+	if params.Bool("vflip") {
+		cmdArgs = append(cmdArgs, "-vf")
+	}
+
+	if roi := params.String("roi"); roi != "" {
+		cmdArgs = append(cmdArgs, "-roi", roi)
+	}
+
+	cmdArgs = append(cmdArgs, "-vs", "-o", "-")
+	return exec.Command("raspivid", cmdArgs...)
+}
+
+var raspividParameters = []Parameter{{
+	Key:     "exposure",
+	Label:   "Exposure mode",
+	Type:    ParamEnum,
+	Options: []string{"auto", "night", "nightpreview", "backlight", "spotlight", "sports", "snow", "beach", "verylong", "fixedfps", "antishake", "fireworks"},
+	Default: "night",
+}, {
+	Key:     "awb",
+	Label:   "White balance",
+	Type:    ParamEnum,
+	Options: []string{"off", "auto", "sun", "cloud", "shade", "tungsten", "fluorescent", "incandescent", "flash", "horizon", "greyworld"},
+	Default: "auto",
+}, {
+	Key:     "metering",
+	Label:   "Metering mode",
+	Type:    ParamEnum,
+	Options: []string{"average", "spot", "backlit", "matrix"},
+	Default: "average",
+}, {
+	Key:         "bitrate",
+	Label:       "Bitrate",
+	Type:        ParamInt,
+	Min:         0,
+	Max:         25000000,
+	Default:     "0",
+	Description: "Bits per second, 0 to use quantisation instead",
+}, {
+	Key:         "qp",
+	Label:       "Quantisation",
+	Type:        ParamInt,
+	Min:         0,
+	Max:         40,
+	Default:     "30",
+	Description: "Lower value for better quality, 0 to use bitrate instead",
+}, {
+	Key:     "sharpness",
+	Label:   "Sharpness",
+	Type:    ParamInt,
+	Min:     -100,
+	Max:     100,
+	Default: "0",
+}, {
+	Key:     "contrast",
+	Label:   "Contrast",
+	Type:    ParamInt,
+	Min:     -100,
+	Max:     100,
+	Default: "0",
+}, {
+	Key:     "brightness",
+	Label:   "Brightness",
+	Type:    ParamInt,
+	Min:     0,
+	Max:     100,
+	Default: "50",
+}, {
+	Key:     "saturation",
+	Label:   "Saturation",
+	Type:    ParamInt,
+	Min:     -100,
+	Max:     100,
+	Default: "0",
+}, {
+	Key:         "annotation",
+	Label:       "Annotation",
+	Type:        ParamText,
+	Default:     "%Y-%m-%d %X",
+	Description: "Text on top of the video, supports strftime format",
+}, {
+	Key:     "annotationSize",
+	Label:   "Annotation size",
+	Type:    ParamInt,
+	Min:     6,
+	Max:     160,
+	Default: "16",
+}, {
+	Key:     "hflip",
+	Label:   "Horizontal flip",
+	Type:    ParamBool,
+	Default: "off",
+}, {
+	Key:     "vflip",
+	Label:   "Vertical flip",
+	Type:    ParamBool,
+	Default: "off",
+}, {
+	Key:         "roi",
+	Label:       "Region of interest",
+	Type:        ParamROI,
+	Description: "Normalized x,y,w,h of the sensor, empty for full frame",
+}}
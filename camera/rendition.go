@@ -0,0 +1,180 @@
+package camera
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	lowRenditionDir     = "low"
+	defaultLowWidth     = 480
+	defaultLowBitrate   = 300
+	estimatedBitsPerPix = 0.1
+)
+
+// LowRendition is the setting for low resolution live stream,
+// which used by client with slow connection.
+type LowRendition struct {
+	Enabled bool
+	Width   int
+	Bitrate int
+}
+
+// LiveVariant is a variant of live stream, listed in HLS master playlist.
+type LiveVariant struct {
+	Name      string
+	Bandwidth int
+	Width     int
+	Height    int
+}
+
+// validateLowRendition checks the low rendition setting before it's saved.
+func validateLowRendition(setting map[string]string) error {
+	if err := validateSwitch("low rendition", setting["lowRendition"]); err != nil {
+		return err
+	}
+
+	if setting["lowWidth"] != "" {
+		if width, err := strconv.Atoi(setting["lowWidth"]); err != nil || width < 160 || width > 1920 {
+			return fmt.Errorf("low rendition width must be between 160 and 1920")
+		}
+	}
+
+	if setting["lowBitrate"] != "" {
+		if bitrate, err := strconv.Atoi(setting["lowBitrate"]); err != nil || bitrate < 100 || bitrate > 5000 {
+			return fmt.Errorf("low rendition bitrate must be between 100 and 5000 kbps")
+		}
+	}
+
+	return nil
+}
+
+// loadLowRendition loads the low rendition setting of camera from database.
+func loadLowRendition(db *bolt.DB, cameraID string) LowRendition {
+	rendition := LowRendition{
+		Width:   defaultLowWidth,
+		Bitrate: defaultLowBitrate,
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		rendition.Enabled = string(bucket.Get([]byte("lowRendition"))) == "on"
+
+		if width, err := strconv.Atoi(string(bucket.Get([]byte("lowWidth")))); err == nil && width > 0 {
+			rendition.Width = width
+		}
+
+		if bitrate, err := strconv.Atoi(string(bucket.Get([]byte("lowBitrate")))); err == nil && bitrate > 0 {
+			rendition.Bitrate = bitrate
+		}
+
+		return nil
+	})
+
+	return rendition
+}
+
+// LiveVariants returns the variants of live stream which currently available.
+// The main variant is always listed, while the low one only listed once its
+// playlist has been created.
+func (cam *Camera) LiveVariants() []LiveVariant {
+	setting := cam.Source.Setting()
+	audioBitrate := 0
+	if audio := loadAudioSetting(cam.DB, cam.ID); audio.enabled() {
+		audioBitrate = audio.Bitrate * 1000
+	}
+
+	// Bitrate of the main stream is not known, so just estimate it
+	variants := []LiveVariant{{
+		Name:      "main",
+		Bandwidth: int(float64(setting.Width*setting.Height*setting.FPS)*estimatedBitsPerPix) + audioBitrate,
+		Width:     setting.Width,
+		Height:    setting.Height,
+	}}
+
+	rendition := loadLowRendition(cam.DB, cam.ID)
+	lowPlaylist := fp.Join(cam.HlsSegmentsDir, lowRenditionDir, "playlist.m3u8")
+	if _, err := os.Stat(lowPlaylist); !rendition.Enabled || err != nil {
+		return variants
+	}
+
+	width, height := rendition.size(setting)
+	return append(variants, LiveVariant{
+		Name:      lowRenditionDir,
+		Bandwidth: rendition.Bitrate*1000 + audioBitrate,
+		Width:     width,
+		Height:    height,
+	})
+}
+
+// LowSegmentsDir returns directory for segments of the low rendition.
+func (cam *Camera) LowSegmentsDir() string {
+	return fp.Join(cam.HlsSegmentsDir, lowRenditionDir)
+}
+
+// size returns frame size of the low rendition, keeping the aspect ratio of
+// the main stream. The rendition is never larger than the main stream.
+func (lr LowRendition) size(setting Setting) (int, int) {
+	width := lr.Width
+	if width > setting.Width {
+		width = setting.Width
+	}
+
+	height := setting.Height
+	if setting.Width > 0 {
+		height = width * setting.Height / setting.Width
+	}
+
+	// Encoder needs even size
+	return width &^ 1, height &^ 1
+}
+
+// genCmdLowRendition creates command that re-encodes the stream into low
+// resolution HLS, with keyframe every two seconds to make it seekable.
+func (cam *Camera) genCmdLowRendition(setting Setting, rendition LowRendition, withAudio bool) *exec.Cmd {
+	cmdArgs := []string{"-y",
+		"-loglevel", "error",
+		"-framerate", strconv.Itoa(setting.FPS),
+		"-i", "pipe:0"}
+
+	if withAudio {
+		cmdArgs = append(cmdArgs, audioInputArgs...)
+		cmdArgs = append(cmdArgs,
+			"-map", "0:v",
+			"-map", "1:a",
+			"-codec:a", "copy")
+	}
+
+	width, height := rendition.size(setting)
+	bitrate := strconv.Itoa(rendition.Bitrate) + "k"
+	playlistPath := fp.Join(cam.LowSegmentsDir(), "playlist.m3u8")
+	segmentPath := fp.Join(cam.LowSegmentsDir(), "%d.ts")
+	cmdArgs = append(cmdArgs,
+		"-vf", "scale="+strconv.Itoa(width)+":"+strconv.Itoa(height),
+		"-codec:v", "libx264",
+		"-preset", "ultrafast",
+		"-tune", "zerolatency",
+		"-pix_fmt", "yuv420p",
+		"-b:v", bitrate,
+		"-maxrate", bitrate,
+		"-bufsize", strconv.Itoa(rendition.Bitrate*2)+"k",
+		"-g", strconv.Itoa(setting.FPS*2),
+		"-hls_wrap", "10",
+		"-hls_list_size", "10",
+		"-hls_base_url", "/live/"+cam.ID+"/low/stream/",
+		"-hls_segment_filename", segmentPath,
+		"-hls_segment_type", "mpegts",
+		"-hls_flags", "delete_segments+temp_file",
+		playlistPath)
+
+	return exec.Command("ffmpeg", cmdArgs...)
+}
@@ -0,0 +1,328 @@
+package camera
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// RestreamTarget is external server that the live stream pushed to,
+// e.g. YouTube or Twitch ingest server, or SRT listener.
+type RestreamTarget struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Key     string `json:"key"`
+	Enabled bool   `json:"enabled"`
+}
+
+// RestreamStatus is the running status of restream target.
+type RestreamStatus struct {
+	Name          string    `json:"name"`
+	Enabled       bool      `json:"enabled"`
+	Running       bool      `json:"running"`
+	StartTime     time.Time `json:"startTime"`
+	Reconnects    int       `json:"reconnects"`
+	LastError     string    `json:"lastError"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+}
+
+// restreams is the restreamers of camera, one for each enabled target.
+type restreams struct {
+	mutex       sync.Mutex
+	restreamers []*restreamer
+}
+
+// restreamer pushes the live stream to a target. If the connection failed,
+// it will be reconnected with exponential backoff until it's stopped.
+type restreamer struct {
+	cam    *Camera
+	target RestreamTarget
+	mutex  sync.Mutex
+	status RestreamStatus
+	chStop chan struct{}
+}
+
+// Validate checks whether the target is usable.
+func (rt RestreamTarget) Validate() error {
+	if strings.TrimSpace(rt.Name) == "" {
+		return fmt.Errorf("restream name must not empty")
+	}
+
+	u, err := url.Parse(rt.URL)
+	if err != nil {
+		return fmt.Errorf("invalid restream URL for %s: %v", rt.Name, err)
+	}
+
+	switch u.Scheme {
+	case "rtmp", "rtmps", "srt":
+	default:
+		return fmt.Errorf("restream URL for %s must be RTMP or SRT", rt.Name)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("restream URL for %s doesn't have host", rt.Name)
+	}
+
+	return nil
+}
+
+// outputURL returns the URL that used by ffmpeg. For RTMP the key is the last
+// part of path, while for SRT it's given as stream ID.
+func (rt RestreamTarget) outputURL() string {
+	if rt.Key == "" {
+		return rt.URL
+	}
+
+	if strings.HasPrefix(rt.URL, "srt:") {
+		u, _ := url.Parse(rt.URL)
+		query := u.Query()
+		query.Set("streamid", rt.Key)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	return strings.TrimSuffix(rt.URL, "/") + "/" + rt.Key
+}
+
+// secrets returns the parts of output URL that must not be logged,
+// i.e. the stream key and the password, as it is and URL escaped.
+func (rt RestreamTarget) secrets() []string {
+	rawSecrets := []string{rt.Key}
+	if u, err := url.Parse(rt.URL); err == nil {
+		password, _ := u.User.Password()
+		rawSecrets = append(rawSecrets, password, u.Query().Get("passphrase"))
+	}
+
+	var secrets []string
+	for _, secret := range rawSecrets {
+		if secret != "" {
+			secrets = append(secrets, secret, url.QueryEscape(secret), url.PathEscape(secret))
+		}
+	}
+
+	return secrets
+}
+
+// outputFormat returns ffmpeg muxer for the target.
+func (rt RestreamTarget) outputFormat() string {
+	if strings.HasPrefix(rt.URL, "srt:") {
+		return "mpegts"
+	}
+
+	return "flv"
+}
+
+// RestreamTargets returns the restream targets of camera.
+func RestreamTargets(db *bolt.DB, cameraID string) []RestreamTarget {
+	targets := []RestreamTarget{}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("restreams"))
+		if bucket == nil {
+			return nil
+		}
+
+		if val := bucket.Get([]byte(cameraID)); val != nil {
+			json.Unmarshal(val, &targets)
+		}
+		return nil
+	})
+
+	return targets
+}
+
+// SaveRestreamTargets validates then saves the restream targets of camera.
+// The name of targets must be unique, since it's used to report their status.
+func SaveRestreamTargets(db *bolt.DB, cameraID string, targets []RestreamTarget) error {
+	names := make(map[string]struct{})
+	for _, target := range targets {
+		if err := target.Validate(); err != nil {
+			return err
+		}
+
+		if _, exist := names[target.Name]; exist {
+			return fmt.Errorf("restream %s already exists", target.Name)
+		}
+		names[target.Name] = struct{}{}
+	}
+
+	value, err := json.Marshal(&targets)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("restreams"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(cameraID), value)
+	})
+}
+
+// ReloadRestreams stops the running restreams, then starts the enabled targets.
+// The restreams read the live stream on demand, so the camera is not restarted.
+func (cam *Camera) ReloadRestreams() {
+	cam.stopRestreams()
+
+	cam.restream.mutex.Lock()
+	defer cam.restream.mutex.Unlock()
+
+	// Camera already stopped, so nothing to restream
+	select {
+	case <-cam.stopChannel():
+		return
+	default:
+	}
+
+	for _, target := range RestreamTargets(cam.DB, cam.ID) {
+		rs := &restreamer{
+			cam:    cam,
+			target: target,
+			status: RestreamStatus{Name: target.Name, Enabled: target.Enabled},
+			chStop: make(chan struct{}),
+		}
+
+		if target.Enabled {
+			go rs.run()
+		}
+
+		cam.restream.restreamers = append(cam.restream.restreamers, rs)
+	}
+}
+
+// RestreamStatuses returns the status of all restream targets.
+func (cam *Camera) RestreamStatuses() []RestreamStatus {
+	cam.restream.mutex.Lock()
+	defer cam.restream.mutex.Unlock()
+
+	statuses := []RestreamStatus{}
+	for _, rs := range cam.restream.restreamers {
+		rs.mutex.Lock()
+		statuses = append(statuses, rs.status)
+		rs.mutex.Unlock()
+	}
+
+	return statuses
+}
+
+func (cam *Camera) stopRestreams() {
+	cam.restream.mutex.Lock()
+	defer cam.restream.mutex.Unlock()
+
+	for _, rs := range cam.restream.restreamers {
+		close(rs.chStop)
+	}
+
+	cam.restream.restreamers = nil
+}
+
+// run keeps pushing the stream to target until the restreamer stopped.
+func (rs *restreamer) run() {
+	logger := logrus.WithField("camera", rs.cam.ID)
+	delay := minRestartDelay
+	for {
+		rs.mutex.Lock()
+		rs.status.Running = true
+		rs.status.StartTime = time.Now()
+		rs.mutex.Unlock()
+
+		logger.Infoln("restream started to", rs.target.Name)
+		err := rs.push()
+
+		rs.mutex.Lock()
+		rs.status.Running = false
+		runDuration := time.Since(rs.status.StartTime)
+		if err != nil {
+			rs.status.LastError = err.Error()
+			rs.status.LastErrorTime = time.Now()
+		}
+		rs.mutex.Unlock()
+
+		select {
+		case <-rs.chStop:
+			logger.Infoln("restream stopped to", rs.target.Name)
+			return
+		default:
+		}
+
+		if runDuration > stableDuration {
+			delay = minRestartDelay
+		}
+
+		logger.Warnf("restream to %s failed (%v), reconnecting in %s", rs.target.Name, err, delay)
+		select {
+		case <-rs.chStop:
+			return
+		case <-time.After(delay):
+		}
+
+		rs.mutex.Lock()
+		rs.status.Reconnects++
+		rs.mutex.Unlock()
+
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// push runs the encoder and writes the live stream into it, until the
+// encoder exited or the restreamer stopped.
+func (rs *restreamer) push() error {
+	cmd := rs.genCmdRestream(rs.cam.Source.Setting())
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// The output URL contains the stream key, so keep it out of the logs
+	process := newChildProcess(rs.cam.ID, "restream", cmd)
+	process.redact(rs.target.secrets()...)
+	if err = process.start(); err != nil {
+		stdin.Close()
+		return err
+	}
+
+	chExit := make(chan error, 1)
+	go func() {
+		chExit <- process.wait()
+	}()
+
+	reader := rs.cam.hub.attachWriter(stdin)
+
+	select {
+	case <-rs.chStop:
+		process.kill()
+		err = <-chExit
+	case err = <-chExit:
+	}
+
+	rs.cam.hub.detach(reader)
+	return err
+}
+
+// genCmdRestream creates command that pushes the stream to target as it is,
+// without audio since the audio is only available for HLS and recording.
+// ffmpeg can only receive the output URL from its arguments, so the stream
+// key is visible in the process list. It's mentioned in the setting page.
+func (rs *restreamer) genCmdRestream(setting Setting) *exec.Cmd {
+	return exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-framerate", strconv.Itoa(setting.FPS),
+		"-f", "h264",
+		"-i", "pipe:0",
+		"-an",
+		"-codec:v", "copy",
+		"-f", rs.target.outputFormat(),
+		rs.target.outputURL())
+}
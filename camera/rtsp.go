@@ -0,0 +1,324 @@
+package camera
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/auth"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// RTSPRealm is the realm for digest authentication of RTSP server.
+const RTSPRealm = "Cygnus"
+
+const rtspPayloadType = 96
+
+// RTSPServer re-publishes the live stream of cameras over RTSP, so Cygnus
+// can be used as IP camera by third party recorders. Each camera is served
+// at rtsp://host:port/<camera ID>, and the stream is sent as it is without
+// re-encoding. Clients are authenticated by digest authentication using the
+// web users. Only TCP transport is supported.
+type RTSPServer struct {
+	DB      *bolt.DB
+	Address string
+	Cameras []*Camera
+
+	server  *gortsplib.Server
+	mutex   sync.Mutex
+	streams map[string]*rtspStream
+	chStop  chan struct{}
+}
+
+// rtspStream is the RTSP stream of a camera. Its description contains
+// the parameter sets, so it's recreated when they are changed.
+type rtspStream struct {
+	stream  *gortsplib.ServerStream
+	media   *description.Media
+	encoder *rtph264.Encoder
+	sps     []byte
+	pps     []byte
+	start   time.Time
+}
+
+// Start starts the RTSP server in background.
+func (rs *RTSPServer) Start() error {
+	rs.streams = make(map[string]*rtspStream)
+	rs.chStop = make(chan struct{})
+	rs.server = &gortsplib.Server{
+		Handler:     rs,
+		RTSPAddress: rs.Address,
+	}
+
+	if err := rs.server.Start(); err != nil {
+		return err
+	}
+
+	for _, cam := range rs.Cameras {
+		go rs.runCamera(cam)
+	}
+
+	logrus.Println("rtsp server started in " + rs.Address)
+	return nil
+}
+
+// Close stops the server and disconnects all clients.
+func (rs *RTSPServer) Close() {
+	close(rs.chStop)
+	rs.server.Close()
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	for id, stream := range rs.streams {
+		stream.stream.Close()
+		delete(rs.streams, id)
+	}
+}
+
+// OnConnOpen is called by RTSP server when client connected.
+func (rs *RTSPServer) OnConnOpen(ctx *gortsplib.ServerHandlerOnConnOpenCtx) {
+	// Every connection has its own nonce for digest authentication
+	nonce, err := auth.GenerateNonce()
+	if err != nil {
+		logrus.Warnln("rtsp: failed to generate nonce:", err)
+		ctx.Conn.Close()
+		return
+	}
+
+	ctx.Conn.SetUserData(nonce)
+}
+
+// OnDescribe is called by RTSP server when client asks the stream description.
+func (rs *RTSPServer) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return rs.serveStream(ctx.Conn, ctx.Request, ctx.Path)
+}
+
+// OnSetup is called by RTSP server when client setups the stream.
+func (rs *RTSPServer) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return rs.serveStream(ctx.Conn, ctx.Request, ctx.Path)
+}
+
+// OnPlay is called by RTSP server when client starts playing the stream.
+func (rs *RTSPServer) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+// serveStream returns the stream of camera in the request path to authenticated client.
+func (rs *RTSPServer) serveStream(conn *gortsplib.ServerConn, req *base.Request, path string) (*base.Response, *gortsplib.ServerStream, error) {
+	if res := rs.authenticate(conn, req); res != nil {
+		return res, nil, nil
+	}
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	stream, exist := rs.streams[strings.TrimPrefix(path, "/")]
+	if !exist {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
+	}
+
+	return &base.Response{StatusCode: base.StatusOK}, stream.stream, nil
+}
+
+// authenticate validates the digest authentication of request.
+// Returns the response for client if it's not authenticated.
+func (rs *RTSPServer) authenticate(conn *gortsplib.ServerConn, req *base.Request) *base.Response {
+	nonce, _ := conn.UserData().(string)
+	unauthorized := &base.Response{
+		StatusCode: base.StatusUnauthorized,
+		Header: base.Header{
+			"WWW-Authenticate": auth.GenerateWWWAuthenticate(
+				[]auth.ValidateMethod{auth.ValidateMethodDigestMD5}, RTSPRealm, nonce),
+		},
+	}
+
+	var authorization headers.Authorization
+	if err := authorization.Unmarshal(req.Header["Authorization"]); err != nil {
+		return unauthorized
+	}
+
+	isMD5 := authorization.Algorithm == nil || *authorization.Algorithm == headers.AuthAlgorithmMD5
+	if authorization.Method != headers.AuthMethodDigest || !isMD5 ||
+		authorization.Nonce != nonce || authorization.Realm != RTSPRealm {
+		return unauthorized
+	}
+
+	ha1, found := rtspCredential(rs.DB, authorization.Username)
+	expected := md5Hex(ha1 + ":" + nonce + ":" + md5Hex(string(req.Method)+":"+authorization.URI))
+	if !found || authorization.Response != expected {
+		logrus.Warnf("rtsp: authentication failed for user %q from %s",
+			authorization.Username, conn.NetConn().RemoteAddr())
+		return unauthorized
+	}
+
+	return nil
+}
+
+// runCamera sends the live stream of camera to RTSP clients until the server stopped.
+func (rs *RTSPServer) runCamera(cam *Camera) {
+	reader := cam.hub.attach()
+	defer cam.hub.detach(reader)
+
+	var tracker h264Tracker
+	for {
+		select {
+		case <-rs.chStop:
+			return
+		case unit := <-reader.chUnit:
+			var frame [][]byte
+			for _, nal := range unit.nals {
+				tracker.track(nal)
+				_, header := nalType(nal)
+				frame = append(frame, bytes.TrimRight(nal[header:], "\x00"))
+			}
+
+			rs.writeFrame(cam.ID, frame, time.Now(), tracker.parameterSets())
+		}
+	}
+}
+
+func (rs *RTSPServer) writeFrame(cameraID string, frame [][]byte, frameTime time.Time, parameterSets [][]byte) {
+	if len(parameterSets) == 0 {
+		return
+	}
+
+	_, spsHeader := nalType(parameterSets[0])
+	_, ppsHeader := nalType(parameterSets[1])
+	sps := parameterSets[0][spsHeader:]
+	pps := parameterSets[1][ppsHeader:]
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	select {
+	case <-rs.chStop:
+		return
+	default:
+	}
+
+	// Create the stream, or recreate it if the parameter sets changed
+	stream, exist := rs.streams[cameraID]
+	if exist && (!bytes.Equal(stream.sps, sps) || !bytes.Equal(stream.pps, pps)) {
+		stream.stream.Close()
+		exist = false
+	}
+
+	if !exist {
+		var err error
+		stream, err = rs.newStream(sps, pps)
+		if err != nil {
+			logrus.WithField("camera", cameraID).Warnln("rtsp: failed to create stream:", err)
+			delete(rs.streams, cameraID)
+			return
+		}
+
+		rs.streams[cameraID] = stream
+	}
+
+	packets, err := stream.encoder.Encode(frame)
+	if err != nil {
+		logrus.WithField("camera", cameraID).Warnln("rtsp: failed to encode frame:", err)
+		return
+	}
+
+	timestamp := uint32(frameTime.Sub(stream.start) * 90000 / time.Second)
+	for _, packet := range packets {
+		packet.Timestamp = timestamp
+		stream.stream.WritePacketRTPWithNTP(stream.media, packet, frameTime)
+	}
+}
+
+func (rs *RTSPServer) newStream(sps, pps []byte) (*rtspStream, error) {
+	h264 := &format.H264{
+		PayloadTyp:        rtspPayloadType,
+		SPS:               append([]byte(nil), sps...),
+		PPS:               append([]byte(nil), pps...),
+		PacketizationMode: 1,
+	}
+
+	encoder, err := h264.CreateEncoder()
+	if err != nil {
+		return nil, err
+	}
+
+	media := &description.Media{
+		Type:    description.MediaTypeVideo,
+		Formats: []format.Format{h264},
+	}
+
+	return &rtspStream{
+		stream:  gortsplib.NewServerStream(rs.server, &description.Session{Medias: []*description.Media{media}}),
+		media:   media,
+		encoder: encoder,
+		sps:     h264.SPS,
+		pps:     h264.PPS,
+		start:   time.Now(),
+	}, nil
+}
+
+// UserDigest returns the digest of user's password for digest authentication of
+// RTSP server. It's saved along with the bcrypt hash whenever web user is added,
+// since the RTSP clients can't be authenticated using the bcrypt hash.
+func UserDigest(username, password string) string {
+	return md5Hex(username + ":" + RTSPRealm + ":" + password)
+}
+
+// UsersWithoutDigest returns the name of web users that don't have the digest
+// of their password, i.e. users that added before RTSP server available. They
+// can't access RTSP server until their password is set again.
+func UsersWithoutDigest(db *bolt.DB) []string {
+	users := []string{}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("user"))
+		if bucket == nil {
+			return nil
+		}
+
+		digestBucket := tx.Bucket([]byte("user-digest"))
+		return bucket.ForEach(func(key, val []byte) error {
+			if digestBucket == nil || digestBucket.Get(key) == nil {
+				users = append(users, string(key))
+			}
+			return nil
+		})
+	})
+
+	return users
+}
+
+// rtspCredential returns the digest of web user's password. Unlike the web
+// interface there is no default account, so nobody allowed until user added.
+func rtspCredential(db *bolt.DB, username string) (string, bool) {
+	ha1 := ""
+	found := false
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("user"))
+		digestBucket := tx.Bucket([]byte("user-digest"))
+		if bucket == nil || digestBucket == nil || bucket.Get([]byte(username)) == nil {
+			return nil
+		}
+
+		if val := digestBucket.Get([]byte(username)); val != nil {
+			ha1, found = string(val), true
+		}
+		return nil
+	})
+
+	return ha1, found
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
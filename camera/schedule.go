@@ -0,0 +1,223 @@
+package camera
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Special time of schedule entry, which depends on the location.
+const (
+	ScheduleSunrise = "sunrise"
+	ScheduleSunset  = "sunset"
+)
+
+// maxScheduleOffset is the max offset of schedule entry, in minutes. It's less than a day,
+// so the entry only moves to the previous or the next day.
+const maxScheduleOffset = 24*60 - 1
+
+// ScheduleEntry is a time when camera switched to a profile. Time is either
+// fixed time of day in "15:04" format, "sunrise" or "sunset". Offset is
+// minutes added to the time, e.g. -30 for half an hour before sunset.
+type ScheduleEntry struct {
+	Time    string `json:"time"`
+	Offset  int    `json:"offset"`
+	Profile string `json:"profile"`
+}
+
+// Scheduler switches the active profile of cameras following their schedules.
+// The profile is only switched when the scheduled time is reached, so manually
+// activated profile is kept until the next entry of schedule.
+type Scheduler struct {
+	DB        *bolt.DB
+	Location  *Location
+	ChRestart chan bool
+
+	mutex    sync.Mutex
+	lastTime map[string]time.Time
+}
+
+// Schedule returns the profile schedule of camera.
+func Schedule(db *bolt.DB, cameraID string) []ScheduleEntry {
+	entries := []ScheduleEntry{}
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("schedules"))
+		if bucket == nil {
+			return nil
+		}
+
+		if val := bucket.Get([]byte(cameraID)); val != nil {
+			json.Unmarshal(val, &entries)
+		}
+		return nil
+	})
+
+	return entries
+}
+
+// SaveSchedule validates then saves the profile schedule of camera.
+func (s *Scheduler) SaveSchedule(cameraID string, entries []ScheduleEntry) error {
+	profiles := Profiles(s.DB, cameraID)
+	for _, entry := range entries {
+		if err := s.validateEntry(entry); err != nil {
+			return err
+		}
+
+		found := false
+		for _, profile := range profiles {
+			if profile.Name == entry.Profile {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("profile %s is not exist", entry.Profile)
+		}
+	}
+
+	value, err := json.Marshal(&entries)
+	if err != nil {
+		return err
+	}
+
+	err = s.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("schedules"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(cameraID), value)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Apply the new schedule on next check
+	s.mutex.Lock()
+	delete(s.lastTime, cameraID)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Run checks the schedules every minute. It never returns, so it should be run in goroutine.
+func (s *Scheduler) Run() {
+	for {
+		s.check(time.Now())
+		time.Sleep(time.Minute)
+	}
+}
+
+func (s *Scheduler) check(now time.Time) {
+	s.mutex.Lock()
+	if s.lastTime == nil {
+		s.lastTime = make(map[string]time.Time)
+	}
+
+	restart := false
+	for _, cameraID := range IDs(s.DB) {
+		entry, entryTime, found := s.currentEntry(Schedule(s.DB, cameraID), now)
+		if !found || entryTime.Equal(s.lastTime[cameraID]) {
+			continue
+		}
+
+		s.lastTime[cameraID] = entryTime
+		if entry.Profile == ActiveProfile(s.DB, cameraID) {
+			continue
+		}
+
+		err := ActivateProfile(s.DB, cameraID, entry.Profile, "scheduled at "+entryTime.Format("15:04"))
+		if err != nil {
+			logrus.WithField("camera", cameraID).Warnln("failed to switch profile:", err)
+			continue
+		}
+
+		restart = true
+	}
+	s.mutex.Unlock()
+
+	// Profile is applied when the cameras are started again
+	if restart {
+		s.ChRestart <- true
+	}
+}
+
+// currentEntry returns the entry which time has been reached most recently.
+// The entries of yesterday are checked as well, in case today's first entry
+// hasn't been reached yet, and so are the entries of tomorrow, since negative
+// offset might move them to today.
+func (s *Scheduler) currentEntry(entries []ScheduleEntry, now time.Time) (ScheduleEntry, time.Time, bool) {
+	var current ScheduleEntry
+	var currentTime time.Time
+
+	for _, day := range []time.Time{now.AddDate(0, 0, -1), now, now.AddDate(0, 0, 1)} {
+		for _, entry := range entries {
+			entryTime, err := s.parseEntry(entry, day)
+			if err != nil || entryTime.After(now) || entryTime.Before(currentTime) {
+				continue
+			}
+
+			current, currentTime = entry, entryTime
+		}
+	}
+
+	return current, currentTime, !currentTime.IsZero()
+}
+
+// validateEntry checks the schedule entry before it's saved. Sunrise and sunset
+// are valid even if the sun doesn't rise today, e.g. in polar day, since it will
+// rise again someday.
+func (s *Scheduler) validateEntry(entry ScheduleEntry) error {
+	if entry.Offset < -maxScheduleOffset || entry.Offset > maxScheduleOffset {
+		return fmt.Errorf("schedule offset must be between %d and %d minutes", -maxScheduleOffset, maxScheduleOffset)
+	}
+
+	switch entry.Time {
+	case ScheduleSunrise, ScheduleSunset:
+		if s.Location == nil {
+			return fmt.Errorf("location is not configured, %s can't be used", entry.Time)
+		}
+	default:
+		if _, err := time.Parse("15:04", entry.Time); err != nil {
+			return fmt.Errorf("schedule time must be HH:MM, %s or %s", ScheduleSunrise, ScheduleSunset)
+		}
+	}
+
+	return nil
+}
+
+// parseEntry returns the time of schedule entry in the specified day.
+func (s *Scheduler) parseEntry(entry ScheduleEntry, day time.Time) (time.Time, error) {
+	if err := s.validateEntry(entry); err != nil {
+		return time.Time{}, err
+	}
+
+	var entryTime time.Time
+	switch entry.Time {
+	case ScheduleSunrise, ScheduleSunset:
+		sunrise, sunset, ok := sunTimes(day, *s.Location)
+		if !ok {
+			return time.Time{}, fmt.Errorf("there are no %s in %s", entry.Time, day.Format("2006-01-02"))
+		}
+
+		entryTime = sunrise
+		if entry.Time == ScheduleSunset {
+			entryTime = sunset
+		}
+
+		// Seconds are dropped, so the time is stable between checks
+		entryTime = entryTime.Truncate(time.Minute)
+	default:
+		clock, _ := time.Parse("15:04", entry.Time)
+
+		entryTime = time.Date(day.Year(), day.Month(), day.Day(),
+			clock.Hour(), clock.Minute(), 0, 0, day.Location())
+	}
+
+	return entryTime.Add(time.Duration(entry.Offset) * time.Minute), nil
+}
@@ -0,0 +1,160 @@
+package camera
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCurrentEntry(t *testing.T) {
+	london := &Location{Latitude: 51.5074, Longitude: -0.1278}
+	tromso := &Location{Latitude: 69.6492, Longitude: 18.9553}
+	dayNight := []ScheduleEntry{
+		{Time: "07:00", Profile: "day"},
+		{Time: "19:00", Profile: "night"},
+	}
+
+	tests := []struct {
+		name        string
+		location    *Location
+		entries     []ScheduleEntry
+		now         time.Time
+		wantProfile string
+		wantTime    time.Time
+		wantFound   bool
+	}{{
+		name:      "no entries",
+		entries:   nil,
+		now:       testTime(2020, 1, 2, 12, 0, 7),
+		wantFound: false,
+	}, {
+		name:        "entry of today",
+		entries:     dayNight,
+		now:         testTime(2020, 1, 2, 8, 0, 7),
+		wantProfile: "day",
+		wantTime:    testTime(2020, 1, 2, 7, 0, 7),
+		wantFound:   true,
+	}, {
+		name:        "entry time is reached",
+		entries:     dayNight,
+		now:         testTime(2020, 1, 2, 19, 0, 7),
+		wantProfile: "night",
+		wantTime:    testTime(2020, 1, 2, 19, 0, 7),
+		wantFound:   true,
+	}, {
+		name:        "entry of yesterday before today's first entry",
+		entries:     dayNight,
+		now:         testTime(2020, 1, 2, 3, 0, 7),
+		wantProfile: "night",
+		wantTime:    testTime(2020, 1, 1, 19, 0, 7),
+		wantFound:   true,
+	}, {
+		name: "negative offset moves tomorrow's entry to today",
+		entries: []ScheduleEntry{
+			{Time: "07:00", Profile: "day"},
+			{Time: "00:30", Offset: -60, Profile: "night"},
+		},
+		now:         testTime(2020, 1, 1, 23, 45, 7),
+		wantProfile: "night",
+		wantTime:    testTime(2020, 1, 1, 23, 30, 7),
+		wantFound:   true,
+	}, {
+		name: "positive offset moves yesterday's entry to today",
+		entries: []ScheduleEntry{
+			{Time: "07:00", Profile: "day"},
+			{Time: "23:30", Offset: 60, Profile: "night"},
+		},
+		now:         testTime(2020, 1, 2, 0, 45, 7),
+		wantProfile: "night",
+		wantTime:    testTime(2020, 1, 2, 0, 30, 7),
+		wantFound:   true,
+	}, {
+		name: "positive offset not reached yet",
+		entries: []ScheduleEntry{
+			{Time: "07:00", Profile: "day"},
+			{Time: "23:30", Offset: 60, Profile: "night"},
+		},
+		now:         testTime(2020, 1, 2, 0, 15, 7),
+		wantProfile: "day",
+		wantTime:    testTime(2020, 1, 1, 7, 0, 7),
+		wantFound:   true,
+	}, {
+		name:     "sunset with offset",
+		location: london,
+		entries: []ScheduleEntry{
+			{Time: ScheduleSunrise, Profile: "day"},
+			{Time: ScheduleSunset, Offset: -30, Profile: "night"},
+		},
+		now:         testTime(2020, 6, 21, 21, 0, 1),
+		wantProfile: "night",
+		wantTime:    testTime(2020, 6, 21, 20, 51, 1),
+		wantFound:   true,
+	}, {
+		name:     "sunrise of today",
+		location: london,
+		entries: []ScheduleEntry{
+			{Time: ScheduleSunrise, Profile: "day"},
+			{Time: ScheduleSunset, Offset: -30, Profile: "night"},
+		},
+		now:         testTime(2020, 6, 21, 5, 0, 1),
+		wantProfile: "day",
+		wantTime:    testTime(2020, 6, 21, 4, 43, 1),
+		wantFound:   true,
+	}, {
+		name:     "polar day skips sunrise",
+		location: tromso,
+		entries: []ScheduleEntry{
+			{Time: ScheduleSunrise, Profile: "day"},
+			{Time: "22:00", Profile: "night"},
+		},
+		now:         testTime(2020, 6, 21, 12, 0, 2),
+		wantProfile: "night",
+		wantTime:    testTime(2020, 6, 20, 22, 0, 2),
+		wantFound:   true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scheduler{Location: tt.location}
+			entry, entryTime, found := s.currentEntry(tt.entries, tt.now)
+			if found != tt.wantFound {
+				t.Fatalf("got found %v, want %v", found, tt.wantFound)
+			}
+
+			if !found {
+				return
+			}
+
+			if entry.Profile != tt.wantProfile || !entryTime.Equal(tt.wantTime) {
+				t.Errorf("got (%s, %s), want (%s, %s)", entry.Profile, entryTime, tt.wantProfile, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestValidateEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		location *Location
+		entry    ScheduleEntry
+		wantErr  bool
+	}{
+		{"fixed time", nil, ScheduleEntry{Time: "07:30"}, false},
+		{"fixed time with offset", nil, ScheduleEntry{Time: "00:30", Offset: -60}, false},
+		{"invalid time", nil, ScheduleEntry{Time: "25:00"}, true},
+		{"sunrise without location", nil, ScheduleEntry{Time: ScheduleSunrise}, true},
+		{"sunset with location", &Location{Latitude: 51.5, Longitude: 0}, ScheduleEntry{Time: ScheduleSunset, Offset: -30}, false},
+		{"sunrise in polar region", &Location{Latitude: 89, Longitude: 0}, ScheduleEntry{Time: ScheduleSunrise}, false},
+		{"offset of a day", nil, ScheduleEntry{Time: "07:00", Offset: 24 * 60}, true},
+		{"negative offset of a day", nil, ScheduleEntry{Time: "07:00", Offset: -24 * 60}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scheduler{Location: tt.location}
+			err := s.validateEntry(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
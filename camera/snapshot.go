@@ -0,0 +1,75 @@
+package camera
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// snapshotCache keeps the latest keyframe of live stream decoded as JPEG image,
+// so it's only decoded once per keyframe no matter how often snapshot requested.
+type snapshotCache struct {
+	mutex       sync.Mutex
+	image       []byte
+	keyframeSeq uint64
+	captured    time.Time
+}
+
+// Snapshot returns JPEG image of the latest keyframe of live stream, along with
+// the time it's captured. Since the sources send keyframe every two seconds, the
+// image is at most that old. Returns nil if the live stream is not available yet.
+func (cam *Camera) Snapshot() ([]byte, time.Time, error) {
+	cam.snapshot.mutex.Lock()
+	defer cam.snapshot.mutex.Unlock()
+
+	keyframe, keyframeSeq, captured := cam.hub.latestKeyframe()
+	if keyframe == nil {
+		return nil, time.Time{}, nil
+	}
+
+	if cam.snapshot.image != nil && cam.snapshot.keyframeSeq == keyframeSeq {
+		return cam.snapshot.image, cam.snapshot.captured, nil
+	}
+
+	image, err := cam.decodeKeyframe(keyframe)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	cam.snapshot.image = image
+	cam.snapshot.keyframeSeq = keyframeSeq
+	cam.snapshot.captured = captured
+	return image, captured, nil
+}
+
+// decodeKeyframe decodes H.264 keyframe into JPEG image.
+func (cam *Camera) decodeKeyframe(keyframe []byte) ([]byte, error) {
+	stderr := newStderrWriter(cam.ID, "snapshot")
+	defer stderr.Close()
+
+	buffer := new(bytes.Buffer)
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "h264",
+		"-i", "pipe:0",
+		"-frames:v", "1",
+		"-codec:v", "mjpeg",
+		"-q:v", "3",
+		"-f", "image2",
+		"pipe:1")
+	cmd.Stdin = bytes.NewReader(keyframe)
+	cmd.Stdout = buffer
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to decode keyframe: %v", err)
+	}
+
+	if buffer.Len() == 0 {
+		return nil, fmt.Errorf("keyframe is not decoded")
+	}
+
+	return buffer.Bytes(), nil
+}
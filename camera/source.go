@@ -0,0 +1,214 @@
+package camera
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Source is producer of raw H.264 stream which consumed by
+// the HLS segmenter and the video saver.
+type Source interface {
+	// Name returns the name of the source, e.g. "raspivid".
+	Name() string
+
+	// Setting returns the setting that will be used by the source.
+	Setting() Setting
+
+	// Capabilities returns the settings that supported by the source.
+	Capabilities() Capabilities
+
+	// Start starts the source and writes its H.264 stream to w.
+	// It blocks until the source stopped or failed.
+	Start(w io.Writer) error
+
+	// Stop stops the source.
+	Stop()
+}
+
+// resettableSource is source that needs to be reset before it's started again,
+// e.g. because it remembers the stop request that comes before it started.
+type resettableSource interface {
+	reset()
+}
+
+// Setting is the setting for camera source.
+type Setting struct {
+	FPS      int
+	Width    int
+	Height   int
+	Rotation int
+	Format   string
+}
+
+// Capabilities is the list of setting value that supported by camera source.
+type Capabilities struct {
+	Resolutions []string      `json:"resolutions"`
+	Rotations   []int         `json:"rotations"`
+	MaxFPS      int           `json:"maxFps"`
+	Formats     []PixelFormat `json:"formats,omitempty"`
+	Parameters  []Parameter   `json:"parameters,omitempty"`
+}
+
+// PixelFormat is pixel format that supported by camera device,
+// along with its supported frame sizes.
+type PixelFormat struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Sizes       []FrameSize `json:"sizes"`
+}
+
+// FrameSize is frame size that supported by a pixel format,
+// along with its supported frame rates.
+type FrameSize struct {
+	Width      int   `json:"width"`
+	Height     int   `json:"height"`
+	FrameRates []int `json:"frameRates"`
+}
+
+// Validate checks whether the specified setting is supported.
+func (c Capabilities) Validate(format, resolution string, fps, rotation int) error {
+	if fps <= 0 || (c.MaxFPS > 0 && fps > c.MaxFPS) {
+		return fmt.Errorf("framerate %d is not supported", fps)
+	}
+
+	if len(c.Rotations) > 0 && !containsInt(c.Rotations, rotation) {
+		return fmt.Errorf("rotation %d is not supported", rotation)
+	}
+
+	if len(c.Resolutions) > 0 && !containsString(c.Resolutions, resolution) {
+		return fmt.Errorf("resolution %s is not supported", resolution)
+	}
+
+	// If source doesn't have any pixel format, we are done
+	if len(c.Formats) == 0 {
+		return nil
+	}
+
+	for _, pixFormat := range c.Formats {
+		if pixFormat.Name != format {
+			continue
+		}
+
+		for _, size := range pixFormat.Sizes {
+			if fmt.Sprintf("%dx%d", size.Width, size.Height) != resolution {
+				continue
+			}
+
+			if len(size.FrameRates) > 0 && !containsInt(size.FrameRates, fps) {
+				return fmt.Errorf("framerate %d is not supported by %s %s", fps, format, resolution)
+			}
+
+			return nil
+		}
+
+		return fmt.Errorf("resolution %s is not supported by %s", resolution, format)
+	}
+
+	return fmt.Errorf("pixel format %s is not supported", format)
+}
+
+// ValidateSetting checks the setting of camera features, e.g. motion detection and
+// audio, before it's saved. Empty value is always valid, since the default is used.
+func ValidateSetting(setting map[string]string) error {
+	validators := []func(map[string]string) error{
+		validateMotionSetting,
+		validateRecordingSetting,
+		validateMJPEGFrameRate,
+		validateAudioSetting,
+		validateLowRendition,
+		validateLowLatency,
+	}
+
+	for _, validate := range validators {
+		if err := validate(setting); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateSwitch checks whether the value is valid for setting that can be turned on or off.
+func validateSwitch(name, value string) error {
+	switch value {
+	case "", "on", "off":
+		return nil
+	default:
+		return fmt.Errorf("%s must be either on or off", name)
+	}
+}
+
+// loadSetting loads camera setting from database.
+// If the saved setting is invalid, the default value will be used.
+func loadSetting(db *bolt.DB, cameraID string) Setting {
+	setting := make(map[string]string)
+	db.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		bucket.ForEach(func(key, val []byte) error {
+			setting[string(key)] = string(val)
+			return nil
+		})
+
+		return nil
+	})
+
+	fps, _ := strconv.Atoi(setting["fps"])
+	rotation, _ := strconv.Atoi(setting["rotation"])
+	resolutionParts := strings.SplitN(setting["resolution"], "x", 2)
+
+	if fps <= 0 {
+		fps = 30
+	}
+
+	switch rotation {
+	case 0, 90, 180, 270:
+	default:
+		rotation = 0
+	}
+
+	width := 800
+	height := 600
+	if len(resolutionParts) == 2 {
+		width, _ = strconv.Atoi(resolutionParts[0])
+		height, _ = strconv.Atoi(resolutionParts[1])
+
+		if width <= 0 || height <= 0 {
+			width = 800
+			height = 600
+		}
+	}
+
+	return Setting{
+		FPS:      fps,
+		Width:    width,
+		Height:   height,
+		Rotation: rotation,
+		Format:   setting["format"],
+	}
+}
+
+func containsInt(list []int, val int) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, val string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
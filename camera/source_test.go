@@ -0,0 +1,38 @@
+package camera
+
+import "testing"
+
+func TestValidateSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting map[string]string
+		wantErr bool
+	}{
+		{"empty", map[string]string{}, false},
+		{"valid", map[string]string{"motion": "on", "motionThreshold": "2.5", "recording": RecordBoth,
+			"preRoll": "5", "postRoll": "60", "mjpegFps": "15", "audioDevice": "hw:1,0",
+			"audioSampleRate": "48000", "audioBitrate": "64", "audioMute": "off",
+			"lowRendition": "on", "lowWidth": "480", "lowBitrate": "300", "lowLatency": "on"}, false},
+		{"invalid motion", map[string]string{"motion": "yes"}, true},
+		{"invalid motion threshold", map[string]string{"motionThreshold": "101"}, true},
+		{"invalid recording mode", map[string]string{"recording": "always"}, true},
+		{"negative pre roll", map[string]string{"preRoll": "-1"}, true},
+		{"too long post roll", map[string]string{"postRoll": "61"}, true},
+		{"invalid MJPEG framerate", map[string]string{"mjpegFps": "0"}, true},
+		{"audio device with whitespace", map[string]string{"audioDevice": "hw:1 -f"}, true},
+		{"invalid audio sample rate", map[string]string{"audioSampleRate": "12345"}, true},
+		{"invalid audio bitrate", map[string]string{"audioBitrate": "8"}, true},
+		{"invalid low rendition width", map[string]string{"lowWidth": "100"}, true},
+		{"invalid low rendition bitrate", map[string]string{"lowBitrate": "abc"}, true},
+		{"invalid low latency", map[string]string{"lowLatency": "true"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSetting(tt.setting)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package camera
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"math"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spriteInterval = 10
+	spriteWidth    = 160
+	spriteColumns  = 10
+
+	// Sprite of the in-progress recording is updated at most once in this interval
+	spriteUpdateInterval = time.Minute
+)
+
+// Sprite is sprite sheet of frames from a video, taken every Interval seconds.
+// It's used for showing preview while seeking the video.
+type Sprite struct {
+	Path       string
+	Interval   int
+	Count      int
+	Columns    int
+	TileWidth  int
+	TileHeight int
+}
+
+// spriteCache keeps the latest sprite of every video, so the sprite of
+// the in-progress recording is not regenerated on every request.
+type spriteCache struct {
+	mutex   sync.Mutex
+	entries map[string]*spriteEntry
+}
+
+// spriteEntry is the sprite of a video. Its mutex is held while the sprite
+// generated, so the same sprite is never generated concurrently.
+type spriteEntry struct {
+	mutex        sync.Mutex
+	sprite       Sprite
+	videoModTime time.Time
+	updated      time.Time
+}
+
+func (sc *spriteCache) entry(videoPath string) *spriteEntry {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if sc.entries == nil {
+		sc.entries = make(map[string]*spriteEntry)
+	}
+
+	entry, exist := sc.entries[videoPath]
+	if !exist {
+		entry = &spriteEntry{}
+		sc.entries[videoPath] = entry
+	}
+
+	return entry
+}
+
+// SpritePath returns path to the sprite sheet of video with specified name.
+func (cam *Camera) SpritePath(videoName string) string {
+	return VideoSpritePath(fp.Join(cam.StorageDir, videoName+".mp4"))
+}
+
+// VideoSpritePath returns path to the sprite sheet of the video file.
+func VideoSpritePath(videoPath string) string {
+	videoName := strings.TrimSuffix(fp.Base(videoPath), fp.Ext(videoPath))
+	return fp.Join(fp.Dir(videoPath), thumbnailDirName, videoName+".sprite.jpg")
+}
+
+// VideoSprite returns the sprite sheet of video with specified name.
+// If the sprite doesn't exist or older than the video, it will be generated.
+// For the in-progress recording, the sprite is updated at most once in
+// spriteUpdateInterval, so it might not cover the end of the video.
+func (cam *Camera) VideoSprite(videoName string) (Sprite, error) {
+	videoPath := fp.Join(cam.StorageDir, videoName+".mp4")
+	spritePath := cam.SpritePath(videoName)
+
+	video, err := os.Stat(videoPath)
+	if err != nil {
+		return Sprite{}, fmt.Errorf("video %s is not exist", videoName)
+	}
+
+	// The concurrent requests for the same video, e.g. the thumbnails
+	// track and the sprite itself, wait for the first one to finish
+	entry := cam.sprites.entry(videoPath)
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.sprite.Path != "" && (!video.ModTime().After(entry.videoModTime) ||
+		time.Since(entry.updated) < spriteUpdateInterval) {
+		if _, err := os.Stat(entry.sprite.Path); err == nil {
+			return entry.sprite, nil
+		}
+	}
+
+	// Calculate the layout of sprite
+	duration, err := VideoDuration(videoPath)
+	if err != nil {
+		return Sprite{}, err
+	}
+
+	count := int(math.Ceil(duration / spriteInterval))
+	if count < 1 {
+		count = 1
+	}
+
+	columns := spriteColumns
+	if count < columns {
+		columns = count
+	}
+
+	rows := int(math.Ceil(float64(count) / float64(columns)))
+
+	// Generate sprite if needed
+	sprite, err := os.Stat(spritePath)
+	if err != nil || video.ModTime().After(sprite.ModTime()) {
+		err = cam.generateSprite(videoPath, spritePath, columns, rows)
+		if err != nil {
+			return Sprite{}, err
+		}
+	}
+
+	// Get size of each tile
+	f, err := os.Open(spritePath)
+	if err != nil {
+		return Sprite{}, err
+	}
+	defer f.Close()
+
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return Sprite{}, err
+	}
+
+	entry.sprite = Sprite{
+		Path:       spritePath,
+		Interval:   spriteInterval,
+		Count:      count,
+		Columns:    columns,
+		TileWidth:  config.Width / columns,
+		TileHeight: config.Height / rows,
+	}
+	entry.videoModTime = video.ModTime()
+	entry.updated = time.Now()
+
+	return entry.sprite, nil
+}
+
+// generateSprite creates sprite sheet from the video. To make it fast,
+// only the keyframes are decoded, so the frame might be slightly off.
+func (cam *Camera) generateSprite(videoPath, spritePath string, columns, rows int) error {
+	err := os.MkdirAll(fp.Dir(spritePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	filter := fmt.Sprintf("fps=1/%d,scale=%d:-2,tile=%dx%d",
+		spriteInterval, spriteWidth, columns, rows)
+
+	tmpPath := spritePath + ".tmp"
+	cmd := exec.Command("ffmpeg", "-y",
+		"-loglevel", "error",
+		"-skip_frame", "nokey",
+		"-i", videoPath,
+		"-an",
+		"-vf", filter,
+		"-frames:v", "1",
+		"-codec:v", "mjpeg",
+		"-f", "image2",
+		tmpPath)
+
+	stderr := newStderrWriter(cam.ID, "sprite")
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	if err = cmd.Run(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to generate sprite for %s: %v", fp.Base(videoPath), err)
+	}
+
+	return os.Rename(tmpPath, spritePath)
+}
+
+// VideoDuration returns duration of the video file in seconds.
+func VideoDuration(videoPath string) (float64, error) {
+	cmd := exec.Command("ffprobe",
+		"-loglevel", "fatal",
+		"-print_format", "compact",
+		"-show_entries", "format=duration",
+		videoPath)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, err
+	}
+
+	output = bytes.TrimSpace(output)
+	outputParts := strings.SplitN(string(output), "=", 2)
+	if len(outputParts) != 2 || outputParts[0] != "format|duration" {
+		return 0, fmt.Errorf("unable to parse video duration")
+	}
+
+	return strconv.ParseFloat(outputParts[1], 64)
+}
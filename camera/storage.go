@@ -0,0 +1,178 @@
+package camera
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// storageRecorder saves H.264 stream into storage while the camera is armed.
+// The encoder is started and stopped on keyframe, so arming and disarming
+// doesn't require restarting the camera source.
+type storageRecorder struct {
+	mutex     sync.Mutex
+	cameraID  string
+	armed     func() bool
+	newCmd    func() *exec.Cmd
+	withAudio bool
+
+	scanner nalScanner
+	tracker h264Tracker
+	adts    adtsScanner
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr io.Closer
+	audio  *os.File
+}
+
+func newStorageRecorder(cameraID string, armed func() bool, newCmd func() *exec.Cmd, withAudio bool) *storageRecorder {
+	return &storageRecorder{
+		cameraID:  cameraID,
+		armed:     armed,
+		newCmd:    newCmd,
+		withAudio: withAudio,
+	}
+}
+
+// Write receives the H.264 stream from camera source. It never returns error,
+// since failure in saving stream must not stop the live view.
+func (sr *storageRecorder) Write(p []byte) (int, error) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.scanner.push(p, sr.handleNAL)
+	return len(p), nil
+}
+
+func (sr *storageRecorder) handleNAL(nal []byte) {
+	keyframe, ok := sr.tracker.track(nal)
+	if !ok {
+		return
+	}
+
+	if keyframe {
+		armed := sr.armed()
+		switch {
+		case armed && sr.cmd == nil:
+			sr.start()
+		case !armed && sr.cmd != nil:
+			sr.finish()
+		}
+	}
+
+	sr.write(nal)
+}
+
+// audioWriter returns writer for receiving the shared audio. Audio is only
+// written while recording, and always in whole frames, so the encoder can
+// join it from the middle.
+func (sr *storageRecorder) audioWriter() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		sr.mutex.Lock()
+		defer sr.mutex.Unlock()
+
+		sr.adts.push(p, func(frame []byte) {
+			if sr.audio != nil {
+				sr.audio.Write(frame)
+			}
+		})
+
+		return len(p), nil
+	})
+}
+
+// Close stops the encoder, if any.
+func (sr *storageRecorder) Close() error {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.finish()
+	return nil
+}
+
+func (sr *storageRecorder) start() {
+	logger := logrus.WithField("camera", sr.cameraID)
+	cmd := sr.newCmd()
+	stderr := newStderrWriter(sr.cameraID, "storage")
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger.Warnln("failed to start recording:", err)
+		return
+	}
+
+	var audio *os.File
+	if sr.withAudio {
+		if audio, err = attachAudio(cmd); err != nil {
+			logger.Warnln("failed to start recording:", err)
+			stdin.Close()
+			return
+		}
+	}
+
+	err = cmd.Start()
+	releaseAudio(cmd)
+	if err != nil {
+		logger.Warnln("failed to start recording:", err)
+		stdin.Close()
+		stderr.Close()
+		if audio != nil {
+			audio.Close()
+		}
+		return
+	}
+
+	logger.Infoln("recording started")
+	sr.cmd = cmd
+	sr.stdin = stdin
+	sr.stderr = stderr
+	sr.audio = audio
+
+	// The stream is started from the middle, so the encoder needs the parameter sets
+	for _, parameterSet := range sr.tracker.parameterSets() {
+		sr.write(parameterSet)
+	}
+}
+
+func (sr *storageRecorder) write(data []byte) {
+	if sr.cmd == nil {
+		return
+	}
+
+	if _, err := sr.stdin.Write(data); err != nil {
+		logrus.WithField("camera", sr.cameraID).Warnln("failed to save stream:", err)
+		sr.finish()
+	}
+}
+
+func (sr *storageRecorder) finish() {
+	if sr.cmd == nil {
+		return
+	}
+
+	cmd, stderr := sr.cmd, sr.stderr
+	sr.stdin.Close()
+	if sr.audio != nil {
+		sr.audio.Close()
+	}
+
+	sr.cmd = nil
+	sr.stdin = nil
+	sr.stderr = nil
+	sr.audio = nil
+
+	// Let the encoder finish the file in background
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			logrus.WithField("camera", sr.cameraID).Warnln("failed to finish recording:", err)
+		} else {
+			logrus.WithField("camera", sr.cameraID).Infoln("recording stopped")
+		}
+		stderr.Close()
+	}()
+}
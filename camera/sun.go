@@ -0,0 +1,50 @@
+package camera
+
+import (
+	"math"
+	"time"
+)
+
+// Location is geographic coordinate of the camera, used to calculate sunrise and sunset.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// sunTimes returns time of sunrise and sunset at the location in the specified day,
+// using the sunrise equation. It returns false when the sun never rises or sets that
+// day, e.g. in polar region.
+func sunTimes(day time.Time, loc Location) (time.Time, time.Time, bool) {
+	const rad = math.Pi / 180
+	sin := func(deg float64) float64 { return math.Sin(deg * rad) }
+	cos := func(deg float64) float64 { return math.Cos(deg * rad) }
+
+	// Number of days since J2000 epoch
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, day.Location())
+	julianDay := float64(noon.Unix())/86400 + 2440587.5
+	n := math.Round(julianDay - 2451545.0 + 0.0008)
+
+	// Solar transit, i.e. when the sun is at its highest
+	meanNoon := n - loc.Longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*sin(anomaly) + 0.02*sin(2*anomaly) + 0.0003*sin(3*anomaly)
+	longitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := 2451545.0 + meanNoon + 0.0053*sin(anomaly) - 0.0069*sin(2*longitude)
+
+	// Hour angle when the sun is just below horizon
+	sinDeclination := sin(longitude) * sin(23.44)
+	cosDeclination := math.Cos(math.Asin(sinDeclination))
+	cosHourAngle := (sin(-0.833) - sin(loc.Latitude)*sinDeclination) /
+		(cos(loc.Latitude) * cosDeclination)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	hourAngle := math.Acos(cosHourAngle) / rad
+	julianToTime := func(julian float64) time.Time {
+		seconds := (julian - 2440587.5) * 86400
+		return time.Unix(0, int64(seconds*float64(time.Second))).In(day.Location())
+	}
+
+	return julianToTime(transit - hourAngle/360), julianToTime(transit + hourAngle/360), true
+}
@@ -0,0 +1,103 @@
+package camera
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunTimes(t *testing.T) {
+	// Published sunrise and sunset, rounded to minute. The sunrise equation
+	// ignores the terrain and the atmospheric condition, so allow slight error.
+	const tolerance = 2 * time.Minute
+	london := Location{Latitude: 51.5074, Longitude: -0.1278}
+	tromso := Location{Latitude: 69.6492, Longitude: 18.9553}
+
+	tests := []struct {
+		name        string
+		loc         Location
+		day         time.Time
+		wantSunrise time.Time
+		wantSunset  time.Time
+		wantOK      bool
+	}{{
+		name:        "London summer solstice",
+		loc:         london,
+		day:         testDate(2020, 6, 21, 1),
+		wantSunrise: testTime(2020, 6, 21, 4, 43, 1),
+		wantSunset:  testTime(2020, 6, 21, 21, 21, 1),
+		wantOK:      true,
+	}, {
+		name:        "London winter solstice",
+		loc:         london,
+		day:         testDate(2020, 12, 21, 0),
+		wantSunrise: testTime(2020, 12, 21, 8, 4, 0),
+		wantSunset:  testTime(2020, 12, 21, 15, 53, 0),
+		wantOK:      true,
+	}, {
+		name:        "New York, west of prime meridian",
+		loc:         Location{Latitude: 40.7128, Longitude: -74.0060},
+		day:         testDate(2020, 6, 20, -4),
+		wantSunrise: testTime(2020, 6, 20, 5, 25, -4),
+		wantSunset:  testTime(2020, 6, 20, 20, 30, -4),
+		wantOK:      true,
+	}, {
+		name:        "Sydney, southern hemisphere",
+		loc:         Location{Latitude: -33.8688, Longitude: 151.2093},
+		day:         testDate(2020, 6, 21, 10),
+		wantSunrise: testTime(2020, 6, 21, 7, 0, 10),
+		wantSunset:  testTime(2020, 6, 21, 16, 54, 10),
+		wantOK:      true,
+	}, {
+		name:        "Reykjavik, sunset after midnight",
+		loc:         Location{Latitude: 64.1466, Longitude: -21.9426},
+		day:         testDate(2020, 6, 21, 0),
+		wantSunrise: testTime(2020, 6, 21, 2, 55, 0),
+		wantSunset:  testTime(2020, 6, 22, 0, 4, 0),
+		wantOK:      true,
+	}, {
+		name:   "Tromso, polar day",
+		loc:    tromso,
+		day:    testDate(2020, 6, 21, 2),
+		wantOK: false,
+	}, {
+		name:   "Tromso, polar night",
+		loc:    tromso,
+		day:    testDate(2020, 12, 21, 1),
+		wantOK: false,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sunrise, sunset, ok := sunTimes(tt.day, tt.loc)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+
+			if !ok {
+				return
+			}
+
+			if diff := sunrise.Sub(tt.wantSunrise); diff < -tolerance || diff > tolerance {
+				t.Errorf("got sunrise %s, want %s", sunrise, tt.wantSunrise)
+			}
+
+			if diff := sunset.Sub(tt.wantSunset); diff < -tolerance || diff > tolerance {
+				t.Errorf("got sunset %s, want %s", sunset, tt.wantSunset)
+			}
+
+			if sunrise.Location() != tt.day.Location() {
+				t.Errorf("got location %s, want %s", sunrise.Location(), tt.day.Location())
+			}
+		})
+	}
+}
+
+// testTime returns time in fixed zone with the specified UTC offset in hours.
+func testTime(year int, month time.Month, day, hour, minute, utcOffset int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.FixedZone("", utcOffset*3600))
+}
+
+// testDate returns midnight of the date in fixed zone with the specified UTC offset in hours.
+func testDate(year int, month time.Month, day, utcOffset int) time.Time {
+	return testTime(year, month, day, 0, 0, utcOffset)
+}
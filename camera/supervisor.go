@@ -0,0 +1,146 @@
+package camera
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+	stableDuration  = 5 * time.Minute
+
+	// Max duration for source to exit after it's stopped, before it's reported
+	sourceStopTimeout = 10 * time.Second
+)
+
+// Status is the running status of camera pipeline.
+type Status struct {
+	Running       bool      `json:"running"`
+	StartTime     time.Time `json:"startTime"`
+	Restarts      int       `json:"restarts"`
+	LastError     string    `json:"lastError"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+}
+
+// Start runs the camera pipeline and supervises it. If any of its child
+// processes exited, the whole pipeline will be teared down and restarted
+// with exponential backoff. It blocks until Stop is called.
+func (cam *Camera) Start() error {
+	if cam.SaveToStorage {
+		cam.initArmed()
+		go cam.runArming()
+		go cam.runThumbnailer()
+	}
+
+	cam.ReloadRestreams()
+	defer cam.stopRestreams()
+
+	delay := minRestartDelay
+	for {
+		cam.mutex.Lock()
+		cam.status.Running = true
+		cam.status.StartTime = time.Now()
+		cam.mutex.Unlock()
+
+		err := cam.runPipeline()
+
+		cam.mutex.Lock()
+		cam.status.Running = false
+		runDuration := time.Since(cam.status.StartTime)
+		cam.mutex.Unlock()
+
+		if err != nil {
+			cam.recordError(err)
+		}
+
+		// If stop requested, we are done
+		select {
+		case <-cam.stopChannel():
+			return nil
+		default:
+		}
+
+		// If the pipeline ran long enough, it's considered stable
+		// so reset the restart delay
+		if runDuration > stableDuration {
+			delay = minRestartDelay
+		}
+
+		logrus.WithField("camera", cam.ID).Warnf("camera failed (%v), restarting in %s", err, delay)
+		select {
+		case <-cam.stopChannel():
+			return nil
+		case <-time.After(delay):
+		}
+
+		cam.mutex.Lock()
+		cam.status.Restarts++
+		cam.mutex.Unlock()
+
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// waitSource waits until the stopped source exited, so the pipeline is never
+// restarted while the old source still running. If it takes too long, it's
+// recorded as error, but it's still waited since it might use the device.
+func (cam *Camera) waitSource(chSourceExit chan struct{}) error {
+	select {
+	case <-chSourceExit:
+		return nil
+	case <-time.After(sourceStopTimeout):
+	}
+
+	err := fmt.Errorf("%s doesn't exit after %s since stopped", cam.Source.Name(), sourceStopTimeout)
+	logrus.WithField("camera", cam.ID).Errorln(err)
+	cam.recordError(err)
+	<-chSourceExit
+	return err
+}
+
+// recordError saves the error as the last error in camera status.
+func (cam *Camera) recordError(err error) {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+
+	cam.status.LastError = err.Error()
+	cam.status.LastErrorTime = time.Now()
+}
+
+// Stop stops the camera pipeline and its supervisor.
+func (cam *Camera) Stop() {
+	chStop := cam.stopChannel()
+
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+
+	select {
+	case <-chStop:
+	default:
+		close(chStop)
+	}
+}
+
+// Status returns the current running status of the camera.
+func (cam *Camera) Status() Status {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+	return cam.status
+}
+
+func (cam *Camera) stopChannel() chan struct{} {
+	cam.mutex.Lock()
+	defer cam.mutex.Unlock()
+
+	if cam.chStop == nil {
+		cam.chStop = make(chan struct{})
+	}
+
+	return cam.chStop
+}
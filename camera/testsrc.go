@@ -0,0 +1,194 @@
+package camera
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// TestPattern is source that generates test pattern with clock overlay.
+// It's useful for exercising the pipeline on machine without camera.
+type TestPattern struct {
+	DB       *bolt.DB
+	CameraID string
+
+	processSource
+}
+
+// Name returns the name of the source.
+func (cam *TestPattern) Name() string {
+	return "testsrc"
+}
+
+// Setting returns the setting that will be used by the test pattern.
+func (cam *TestPattern) Setting() Setting {
+	return loadSetting(cam.DB, cam.CameraID)
+}
+
+// Capabilities returns the settings that supported by the test pattern.
+func (cam *TestPattern) Capabilities() Capabilities {
+	return Capabilities{
+		Resolutions: []string{
+			"320x240", "640x480", "800x600", "1024x768",
+			"1280x720", "1920x1080"},
+		Rotations: []int{0, 90, 180, 270},
+		MaxFPS:    60,
+	}
+}
+
+// Start runs ffmpeg to generate the test pattern and writes it to w.
+func (cam *TestPattern) Start(w io.Writer) error {
+	return cam.run(cam.CameraID, cam.Name(), cam.genCmdTestPattern(cam.Setting()), w)
+}
+
+// Stop kills the test pattern generator.
+func (cam *TestPattern) Stop() {
+	cam.kill()
+}
+
+func (cam *TestPattern) genCmdTestPattern(setting Setting) *exec.Cmd {
+	filter := `drawtext=text='%{localtime\:%Y-%m-%d %X}':` +
+		`x=8:y=8:fontsize=24:fontcolor=white:box=1:boxcolor=black@0.5`
+	if rotation := rotationFilter(setting.Rotation); rotation != "" {
+		filter = rotation + "," + filter
+	}
+
+	return exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-re",
+		"-f", "lavfi",
+		"-i", fmt.Sprintf("testsrc=size=%dx%d:rate=%d", setting.Width, setting.Height, setting.FPS),
+		"-vf", filter,
+		"-codec:v", "libx264",
+		"-preset", "ultrafast",
+		"-tune", "zerolatency",
+		"-pix_fmt", "yuv420p",
+		"-g", strconv.Itoa(setting.FPS*2),
+		"-f", "h264",
+		"pipe:1")
+}
+
+// FileReplay is source that loops a video file as if it's a camera.
+// The path of the video is saved in the camera bucket, in key "file".
+// The video must be encoded in H.264.
+type FileReplay struct {
+	DB       *bolt.DB
+	CameraID string
+
+	processSource
+}
+
+// Name returns the name of the source.
+func (cam *FileReplay) Name() string {
+	return "file"
+}
+
+// Setting returns the setting of the video file.
+// The frame size and framerate are taken from the video itself.
+func (cam *FileReplay) Setting() Setting {
+	setting := loadSetting(cam.DB, cam.CameraID)
+
+	filePath := cam.filePath()
+	if filePath == "" {
+		return setting
+	}
+
+	// Probe the video
+	cmd := exec.Command("ffprobe",
+		"-loglevel", "fatal",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height,r_frame_rate",
+		"-of", "default=noprint_wrappers=1",
+		filePath)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return setting
+	}
+
+	// Parse the probe result
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "width":
+			setting.Width, _ = strconv.Atoi(parts[1])
+		case "height":
+			setting.Height, _ = strconv.Atoi(parts[1])
+		case "r_frame_rate":
+			rateParts := strings.SplitN(parts[1], "/", 2)
+			num, _ := strconv.Atoi(rateParts[0])
+			den := 1
+			if len(rateParts) == 2 {
+				den, _ = strconv.Atoi(rateParts[1])
+			}
+
+			if num > 0 && den > 0 {
+				setting.FPS = (num + den/2) / den
+			}
+		}
+	}
+
+	return setting
+}
+
+// Capabilities returns the settings that supported by the file replay.
+// Everything is decided by the video file, so there are nothing to choose here.
+func (cam *FileReplay) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// Start runs ffmpeg to replay the video file and writes it to w.
+func (cam *FileReplay) Start(w io.Writer) error {
+	filePath := cam.filePath()
+	if filePath == "" {
+		return fmt.Errorf("replayed video file is not specified")
+	}
+
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("replayed video file is not accessible: %v", err)
+	}
+
+	return cam.run(cam.CameraID, cam.Name(), cam.genCmdFileReplay(filePath), w)
+}
+
+// Stop kills the file replay.
+func (cam *FileReplay) Stop() {
+	cam.kill()
+}
+
+func (cam *FileReplay) filePath() string {
+	var filePath string
+	cam.DB.View(func(tx *bolt.Tx) error {
+		bucket := Bucket(tx, cam.CameraID)
+		if bucket != nil {
+			filePath = string(bucket.Get([]byte("file")))
+		}
+		return nil
+	})
+
+	return filePath
+}
+
+func (cam *FileReplay) genCmdFileReplay(filePath string) *exec.Cmd {
+	return exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-re",
+		"-stream_loop", "-1",
+		"-i", filePath,
+		"-an",
+		"-codec:v", "copy",
+		"-f", "h264",
+		"pipe:1")
+}
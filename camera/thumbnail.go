@@ -0,0 +1,134 @@
+package camera
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	thumbnailDirName = ".thumbnails"
+	thumbnailWidth   = 320
+
+	// Thumbnail of the in-progress recording is updated at most once in this interval
+	thumbnailUpdateInterval = time.Minute
+)
+
+// ThumbnailPath returns path to the thumbnail of video with specified name.
+func (cam *Camera) ThumbnailPath(videoName string) string {
+	return VideoThumbnailPath(fp.Join(cam.StorageDir, videoName+".mp4"))
+}
+
+// VideoThumbnailPath returns path to the thumbnail of the video file.
+func VideoThumbnailPath(videoPath string) string {
+	videoName := strings.TrimSuffix(fp.Base(videoPath), fp.Ext(videoPath))
+	return fp.Join(fp.Dir(videoPath), thumbnailDirName, videoName+".jpg")
+}
+
+// GenerateThumbnail creates thumbnail of the video with specified name,
+// which taken from the first frame of the video.
+func (cam *Camera) GenerateThumbnail(videoName string) error {
+	return cam.generateThumbnail(videoName, 0)
+}
+
+// generateLatestThumbnail creates thumbnail of the video with specified name,
+// which taken from near the end of the video. It's used for the in-progress
+// recording, so its thumbnail follows what currently recorded.
+func (cam *Camera) generateLatestThumbnail(videoName string) error {
+	duration, err := VideoDuration(fp.Join(cam.StorageDir, videoName+".mp4"))
+	if err != nil {
+		return fmt.Errorf("failed to generate thumbnail for %s: %v", videoName, err)
+	}
+
+	return cam.generateThumbnail(videoName, math.Max(duration-1, 0))
+}
+
+// generateThumbnail creates thumbnail from the frame at position (in seconds) of video.
+func (cam *Camera) generateThumbnail(videoName string, position float64) error {
+	videoPath := fp.Join(cam.StorageDir, videoName+".mp4")
+	thumbnailPath := cam.ThumbnailPath(videoName)
+
+	err := os.MkdirAll(fp.Dir(thumbnailPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// Write to temporary file first, so the incomplete
+	// thumbnail will never be served
+	tmpPath := thumbnailPath + ".tmp"
+	cmd := exec.Command("ffmpeg", "-y",
+		"-loglevel", "error",
+		"-ss", strconv.FormatFloat(position, 'f', 3, 64),
+		"-i", videoPath,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth),
+		"-codec:v", "mjpeg",
+		"-f", "image2",
+		tmpPath)
+
+	stderr := newStderrWriter(cam.ID, "thumbnail")
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	if err = cmd.Run(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to generate thumbnail for %s: %v", videoName, err)
+	}
+
+	return os.Rename(tmpPath, thumbnailPath)
+}
+
+// runThumbnailer periodically generates thumbnails for the recorded videos
+// until the camera stopped. The thumbnail is taken from the first frame, except
+// for video that still being recorded, whose thumbnail is periodically updated
+// using its latest frame as long as the video keeps changing.
+func (cam *Camera) runThumbnailer() {
+	for {
+		cam.updateThumbnails()
+
+		select {
+		case <-cam.stopChannel():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+func (cam *Camera) updateThumbnails() {
+	dirItems, err := ioutil.ReadDir(cam.StorageDir)
+	if err != nil {
+		logrus.WithField("camera", cam.ID).Warnln("failed to read storage dir:", err)
+		return
+	}
+
+	for _, item := range dirItems {
+		if item.IsDir() || item.Size() == 0 || fp.Ext(item.Name()) != ".mp4" {
+			continue
+		}
+
+		// Skip if thumbnail is up to date, or recently updated
+		videoName := strings.TrimSuffix(item.Name(), ".mp4")
+		thumbnail, err := os.Stat(cam.ThumbnailPath(videoName))
+		switch {
+		case err != nil:
+			err = cam.GenerateThumbnail(videoName)
+		case !item.ModTime().After(thumbnail.ModTime()),
+			time.Since(thumbnail.ModTime()) < thumbnailUpdateInterval:
+			continue
+		default:
+			err = cam.generateLatestThumbnail(videoName)
+		}
+
+		if err != nil {
+			logrus.WithField("camera", cam.ID).Warnln(err)
+		}
+	}
+}
@@ -0,0 +1,541 @@
+package camera
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Status of timelapse job.
+const (
+	TimelapseQueued  = "queued"
+	TimelapseRunning = "running"
+	TimelapseDone    = "done"
+	TimelapseFailed  = "failed"
+)
+
+const (
+	timelapseDirName  = ".timelapse"
+	timelapseQueueLen = 20
+
+	// Continuous recording is split every 15 minutes
+	recordingDuration = 15 * time.Minute
+)
+
+var rxRecording = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}-\d{2}:\d{2}:\d{2})(-motion)?\.mp4$`)
+
+// TimelapseJob is request for building timelapse video from the recordings
+// of a camera, taking one frame every Interval seconds and rendered in FPS.
+type TimelapseJob struct {
+	ID       string    `json:"id"`
+	CameraID string    `json:"camera"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Interval int       `json:"interval"`
+	FPS      int       `json:"fps"`
+	Status   string    `json:"status"`
+	Progress float64   `json:"progress"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+
+	storageDir string
+	outputPath string
+}
+
+// storedTimelapseJob is timelapse job as saved in database. Unlike
+// the one returned by API, it also contains the path of its video.
+type storedTimelapseJob struct {
+	TimelapseJob
+	StorageDir string `json:"storageDir"`
+	OutputPath string `json:"outputPath"`
+}
+
+// Timelapser is the background worker that builds the timelapse
+// videos one by one, in order they're requested. The jobs are saved
+// in database, so the finished videos are kept after restart.
+type Timelapser struct {
+	db      *bolt.DB
+	mutex   sync.RWMutex
+	jobs    []*TimelapseJob
+	chQueue chan *TimelapseJob
+}
+
+// NewTimelapser returns new Timelapser with the jobs that saved in database.
+// The jobs that interrupted by restart are marked as failed, since their
+// video is incomplete. Call Run to start processing the new jobs.
+func NewTimelapser(db *bolt.DB) *Timelapser {
+	t := &Timelapser{
+		db:      db,
+		chQueue: make(chan *TimelapseJob, timelapseQueueLen),
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("timelapses"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, val []byte) error {
+			var stored storedTimelapseJob
+			if err := json.Unmarshal(val, &stored); err != nil {
+				return nil
+			}
+
+			job := stored.TimelapseJob
+			job.storageDir = stored.StorageDir
+			job.outputPath = stored.OutputPath
+			t.jobs = append(t.jobs, &job)
+			return nil
+		})
+	})
+
+	sort.Slice(t.jobs, func(i, j int) bool {
+		return t.jobs[i].Created.Before(t.jobs[j].Created)
+	})
+
+	for _, job := range t.jobs {
+		if job.Status == TimelapseQueued || job.Status == TimelapseRunning {
+			os.Remove(job.outputPath)
+			os.Remove(job.outputPath + ".txt")
+			t.setStatus(job, TimelapseFailed, fmt.Errorf("interrupted by restart"))
+		}
+	}
+
+	return t
+}
+
+// Run processes the queued jobs. It never returns, so it should be run in goroutine.
+func (t *Timelapser) Run() {
+	for job := range t.chQueue {
+		t.setStatus(job, TimelapseRunning, nil)
+		logrus.WithField("camera", job.CameraID).Infoln("building timelapse", job.ID)
+
+		err := t.build(job)
+		if err != nil {
+			os.Remove(job.outputPath)
+			logrus.WithField("camera", job.CameraID).Warnln("timelapse failed:", err)
+			t.setStatus(job, TimelapseFailed, err)
+			continue
+		}
+
+		t.setStatus(job, TimelapseDone, nil)
+		logrus.WithField("camera", job.CameraID).Infoln("timelapse finished", job.ID)
+	}
+}
+
+// Add queues new timelapse job for the recordings of the camera.
+func (t *Timelapser) Add(cam *Camera, from, to time.Time, interval, fps int) (TimelapseJob, error) {
+	if !to.After(from) {
+		return TimelapseJob{}, fmt.Errorf("end time must be after start time")
+	}
+
+	if interval <= 0 || fps <= 0 {
+		return TimelapseJob{}, fmt.Errorf("interval and framerate must be positive")
+	}
+
+	now := time.Now()
+	id := strconv.FormatInt(now.UnixNano(), 36)
+	job := &TimelapseJob{
+		ID:         id,
+		CameraID:   cam.ID,
+		From:       from,
+		To:         to,
+		Interval:   interval,
+		FPS:        fps,
+		Status:     TimelapseQueued,
+		Created:    now,
+		storageDir: cam.StorageDir,
+		outputPath: fp.Join(cam.StorageDir, timelapseDirName, id+".mp4"),
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := t.save(job); err != nil {
+		return TimelapseJob{}, err
+	}
+
+	select {
+	case t.chQueue <- job:
+	default:
+		t.delete(job)
+		return TimelapseJob{}, fmt.Errorf("too many timelapse in queue")
+	}
+
+	t.jobs = append(t.jobs, job)
+	return *job, nil
+}
+
+// Jobs returns all timelapse jobs.
+func (t *Timelapser) Jobs() []TimelapseJob {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	jobs := []TimelapseJob{}
+	for _, job := range t.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	return jobs
+}
+
+// Job returns the timelapse job with specified ID, along with path to its video.
+func (t *Timelapser) Job(id string) (TimelapseJob, string, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, job := range t.jobs {
+		if job.ID == id {
+			return *job, job.outputPath, nil
+		}
+	}
+
+	return TimelapseJob{}, "", fmt.Errorf("timelapse %s is not exist", id)
+}
+
+// Oldest returns the oldest finished timelapse job, i.e. the first
+// one to be removed when the storage is running low.
+func (t *Timelapser) Oldest() (TimelapseJob, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, job := range t.jobs {
+		if job.Status == TimelapseDone {
+			return *job, true
+		}
+	}
+
+	return TimelapseJob{}, false
+}
+
+// Remove removes the timelapse job and its video. Running job can't be removed.
+func (t *Timelapser) Remove(id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for i, job := range t.jobs {
+		if job.ID != id {
+			continue
+		}
+
+		if job.Status == TimelapseRunning || job.Status == TimelapseQueued {
+			return fmt.Errorf("timelapse %s is still in progress", id)
+		}
+
+		if err := t.delete(job); err != nil {
+			return err
+		}
+
+		os.Remove(job.outputPath)
+		t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+		return nil
+	}
+
+	return fmt.Errorf("timelapse %s is not exist", id)
+}
+
+func (t *Timelapser) setStatus(job *TimelapseJob, status string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	job.Status = status
+	if status == TimelapseDone {
+		job.Progress = 100
+	}
+
+	if err != nil {
+		job.Error = err.Error()
+	}
+
+	if err := t.save(job); err != nil {
+		logrus.WithField("camera", job.CameraID).Warnln("failed to save timelapse:", err)
+	}
+}
+
+func (t *Timelapser) setProgress(job *TimelapseJob, progress float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if progress > 100 {
+		progress = 100
+	}
+	job.Progress = progress
+}
+
+// save saves the job to database. The caller must hold the mutex.
+func (t *Timelapser) save(job *TimelapseJob) error {
+	value, err := json.Marshal(&storedTimelapseJob{
+		TimelapseJob: *job,
+		StorageDir:   job.storageDir,
+		OutputPath:   job.outputPath,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("timelapses"))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(job.ID), value)
+	})
+}
+
+// delete removes the job from database. The caller must hold the mutex.
+func (t *Timelapser) delete(job *TimelapseJob) error {
+	return t.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("timelapses"))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(job.ID))
+	})
+}
+
+func (t *Timelapser) build(job *TimelapseJob) error {
+	// Create list of recordings for ffmpeg concat demuxer
+	recordings, err := recordingsInRange(job.storageDir, job.From, job.To)
+	if err != nil {
+		return err
+	}
+
+	if len(recordings) == 0 {
+		return fmt.Errorf("no recordings between %s and %s",
+			job.From.Format("2006-01-02 15:04:05"), job.To.Format("2006-01-02 15:04:05"))
+	}
+
+	err = os.MkdirAll(fp.Dir(job.outputPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	listPath := job.outputPath + ".txt"
+	defer os.Remove(listPath)
+
+	list := new(strings.Builder)
+	totalDuration := time.Duration(0)
+	for _, rec := range recordings {
+		fmt.Fprintf(list, "file '%s'\n", strings.Replace(rec.path, "'", `'\''`, -1))
+
+		if rec.inPoint > 0 {
+			fmt.Fprintf(list, "inpoint %f\n", rec.inPoint.Seconds())
+		}
+
+		if rec.outPoint < rec.duration {
+			fmt.Fprintf(list, "outpoint %f\n", rec.outPoint.Seconds())
+		}
+
+		totalDuration += rec.outPoint - rec.inPoint
+	}
+
+	err = ioutil.WriteFile(listPath, []byte(list.String()), 0644)
+	if err != nil {
+		return err
+	}
+
+	// Take one frame every interval, then play them in the specified framerate
+	filter := fmt.Sprintf("fps=1/%d,setpts=N/(%d*TB)", job.Interval, job.FPS)
+	cmd := exec.Command("ffmpeg", "-y",
+		"-loglevel", "error",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listPath,
+		"-an",
+		"-vf", filter,
+		"-r", strconv.Itoa(job.FPS),
+		"-codec:v", "libx264",
+		"-preset", "veryfast",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+		"-progress", "pipe:1",
+		"-nostats",
+		job.outputPath)
+
+	stderr := &tailBuffer{}
+	stderrLogger := newStderrWriter(job.CameraID, "timelapse")
+	defer stderrLogger.Close()
+	cmd.Stderr = io.MultiWriter(stderr, stderrLogger)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Report progress from the number of encoded frames
+	expectedFrames := totalDuration.Seconds() / float64(job.Interval)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "frame=") || expectedFrames <= 0 {
+			continue
+		}
+
+		frame, err := strconv.Atoi(strings.TrimPrefix(line, "frame="))
+		if err == nil {
+			t.setProgress(job, float64(frame)*100/expectedFrames)
+		}
+	}
+
+	err = cmd.Wait()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%v: %s", err, stderr)
+	}
+
+	return err
+}
+
+// recording is a recorded video in storage. Only the part
+// between inPoint and outPoint is used for timelapse.
+type recording struct {
+	path     string
+	start    time.Time
+	duration time.Duration
+	inPoint  time.Duration
+	outPoint time.Duration
+}
+
+// timeRange is a period of time, started at start and ended before end.
+type timeRange struct {
+	start time.Time
+	end   time.Time
+}
+
+// recordingsInRange returns the recordings which overlap with the specified time range,
+// sorted by time. The continuous recordings are preferred, and the motion clips are only
+// used for the periods which have no continuous recording, e.g. when continuous recording
+// is disabled by schedule.
+func recordingsInRange(storageDir string, from, to time.Time) ([]recording, error) {
+	dirItems, err := ioutil.ReadDir(storageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var continuous, clips []recording
+	for _, item := range dirItems {
+		parts := rxRecording.FindStringSubmatch(item.Name())
+		if parts == nil {
+			continue
+		}
+
+		start, err := time.ParseInLocation("2006-01-02-15:04:05", parts[1], time.Local)
+		if err != nil || !start.Before(to) {
+			continue
+		}
+
+		rec := recording{
+			path:  fp.Join(storageDir, item.Name()),
+			start: start,
+		}
+
+		// Continuous recording lasts at most 15 minutes, so the older
+		// ones can be skipped. Motion clip has no such limit.
+		if parts[2] == "" {
+			if !start.Before(from.Add(-recordingDuration - time.Minute)) {
+				continuous = append(continuous, rec)
+			}
+		} else {
+			clips = append(clips, rec)
+		}
+	}
+
+	var result []recording
+	gaps := []timeRange{{start: from, end: to}}
+	for _, recordings := range [][]recording{continuous, clips} {
+		sort.Slice(recordings, func(i, j int) bool {
+			return recordings[i].start.Before(recordings[j].start)
+		})
+
+		for _, rec := range recordings {
+			if len(gaps) == 0 {
+				break
+			}
+
+			// Only probe duration of the recordings that might be used
+			if !rec.start.Before(gaps[len(gaps)-1].end) {
+				continue
+			}
+
+			duration, err := VideoDuration(rec.path)
+			if err != nil || duration <= 0 {
+				continue
+			}
+
+			rec.duration = time.Duration(duration * float64(time.Second))
+			recRange := timeRange{start: rec.start, end: rec.start.Add(rec.duration)}
+			for _, gap := range gaps {
+				part, overlap := gap.intersect(recRange)
+				if !overlap {
+					continue
+				}
+
+				rec.inPoint = part.start.Sub(rec.start)
+				rec.outPoint = part.end.Sub(rec.start)
+				result = append(result, rec)
+			}
+
+			gaps = subtractTimeRange(gaps, recRange)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].start.Add(result[i].inPoint).Before(result[j].start.Add(result[j].inPoint))
+	})
+
+	return result, nil
+}
+
+// intersect returns the overlapping part of both time ranges.
+func (tr timeRange) intersect(other timeRange) (timeRange, bool) {
+	result := tr
+	if other.start.After(result.start) {
+		result.start = other.start
+	}
+
+	if other.end.Before(result.end) {
+		result.end = other.end
+	}
+
+	return result, result.end.After(result.start)
+}
+
+// subtractTimeRange removes the period of r from the sorted time ranges.
+func subtractTimeRange(ranges []timeRange, r timeRange) []timeRange {
+	var result []timeRange
+	for _, tr := range ranges {
+		if _, overlap := tr.intersect(r); !overlap {
+			result = append(result, tr)
+			continue
+		}
+
+		if tr.start.Before(r.start) {
+			result = append(result, timeRange{start: tr.start, end: r.start})
+		}
+
+		if r.end.Before(tr.end) {
+			result = append(result, timeRange{start: r.end, end: tr.end})
+		}
+	}
+
+	return result
+}
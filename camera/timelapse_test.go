@@ -0,0 +1,60 @@
+package camera
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubtractTimeRange(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time {
+		return base.Add(time.Duration(minute) * time.Minute)
+	}
+
+	tests := []struct {
+		name   string
+		ranges []timeRange
+		r      timeRange
+		want   []timeRange
+	}{{
+		name:   "no overlap",
+		ranges: []timeRange{{at(0), at(10)}},
+		r:      timeRange{at(10), at(20)},
+		want:   []timeRange{{at(0), at(10)}},
+	}, {
+		name:   "cover all",
+		ranges: []timeRange{{at(0), at(10)}},
+		r:      timeRange{at(-5), at(15)},
+		want:   nil,
+	}, {
+		name:   "cover the start",
+		ranges: []timeRange{{at(0), at(10)}},
+		r:      timeRange{at(-5), at(3)},
+		want:   []timeRange{{at(3), at(10)}},
+	}, {
+		name:   "cover the end",
+		ranges: []timeRange{{at(0), at(10)}},
+		r:      timeRange{at(7), at(15)},
+		want:   []timeRange{{at(0), at(7)}},
+	}, {
+		name:   "split in the middle",
+		ranges: []timeRange{{at(0), at(10)}},
+		r:      timeRange{at(3), at(7)},
+		want:   []timeRange{{at(0), at(3)}, {at(7), at(10)}},
+	}, {
+		name:   "span several ranges",
+		ranges: []timeRange{{at(0), at(10)}, {at(20), at(30)}, {at(40), at(50)}},
+		r:      timeRange{at(5), at(45)},
+		want:   []timeRange{{at(0), at(5)}, {at(45), at(50)}},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := subtractTimeRange(tt.ranges, tt.r)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package camera
+
+import (
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// UnavailableSource is placeholder for source that can't be created, e.g. because
+// its name in setting is unknown. It always fails to start, so the camera is kept
+// restarting with the error shown in its status, until the source is changed from
+// the web interface. This way the other cameras are not affected.
+type UnavailableSource struct {
+	DB       *bolt.DB
+	CameraID string
+	Err      error
+}
+
+// Name returns the name of the source that saved in camera setting.
+func (cam *UnavailableSource) Name() string {
+	name := ""
+	cam.DB.View(func(tx *bolt.Tx) error {
+		if bucket := Bucket(tx, cam.CameraID); bucket != nil {
+			name = string(bucket.Get([]byte("source")))
+		}
+		return nil
+	})
+
+	return name
+}
+
+// Setting returns the setting that saved in camera setting.
+func (cam *UnavailableSource) Setting() Setting {
+	return loadSetting(cam.DB, cam.CameraID)
+}
+
+// Capabilities returns nothing, since the source is unknown.
+func (cam *UnavailableSource) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// Start returns the reason why the source can't be created.
+func (cam *UnavailableSource) Start(w io.Writer) error {
+	return cam.Err
+}
+
+// Stop does nothing, since the source is never started.
+func (cam *UnavailableSource) Stop() {}
@@ -0,0 +1,305 @@
+package camera
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	rxV4L2Format    = regexp.MustCompile(`'(\w{3,4})'\s*(?:\((.+)\))?`)
+	rxV4L2Name      = regexp.MustCompile(`^Name\s*:\s*(.+)$`)
+	rxV4L2Size      = regexp.MustCompile(`^Size:\s*\w+\s+(\d+)x(\d+)`)
+	rxV4L2SizeRange = regexp.MustCompile(`^Size:\s*(?:Stepwise|Continuous)\s+(\d+)x(\d+)\s*-\s*(\d+)x(\d+)(?:\s+with step (\d+)/(\d+))?`)
+	rxV4L2FrameRate = regexp.MustCompile(`\(([\d.]+) fps\)`)
+)
+
+// v4l2InputFormats maps V4L2 pixel format to its ffmpeg's input format.
+var v4l2InputFormats = map[string]string{
+	"YUYV": "yuyv422",
+	"UYVY": "uyvy422",
+	"MJPG": "mjpeg",
+	"H264": "h264",
+	"YU12": "yuv420p",
+	"NV12": "nv12",
+	"RGB3": "rgb24",
+}
+
+// commonFrameSizes is the frame sizes that offered for device whose
+// frame size is not discrete, i.e. any size within a range is allowed.
+var commonFrameSizes = []FrameSize{
+	{Width: 320, Height: 240},
+	{Width: 640, Height: 480},
+	{Width: 800, Height: 600},
+	{Width: 1024, Height: 768},
+	{Width: 1280, Height: 720},
+	{Width: 1280, Height: 960},
+	{Width: 1600, Height: 1200},
+	{Width: 1920, Height: 1080},
+	{Width: 2560, Height: 1440},
+	{Width: 3840, Height: 2160},
+}
+
+// V4L2Cam is source for V4L2 device, e.g. USB webcam.
+// It uses ffmpeg to capture the device and encode it to H.264.
+type V4L2Cam struct {
+	DB       *bolt.DB
+	CameraID string
+
+	// Device is path to the V4L2 device, e.g. /dev/video0
+	Device string
+
+	// FormatsFile is optional path to file that contains the output of
+	// `v4l2-ctl --list-formats-ext`. If specified, it will be used to
+	// describe the device instead of querying the real device.
+	FormatsFile string
+
+	processSource
+}
+
+// Name returns the name of the source.
+func (cam *V4L2Cam) Name() string {
+	return "v4l2"
+}
+
+// Setting returns the setting that will be used by ffmpeg.
+// If the pixel format is not specified, the first supported format will be used.
+func (cam *V4L2Cam) Setting() Setting {
+	setting := loadSetting(cam.DB, cam.CameraID)
+	if setting.Format == "" {
+		formats, _ := cam.listFormats()
+		if len(formats) > 0 {
+			setting.Format = formats[0].Name
+		}
+	}
+
+	return setting
+}
+
+// Capabilities returns the settings that supported by the V4L2 device.
+func (cam *V4L2Cam) Capabilities() Capabilities {
+	formats, err := cam.listFormats()
+	if err != nil {
+		logrus.Warnln("failed to list V4L2 formats:", err)
+	}
+
+	resolutions := []string{}
+	maxFPS := 0
+	for _, format := range formats {
+		for _, size := range format.Sizes {
+			resolution := fmt.Sprintf("%dx%d", size.Width, size.Height)
+			if !containsString(resolutions, resolution) {
+				resolutions = append(resolutions, resolution)
+			}
+
+			for _, frameRate := range size.FrameRates {
+				if frameRate > maxFPS {
+					maxFPS = frameRate
+				}
+			}
+		}
+	}
+
+	return Capabilities{
+		Resolutions: resolutions,
+		Rotations:   []int{0, 90, 180, 270},
+		MaxFPS:      maxFPS,
+		Formats:     formats,
+	}
+}
+
+// Start runs ffmpeg to capture the device and writes its stream to w.
+func (cam *V4L2Cam) Start(w io.Writer) error {
+	return cam.run(cam.CameraID, cam.Name(), cam.genCmdV4L2(cam.Setting()), w)
+}
+
+// Stop kills the capture process.
+func (cam *V4L2Cam) Stop() {
+	cam.kill()
+}
+
+func (cam *V4L2Cam) listFormats() ([]PixelFormat, error) {
+	if cam.FormatsFile != "" {
+		f, err := os.Open(cam.FormatsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return parseV4L2Formats(f), nil
+	}
+
+	cmd := exec.Command("v4l2-ctl", "--device", cam.Device, "--list-formats-ext")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseV4L2Formats(bytes.NewReader(output)), nil
+}
+
+func (cam *V4L2Cam) genCmdV4L2(setting Setting) *exec.Cmd {
+	cmdArgs := []string{
+		"-loglevel", "error",
+		"-f", "v4l2",
+		"-framerate", strconv.Itoa(setting.FPS),
+		"-video_size", fmt.Sprintf("%dx%d", setting.Width, setting.Height)}
+
+	if inputFormat, ok := v4l2InputFormats[setting.Format]; ok {
+		cmdArgs = append(cmdArgs, "-input_format", inputFormat)
+	}
+
+	cmdArgs = append(cmdArgs, "-i", cam.Device, "-an")
+
+	// If the device already produces H.264 and no rotation needed,
+	// there are no need to encode it again.
+	if setting.Format == "H264" && setting.Rotation == 0 {
+		cmdArgs = append(cmdArgs, "-codec", "copy")
+	} else {
+		if filter := rotationFilter(setting.Rotation); filter != "" {
+			cmdArgs = append(cmdArgs, "-vf", filter)
+		}
+
+		cmdArgs = append(cmdArgs,
+			"-codec:v", "libx264",
+			"-preset", "ultrafast",
+			"-tune", "zerolatency",
+			"-pix_fmt", "yuv420p",
+			"-g", strconv.Itoa(setting.FPS*2))
+	}
+
+	cmdArgs = append(cmdArgs, "-f", "h264", "pipe:1")
+	return exec.Command("ffmpeg", cmdArgs...)
+}
+
+// parseV4L2Formats parses the output of `v4l2-ctl --list-formats-ext`.
+func parseV4L2Formats(r io.Reader) []PixelFormat {
+	formats := []PixelFormat{}
+	formatIdx, sizeIdx := -1, -1
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "["), strings.HasPrefix(line, "Pixel Format"):
+			// Make sure the sizes of unknown format are not
+			// mistakenly added to the previous format
+			parts := rxV4L2Format.FindStringSubmatch(line)
+			if parts == nil {
+				formatIdx = -1
+				continue
+			}
+
+			formats = append(formats, PixelFormat{
+				Name:        parts[1],
+				Description: parts[2],
+				Sizes:       []FrameSize{},
+			})
+
+			formatIdx = len(formats) - 1
+			sizeIdx = -1
+		case rxV4L2Name.MatchString(line):
+			if formatIdx < 0 {
+				continue
+			}
+
+			formats[formatIdx].Description = rxV4L2Name.FindStringSubmatch(line)[1]
+		case rxV4L2SizeRange.MatchString(line):
+			if formatIdx < 0 {
+				continue
+			}
+
+			// The frame rates are not listed for stepwise and continuous size
+			parts := rxV4L2SizeRange.FindStringSubmatch(line)
+			format := &formats[formatIdx]
+			format.Sizes = append(format.Sizes, frameSizesInRange(parts[1:])...)
+			sizeIdx = -1
+		case rxV4L2Size.MatchString(line):
+			if formatIdx < 0 {
+				continue
+			}
+
+			parts := rxV4L2Size.FindStringSubmatch(line)
+			width, _ := strconv.Atoi(parts[1])
+			height, _ := strconv.Atoi(parts[2])
+
+			format := &formats[formatIdx]
+			format.Sizes = append(format.Sizes, FrameSize{
+				Width:      width,
+				Height:     height,
+				FrameRates: []int{},
+			})
+
+			sizeIdx = len(format.Sizes) - 1
+		case rxV4L2FrameRate.MatchString(line):
+			if formatIdx < 0 || sizeIdx < 0 {
+				continue
+			}
+
+			parts := rxV4L2FrameRate.FindStringSubmatch(line)
+			fFrameRate, _ := strconv.ParseFloat(parts[1], 64)
+			frameRate := int(math.Round(fFrameRate))
+
+			size := &formats[formatIdx].Sizes[sizeIdx]
+			if frameRate > 0 && !containsInt(size.FrameRates, frameRate) {
+				size.FrameRates = append(size.FrameRates, frameRate)
+			}
+		}
+	}
+
+	for _, format := range formats {
+		for _, size := range format.Sizes {
+			sort.Sort(sort.Reverse(sort.IntSlice(size.FrameRates)))
+		}
+	}
+
+	return formats
+}
+
+// frameSizesInRange returns the common frame sizes that allowed by stepwise or continuous
+// size, followed by its maximum size. The values are min width, min height, max width,
+// max height, and optionally the width step and height step.
+func frameSizesInRange(values []string) []FrameSize {
+	var numbers [6]int
+	for i, value := range values {
+		numbers[i], _ = strconv.Atoi(value)
+	}
+
+	minWidth, minHeight, maxWidth, maxHeight := numbers[0], numbers[1], numbers[2], numbers[3]
+	stepWidth, stepHeight := numbers[4], numbers[5]
+	if stepWidth <= 0 {
+		stepWidth = 1
+	}
+	if stepHeight <= 0 {
+		stepHeight = 1
+	}
+
+	sizes := []FrameSize{}
+	for _, size := range commonFrameSizes {
+		if size.Width < minWidth || size.Width > maxWidth ||
+			size.Height < minHeight || size.Height > maxHeight ||
+			(size.Width-minWidth)%stepWidth != 0 || (size.Height-minHeight)%stepHeight != 0 {
+			continue
+		}
+
+		if size.Width == maxWidth && size.Height == maxHeight {
+			continue
+		}
+
+		sizes = append(sizes, FrameSize{Width: size.Width, Height: size.Height, FrameRates: []int{}})
+	}
+
+	return append(sizes, FrameSize{Width: maxWidth, Height: maxHeight, FrameRates: []int{}})
+}
@@ -0,0 +1,140 @@
+package camera
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// sizesWithoutFrameRates returns frame sizes from "WxH" pairs, whose frame rates are not listed.
+func sizesWithoutFrameRates(pairs ...[2]int) []FrameSize {
+	sizes := []FrameSize{}
+	for _, pair := range pairs {
+		sizes = append(sizes, FrameSize{Width: pair[0], Height: pair[1], FrameRates: []int{}})
+	}
+	return sizes
+}
+
+func TestParseV4L2Formats(t *testing.T) {
+	bcm2835Sizes := sizesWithoutFrameRates(
+		[2]int{320, 240}, [2]int{640, 480}, [2]int{800, 600}, [2]int{1024, 768},
+		[2]int{1280, 720}, [2]int{1280, 960}, [2]int{1600, 1200}, [2]int{1920, 1080},
+		[2]int{2560, 1440}, [2]int{2592, 1944})
+
+	continuousSizes := sizesWithoutFrameRates(
+		[2]int{320, 240}, [2]int{640, 480}, [2]int{800, 600}, [2]int{1024, 768},
+		[2]int{1280, 720}, [2]int{1280, 800})
+
+	tests := []struct {
+		name    string
+		fixture string
+		want    []PixelFormat
+	}{{
+		name:    "webcam with several pixel formats",
+		fixture: "testdata/v4l2-webcam.txt",
+		want: []PixelFormat{{
+			Name:        "YUYV",
+			Description: "YUYV 4:2:2",
+			Sizes: []FrameSize{
+				{Width: 640, Height: 480, FrameRates: []int{30, 24, 20, 15, 10, 8, 5}},
+				{Width: 1280, Height: 720, FrameRates: []int{10, 8, 5}},
+				{Width: 1920, Height: 1080, FrameRates: []int{5}},
+			},
+		}, {
+			Name:        "H264",
+			Description: "H.264, compressed",
+			Sizes: []FrameSize{
+				{Width: 640, Height: 480, FrameRates: []int{30, 24, 20, 15}},
+				{Width: 1920, Height: 1080, FrameRates: []int{30, 24}},
+			},
+		}, {
+			Name:        "MJPG",
+			Description: "Motion-JPEG, compressed",
+			Sizes: []FrameSize{
+				{Width: 1280, Height: 720, FrameRates: []int{30, 24}},
+				{Width: 1920, Height: 1080, FrameRates: []int{30}},
+			},
+		}},
+	}, {
+		name:    "Raspberry Pi camera with stepwise size",
+		fixture: "testdata/v4l2-bcm2835.txt",
+		want: []PixelFormat{
+			{Name: "YU12", Description: "Planar YUV 4:2:0", Sizes: bcm2835Sizes},
+			{Name: "YUYV", Description: "YUYV 4:2:2", Sizes: bcm2835Sizes},
+			{Name: "RGB3", Description: "24-bit RGB 8-8-8", Sizes: bcm2835Sizes},
+			{Name: "JPEG", Description: "JFIF JPEG, compressed", Sizes: bcm2835Sizes},
+			{Name: "H264", Description: "H.264, compressed", Sizes: bcm2835Sizes},
+			{Name: "MJPG", Description: "Motion-JPEG, compressed", Sizes: bcm2835Sizes},
+		},
+	}, {
+		name:    "continuous size and unknown pixel format",
+		fixture: "testdata/v4l2-continuous.txt",
+		want: []PixelFormat{
+			{Name: "GREY", Description: "8-bit Greyscale", Sizes: continuousSizes},
+			{Name: "YUYV", Description: "YUYV 4:2:2", Sizes: continuousSizes},
+		},
+	}, {
+		name:    "output of old v4l2-ctl",
+		fixture: "testdata/v4l2-old.txt",
+		want: []PixelFormat{{
+			Name:        "YUYV",
+			Description: "YUYV 4:2:2",
+			Sizes: []FrameSize{
+				{Width: 640, Height: 480, FrameRates: []int{30, 15}},
+				{Width: 320, Height: 240, FrameRates: []int{30}},
+			},
+		}, {
+			Name:        "MJPG",
+			Description: "Motion-JPEG",
+			Sizes: []FrameSize{
+				{Width: 640, Height: 480, FrameRates: []int{30}},
+			},
+		}},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got := parseV4L2Formats(f)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestV4L2Capabilities(t *testing.T) {
+	tests := []struct {
+		fixture         string
+		wantResolutions []string
+		wantMaxFPS      int
+	}{{
+		fixture:         "testdata/v4l2-webcam.txt",
+		wantResolutions: []string{"640x480", "1280x720", "1920x1080"},
+		wantMaxFPS:      30,
+	}, {
+		fixture: "testdata/v4l2-continuous.txt",
+		wantResolutions: []string{"320x240", "640x480", "800x600",
+			"1024x768", "1280x720", "1280x800"},
+		wantMaxFPS: 0,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			cam := &V4L2Cam{FormatsFile: tt.fixture}
+			capabilities := cam.Capabilities()
+			if !reflect.DeepEqual(capabilities.Resolutions, tt.wantResolutions) {
+				t.Errorf("got resolutions %v, want %v", capabilities.Resolutions, tt.wantResolutions)
+			}
+
+			if capabilities.MaxFPS != tt.wantMaxFPS {
+				t.Errorf("got max framerate %d, want %d", capabilities.MaxFPS, tt.wantMaxFPS)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package camera
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	webrtcGatherTimeout  = 5 * time.Second
+	webrtcConnectTimeout = 30 * time.Second
+)
+
+// webrtcSessions is the WebRTC viewers of camera.
+type webrtcSessions struct {
+	mutex    sync.Mutex
+	sessions map[string]*webrtcSession
+}
+
+// webrtcSession is a peer connection that receives the live stream.
+// The H.264 stream from camera source is sent as it is, without re-encoding.
+type webrtcSession struct {
+	id        string
+	cameraID  string
+	fps       int
+	pc        *webrtc.PeerConnection
+	track     *webrtc.TrackLocalStaticSample
+	closeOnce sync.Once
+	chClosed  chan struct{}
+}
+
+// StartWebRTC creates WebRTC session for the SDP offer from viewer, which
+// only receives the video. Returns ID of the session and the SDP answer.
+// ICE candidates are not trickled, so the answer already contains them.
+func (cam *Camera) StartWebRTC(offer string) (string, string, error) {
+	sessionID, err := uuid.NewV4()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create session ID: %v", err)
+	}
+
+	// The profile of stream must be known, so the browser can decode it
+	profileLevelID, err := cam.hub.profileLevelID()
+	if err != nil {
+		return "", "", err
+	}
+
+	// Prepare the peer connection
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create peer connection: %v", err)
+	}
+
+	track, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profileLevelID,
+		}, "video", "cygnus-"+cam.ID)
+	if err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("failed to create video track: %v", err)
+	}
+
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("failed to add video track: %v", err)
+	}
+
+	// Create the answer
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("invalid offer: %v", err)
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("failed to create answer: %v", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("failed to set answer: %v", err)
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-time.After(webrtcGatherTimeout):
+		pc.Close()
+		return "", "", fmt.Errorf("failed to gather ICE candidates")
+	}
+
+	// Register the session
+	session := &webrtcSession{
+		id:       sessionID.String(),
+		cameraID: cam.ID,
+		fps:      cam.Source.Setting().FPS,
+		pc:       pc,
+		track:    track,
+		chClosed: make(chan struct{}),
+	}
+
+	cam.rtc.mutex.Lock()
+	if cam.rtc.sessions == nil {
+		cam.rtc.sessions = make(map[string]*webrtcSession)
+	}
+	cam.rtc.sessions[session.id] = session
+	cam.rtc.mutex.Unlock()
+
+	// Session is closed when viewer gone
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			cam.StopWebRTC(session.id)
+		}
+	})
+
+	time.AfterFunc(webrtcConnectTimeout, func() {
+		if pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+			cam.StopWebRTC(session.id)
+		}
+	})
+
+	// RTCP must be read for the interceptors to work
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	go cam.runWebRTC(session)
+
+	logrus.WithField("camera", cam.ID).Infoln("webrtc session started", session.id)
+	return session.id, pc.LocalDescription().SDP, nil
+}
+
+// StopWebRTC closes the WebRTC session with specified ID.
+func (cam *Camera) StopWebRTC(id string) error {
+	cam.rtc.mutex.Lock()
+	session, exist := cam.rtc.sessions[id]
+	delete(cam.rtc.sessions, id)
+	cam.rtc.mutex.Unlock()
+
+	if !exist {
+		return fmt.Errorf("webrtc session %s is not exist", id)
+	}
+
+	session.close()
+	return nil
+}
+
+// runWebRTC sends the live stream to the session until it's closed.
+func (cam *Camera) runWebRTC(session *webrtcSession) {
+	reader := cam.hub.attach()
+	defer cam.hub.detach(reader)
+
+	fps := session.fps
+	if fps <= 0 {
+		fps = 1
+	}
+	frameDuration := time.Second / time.Duration(fps)
+
+	for {
+		select {
+		case <-session.chClosed:
+			return
+		case unit := <-reader.chUnit:
+			// All NAL units of the frame are sent with the same RTP timestamp
+			err := session.track.WriteSample(media.Sample{
+				Data:     unit.data(),
+				Duration: frameDuration,
+			})
+			if err != nil {
+				logrus.WithField("camera", cam.ID).Warnln("failed to send webrtc stream:", err)
+				cam.StopWebRTC(session.id)
+				return
+			}
+		}
+	}
+}
+
+func (ws *webrtcSession) close() {
+	ws.closeOnce.Do(func() {
+		close(ws.chClosed)
+		ws.pc.Close()
+		logrus.WithField("camera", ws.cameraID).Infoln("webrtc session stopped", ws.id)
+	})
+}
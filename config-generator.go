@@ -6,6 +6,8 @@ import (
 	"os"
 	fp "path/filepath"
 	"strconv"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
 )
 
 func init() {
@@ -17,6 +19,15 @@ func init() {
 		}
 	}
 
+	// Set port number for RTSP server. It's disabled by default,
+	// since the cameras are exposed to anyone with RTSP credential.
+	rtspPort = 0
+	if envRTSPPort, found := os.LookupEnv("CYGNUS_RTSP_PORT"); found {
+		if intPort, err := strconv.Atoi(envRTSPPort); intPort > 0 && err == nil {
+			rtspPort = intPort
+		}
+	}
+
 	// Set max storage size
 	maxStorageSize = 0
 	if envMaxSize, found := os.LookupEnv("CYGNUS_STORAGE_SIZE"); found {
@@ -26,6 +37,20 @@ func init() {
 	}
 
 	// Set camera config
+	camSource = "raspivid"
+	if envCamSource, found := os.LookupEnv("CYGNUS_CAM_SOURCE"); found && envCamSource != "" {
+		camSource = envCamSource
+	}
+
+	camDevice = "/dev/video0"
+	if envCamDevice, found := os.LookupEnv("CYGNUS_CAM_DEVICE"); found && envCamDevice != "" {
+		camDevice = envCamDevice
+	}
+
+	// Set path to file that describes V4L2 device. Useful for testing
+	// when there are no real V4L2 device available.
+	v4l2FormatsFile, _ = os.LookupEnv("CYGNUS_V4L2_FORMATS_FILE")
+
 	camWidth = 800
 	if envCamWidth, found := os.LookupEnv("CYGNUS_CAM_WIDTH"); found {
 		if intCamWidth, err := strconv.Atoi(envCamWidth); intCamWidth > 0 && err == nil {
@@ -42,6 +67,17 @@ func init() {
 
 	_, camFlip = os.LookupEnv("CYGNUS_CAM_FLIP")
 
+	// Set camera location, used for scheduling profile at sunrise and sunset
+	envLatitude, latitudeFound := os.LookupEnv("CYGNUS_LATITUDE")
+	envLongitude, longitudeFound := os.LookupEnv("CYGNUS_LONGITUDE")
+	if latitudeFound && longitudeFound {
+		latitude, errLat := strconv.ParseFloat(envLatitude, 64)
+		longitude, errLon := strconv.ParseFloat(envLongitude, 64)
+		if errLat == nil && errLon == nil && latitude >= -90 && latitude <= 90 && longitude >= -180 && longitude <= 180 {
+			camLocation = &camera.Location{Latitude: latitude, Longitude: longitude}
+		}
+	}
+
 	// Set data directory
 	homeDir := os.Getenv("HOME")
 	cygnusDir := fp.Join(homeDir, "cygnus-data")
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetArming is handler for GET /api/arming/:camera
+func (h *WebHandler) APIGetArming(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	arming := CameraArming{
+		Arming: camera.LoadArming(h.DB, cam.ID),
+		Armed:  cam.Armed(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&arming)
+	checkError(err)
+}
+
+// APISaveArming is handler for POST /api/arming/:camera. To simply arm or
+// disarm the camera, only the mode needs to be sent, e.g. {"mode":"armed"},
+// in which case the saved schedule is kept.
+func (h *WebHandler) APISaveArming(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Decode request on top of the current setting
+	cam := h.getCamera(ps.ByName("camera"))
+	arming := camera.LoadArming(h.DB, cam.ID)
+	err = json.NewDecoder(r.Body).Decode(&arming)
+	checkError(err)
+
+	// Applied immediately, so camera doesn't need to be restarted
+	err = cam.SetArming(arming)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
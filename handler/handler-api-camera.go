@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/julienschmidt/httprouter"
+	bolt "go.etcd.io/bbolt"
+)
+
+var rxCameraID = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// APIGetCameras is handler for GET /api/camera
+func (h *WebHandler) APIGetCameras(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Get list of cameras
+	cameras := []CameraInfo{}
+	for _, cam := range h.Cameras {
+		cameras = append(cameras, CameraInfo{
+			ID:         cam.ID,
+			Source:     cam.Source.Name(),
+			Armed:      cam.Armed(),
+			LowLatency: cam.LowLatency(),
+			Status:     cam.Status(),
+		})
+	}
+
+	// Decode to JSON
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&cameras)
+	checkError(err)
+}
+
+// APIInsertCamera is handler for POST /api/camera
+func (h *WebHandler) APIInsertCamera(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Decode request
+	var request NewCamera
+	err = json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	if !rxCameraID.MatchString(request.ID) {
+		panic(fmt.Errorf("camera ID must only contains letter, number, dash and underscore"))
+	}
+
+	err = h.validateSourceName(request.Source)
+	checkError(err)
+
+	// Save camera to database
+	err = h.DB.Update(func(tx *bolt.Tx) error {
+		cameras, _ := tx.CreateBucketIfNotExists([]byte("cameras"))
+		if cameras.Bucket([]byte(request.ID)) != nil {
+			return fmt.Errorf("camera %s already exists", request.ID)
+		}
+
+		bucket, err := cameras.CreateBucket([]byte(request.ID))
+		if err != nil {
+			return err
+		}
+
+		bucket.Put([]byte("source"), []byte(request.Source))
+		bucket.Put([]byte("fps"), []byte("30"))
+		bucket.Put([]byte("rotation"), []byte("0"))
+		bucket.Put([]byte("resolution"), []byte("800x600"))
+		return nil
+	})
+	checkError(err)
+
+	h.ChRestart <- true
+	fmt.Fprint(w, 1)
+}
+
+// APIDeleteCamera is handler for DELETE /api/camera/:id.
+// The recorded videos of the camera are kept in storage.
+func (h *WebHandler) APIDeleteCamera(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Make sure camera exists and it's not the last one
+	cam := h.getCamera(ps.ByName("id"))
+	if len(h.Cameras) <= 1 {
+		panic(fmt.Errorf("the last camera can't be deleted"))
+	}
+
+	// Delete from database
+	err = h.DB.Update(func(tx *bolt.Tx) error {
+		if camera.Bucket(tx, cam.ID) == nil {
+			return nil
+		}
+
+		// Profiles, schedules, arming, masks and restreams are useless without
+		// the camera. Restreams must be removed as well since they contain
+		// stream keys, and a new camera with same ID would push to them.
+		if bucket := tx.Bucket([]byte("profiles")); bucket != nil && bucket.Bucket([]byte(cam.ID)) != nil {
+			bucket.DeleteBucket([]byte(cam.ID))
+		}
+
+		for _, name := range []string{"schedules", "arming", "masks", "restreams"} {
+			if bucket := tx.Bucket([]byte(name)); bucket != nil {
+				bucket.Delete([]byte(cam.ID))
+			}
+		}
+
+		return tx.Bucket([]byte("cameras")).DeleteBucket([]byte(cam.ID))
+	})
+	checkError(err)
+
+	h.ChRestart <- true
+	fmt.Fprint(w, 1)
+}
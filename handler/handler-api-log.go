@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RadhiFadlillah/cygnus/logs"
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetLogs is handler for GET /api/logs. If query "follow" is specified,
+// the log entries will be streamed as server-sent events.
+func (h *WebHandler) APIGetLogs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// If not following, just return the recent logs
+	if r.URL.Query().Get("follow") == "" {
+		entries := h.Logs.Entries()
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(&entries)
+		checkError(err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		panic(fmt.Errorf("log streaming is not supported"))
+	}
+
+	// Subscribe before fetching recent logs, so no entries missed
+	chEntry, unsubscribe := h.Logs.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	for _, entry := range h.Logs.Entries() {
+		writeLogEvent(w, entry)
+	}
+	flusher.Flush()
+
+	// Stream the new logs until client disconnected
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry := <-chEntry:
+			writeLogEvent(w, entry)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeLogEvent(w http.ResponseWriter, entry logs.Entry) {
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetMasks is handler for GET /api/mask/:camera
+func (h *WebHandler) APIGetMasks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	masks := camera.Masks(h.DB, cam.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&masks)
+	checkError(err)
+}
+
+// APISaveMasks is handler for POST /api/mask/:camera
+func (h *WebHandler) APISaveMasks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Decode request
+	cam := h.getCamera(ps.ByName("camera"))
+	masks := []camera.Mask{}
+	err = json.NewDecoder(r.Body).Decode(&masks)
+	checkError(err)
+
+	err = camera.SaveMasks(h.DB, cam.ID, masks)
+	checkError(err)
+
+	h.ChRestart <- true
+	fmt.Fprint(w, 1)
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetProfiles is handler for GET /api/profile/:camera
+func (h *WebHandler) APIGetProfiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	profiles := CameraProfiles{
+		Active:   camera.ActiveProfile(h.DB, cam.ID),
+		Profiles: camera.Profiles(h.DB, cam.ID),
+		Schedule: camera.Schedule(h.DB, cam.ID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&profiles)
+	checkError(err)
+}
+
+// APISaveProfile is handler for POST /api/profile/:camera
+func (h *WebHandler) APISaveProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Decode request
+	cam := h.getCamera(ps.ByName("camera"))
+	var request camera.Profile
+	err = json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	if !rxCameraID.MatchString(request.Name) {
+		panic(fmt.Errorf("profile name must only contains letter, number, dash and underscore"))
+	}
+
+	// Only keep the camera parameters, and make sure they're supported by source
+	err = validateCapabilities(cam.Source, request.Setting)
+	checkError(err)
+
+	profile := camera.Profile{
+		Name:    request.Name,
+		Setting: make(map[string]string),
+	}
+
+	for _, key := range camera.ProfileKeys(cam.Source) {
+		profile.Setting[key] = request.Setting[key]
+	}
+
+	err = camera.SaveProfile(h.DB, cam.ID, profile)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// APIDeleteProfile is handler for DELETE /api/profile/:camera/:name
+func (h *WebHandler) APIDeleteProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Make sure the profile is not used in schedule
+	cam := h.getCamera(ps.ByName("camera"))
+	name := ps.ByName("name")
+	for _, entry := range camera.Schedule(h.DB, cam.ID) {
+		if entry.Profile == name {
+			panic(fmt.Errorf("profile %s is still used in schedule", name))
+		}
+	}
+
+	err = camera.DeleteProfile(h.DB, cam.ID, name)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// APIActivateProfile is handler for POST /api/profile/:camera/:name/activate
+func (h *WebHandler) APIActivateProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	err = camera.ActivateProfile(h.DB, cam.ID, ps.ByName("name"), "manual")
+	checkError(err)
+
+	h.ChRestart <- true
+	fmt.Fprint(w, 1)
+}
+
+// APISaveSchedule is handler for POST /api/schedule/:camera
+func (h *WebHandler) APISaveSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Decode request
+	cam := h.getCamera(ps.ByName("camera"))
+	entries := []camera.ScheduleEntry{}
+	err = json.NewDecoder(r.Body).Decode(&entries)
+	checkError(err)
+
+	err = h.Scheduler.SaveSchedule(cam.ID, entries)
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// APIGetEvents is handler for GET /api/events
+func (h *WebHandler) APIGetEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+
+	events := camera.Events(h.DB, limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&events)
+	checkError(err)
+}
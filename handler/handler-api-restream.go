@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetRestreams is handler for GET /api/restream/:camera
+func (h *WebHandler) APIGetRestreams(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	restreams := CameraRestreams{
+		Targets:  camera.RestreamTargets(h.DB, cam.ID),
+		Statuses: cam.RestreamStatuses(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&restreams)
+	checkError(err)
+}
+
+// APISaveRestreams is handler for POST /api/restream/:camera
+func (h *WebHandler) APISaveRestreams(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Decode request
+	cam := h.getCamera(ps.ByName("camera"))
+	targets := []camera.RestreamTarget{}
+	err = json.NewDecoder(r.Body).Decode(&targets)
+	checkError(err)
+
+	err = camera.SaveRestreamTargets(h.DB, cam.ID, targets)
+	checkError(err)
+
+	// Restreams are applied without restarting the camera
+	cam.ReloadRestreams()
+	fmt.Fprint(w, 1)
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"os/exec"
+	"strconv"
 
+	"github.com/RadhiFadlillah/cygnus/camera"
 	"github.com/julienschmidt/httprouter"
 	bolt "go.etcd.io/bbolt"
 	"golang.org/x/crypto/bcrypt"
@@ -17,14 +19,19 @@ func (h *WebHandler) APIGetSetting(w http.ResponseWriter, r *http.Request, ps ht
 	err := h.validateSession(r)
 	checkError(err)
 
-	// Get list of usernames and setting
+	// Get list of usernames and camera settings
 	users := h.getUsers()
-	camera := h.getCameraSetting()
+	cameras := []CameraSetting{}
+	for _, cam := range h.Cameras {
+		cameras = append(cameras, h.getCameraSetting(cam))
+	}
 
-	// Decode to JSON
 	data := map[string]interface{}{
-		"users":  users,
-		"camera": camera,
+		"users":     users,
+		"cameras":   cameras,
+		"sources":   h.SourceNames,
+		"rtspPort":  h.RTSPPort,
+		"rtspReset": camera.UsersWithoutDigest(h.DB),
 	}
 
 	// Decode to JSON
@@ -78,10 +85,15 @@ func (h *WebHandler) APIInsertUser(w http.ResponseWriter, r *http.Request, ps ht
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
 	checkError(err)
 
-	// Save user to database
+	// Save user to database, along with the digest of password for RTSP server
 	err = h.DB.Update(func(tx *bolt.Tx) error {
 		bucket, _ := tx.CreateBucketIfNotExists([]byte("user"))
-		return bucket.Put([]byte(user.Username), hashedPassword)
+		if err := bucket.Put([]byte(user.Username), hashedPassword); err != nil {
+			return err
+		}
+
+		digestBucket, _ := tx.CreateBucketIfNotExists([]byte("user-digest"))
+		return digestBucket.Put([]byte(user.Username), []byte(camera.UserDigest(user.Username, user.Password)))
 	})
 	checkError(err)
 
@@ -105,6 +117,9 @@ func (h *WebHandler) APIDeleteUser(w http.ResponseWriter, r *http.Request, ps ht
 		}
 
 		bucket.Delete([]byte(username))
+		if digestBucket := tx.Bucket([]byte("user-digest")); digestBucket != nil {
+			digestBucket.Delete([]byte(username))
+		}
 		return nil
 	})
 
@@ -122,14 +137,15 @@ func (h *WebHandler) APIDeleteUser(w http.ResponseWriter, r *http.Request, ps ht
 	fmt.Fprint(w, 1)
 }
 
-// APIGetCameraSetting is handler for GET /api/setting/camera
+// APIGetCameraSetting is handler for GET /api/setting/camera/:id
 func (h *WebHandler) APIGetCameraSetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Make sure session still valid
 	err := h.validateSession(r)
 	checkError(err)
 
-	// Get list of usernames from database
-	setting := h.getCameraSetting()
+	// Get camera setting from database
+	cam := h.getCamera(ps.ByName("id"))
+	setting := h.getCameraSetting(cam)
 
 	// Decode to JSON
 	w.Header().Set("Content-Type", "application/json")
@@ -137,26 +153,96 @@ func (h *WebHandler) APIGetCameraSetting(w http.ResponseWriter, r *http.Request,
 	checkError(err)
 }
 
-// APISaveCameraSetting is handler for POST /api/setting/camera
+// APISaveCameraSetting is handler for POST /api/setting/camera/:id
 func (h *WebHandler) APISaveCameraSetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Make sure session still valid
 	err := h.validateSession(r)
 	checkError(err)
 
 	// Decode request
-	setting := make(map[string]string)
-	err = json.NewDecoder(r.Body).Decode(&setting)
+	cam := h.getCamera(ps.ByName("id"))
+	request := make(map[string]string)
+	err = json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	// Make sure the source is available
+	sourceName := request["source"]
+	if sourceName == "" {
+		sourceName = cam.Source.Name()
+	}
+
+	err = h.validateSourceName(sourceName)
+	checkError(err)
+
+	source := cam.Source
+	if sourceName != cam.Source.Name() {
+		source, err = h.NewSource(cam.ID, sourceName)
+		checkError(err)
+	}
+
+	// Keys that omitted from request keep their saved value, so the
+	// whole setting must be validated, including the unchanged one
+	setting := h.getCameraSetting(cam).Setting
+	for key, val := range request {
+		setting[key] = val
+	}
+
+	err = validateCapabilities(source, setting)
+	checkError(err)
+
+	err = camera.ValidateSetting(setting)
 	checkError(err)
 
 	// Save setting to database
-	h.DB.Update(func(tx *bolt.Tx) error {
-		bucket, _ := tx.CreateBucketIfNotExists([]byte("camera"))
-		bucket.Put([]byte("fps"), []byte(setting["fps"]))
-		bucket.Put([]byte("rotation"), []byte(setting["rotation"]))
-		bucket.Put([]byte("resolution"), []byte(setting["resolution"]))
+	keys := []string{"fps", "rotation", "resolution", "format",
+		"url", "username", "file", "motion", "motionThreshold",
+		"recording", "preRoll", "postRoll", "mjpegFps",
+		"audioDevice", "audioSampleRate", "audioBitrate", "audioMute",
+		"lowRendition", "lowWidth", "lowBitrate", "lowLatency"}
+	for _, param := range source.Capabilities().Parameters {
+		keys = append(keys, param.Key)
+	}
+
+	err = h.DB.Update(func(tx *bolt.Tx) error {
+		bucket := camera.Bucket(tx, cam.ID)
+		if bucket == nil {
+			return fmt.Errorf("camera %s is not exist", cam.ID)
+		}
+
+		// Once the profile keys are changed by hand, the camera no longer uses the
+		// active profile, so it must be cleared to let the schedule applies it again
+		for _, key := range camera.ProfileKeys(source) {
+			if val, exist := request[key]; exist && val != string(bucket.Get([]byte(key))) {
+				if err := bucket.Delete([]byte("profile")); err != nil {
+					return err
+				}
+				break
+			}
+		}
+
+		if err := bucket.Put([]byte("source"), []byte(sourceName)); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			val, exist := request[key]
+			if !exist {
+				continue
+			}
+
+			if err := bucket.Put([]byte(key), []byte(val)); err != nil {
+				return err
+			}
+		}
+
+		// Password is never sent to client, so only save it when it's changed
+		if request["password"] != "" {
+			return bucket.Put([]byte("password"), []byte(request["password"]))
+		}
 
 		return nil
 	})
+	checkError(err)
 
 	h.ChRestart <- true
 	fmt.Fprint(w, 1)
@@ -197,21 +283,60 @@ func (h *WebHandler) getUsers() []string {
 	return users
 }
 
-func (h *WebHandler) getCameraSetting() map[string]string {
+func (h *WebHandler) getCameraSetting(cam *camera.Camera) CameraSetting {
 	setting := make(map[string]string)
 	h.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("camera"))
+		bucket := camera.Bucket(tx, cam.ID)
 		if bucket == nil {
 			return nil
 		}
 
 		bucket.ForEach(func(key, val []byte) error {
-			setting[string(key)] = string(val)
+			if string(key) != "password" {
+				setting[string(key)] = string(val)
+			}
 			return nil
 		})
 
 		return nil
 	})
 
-	return setting
+	return CameraSetting{
+		ID:           cam.ID,
+		Source:       cam.Source.Name(),
+		Setting:      setting,
+		Capabilities: cam.Source.Capabilities(),
+		Status:       cam.Status(),
+	}
+}
+
+// validateCapabilities makes sure the setting is supported by the camera source.
+func validateCapabilities(source camera.Source, setting map[string]string) error {
+	fps, err := strconv.Atoi(setting["fps"])
+	if err != nil {
+		return fmt.Errorf("framerate must be a number")
+	}
+
+	rotation, err := strconv.Atoi(setting["rotation"])
+	if err != nil {
+		return fmt.Errorf("rotation must be a number")
+	}
+
+	capabilities := source.Capabilities()
+	err = capabilities.Validate(setting["format"], setting["resolution"], fps, rotation)
+	if err != nil {
+		return err
+	}
+
+	return camera.ValidateParameters(capabilities.Parameters, setting)
+}
+
+func (h *WebHandler) validateSourceName(name string) error {
+	for _, sourceName := range h.SourceNames {
+		if sourceName == name {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("camera source %s is not available", name)
 }
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/RadhiFadlillah/cygnus/logs"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// APIGetSnapshot is handler for GET /api/snapshot. It serves JPEG image of
+// the latest keyframe of live stream, or if it's not available yet, the last
+// frame of the latest HLS segment. The capture time of live keyframe is sent
+// in Last-Modified header. Query "camera" is used to select the camera,
+// default to the first camera.
+func (h *WebHandler) APIGetSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	// Get the camera
+	var cam *camera.Camera
+	if cameraID := r.URL.Query().Get("camera"); cameraID != "" {
+		cam = h.getCamera(cameraID)
+	} else if len(h.Cameras) > 0 {
+		cam = h.Cameras[0]
+	} else {
+		panic(fmt.Errorf("no camera available"))
+	}
+
+	// Use the latest keyframe, which doesn't need to read any file
+	image, captured, err := cam.Snapshot()
+	checkError(err)
+
+	if image != nil {
+		w.Header().Set("Last-Modified", captured.UTC().Format(http.TimeFormat))
+		serveSnapshot(w, image, "no-cache, no-store, must-revalidate")
+		return
+	}
+
+	// Find the latest segment, then take its last frame
+	segmentPath, err := latestLiveSegment(cam)
+	checkError(err)
+
+	image, err = captureFrame(cam.ID, nil, "-sseof", "-1", "-i", segmentPath)
+	checkError(err)
+
+	serveSnapshot(w, image, "no-cache, no-store, must-revalidate")
+}
+
+// latestLiveSegment returns path to the newest segment in live HLS playlist.
+func latestLiveSegment(cam *camera.Camera) (string, error) {
+	playlist, err := os.Open(fp.Join(cam.HlsSegmentsDir, "playlist.m3u8"))
+	if err != nil {
+		return "", fmt.Errorf("live stream is not available yet")
+	}
+	defer playlist.Close()
+
+	segmentName := ""
+	scanner := bufio.NewScanner(playlist)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			segmentName = fp.Base(line)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if segmentName == "" {
+		return "", fmt.Errorf("live stream is not available yet")
+	}
+
+	return fp.Join(cam.HlsSegmentsDir, segmentName), nil
+}
+
+// captureFrame runs ffmpeg with the specified input arguments and stdin,
+// and returns the first decoded frame as JPEG image.
+func captureFrame(cameraID string, stdin io.Reader, inputArgs ...string) ([]byte, error) {
+	stderr := logs.NewLineWriter(logrus.Fields{
+		"camera":    cameraID,
+		"component": "snapshot",
+	})
+	defer stderr.Close()
+
+	args := []string{"-loglevel", "error"}
+	args = append(args, inputArgs...)
+	args = append(args,
+		"-frames:v", "1",
+		"-codec:v", "mjpeg",
+		"-q:v", "3",
+		"-f", "image2",
+		"pipe:1")
+
+	buffer := new(bytes.Buffer)
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = buffer
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to capture frame: %v", err)
+	}
+
+	if buffer.Len() == 0 {
+		return nil, fmt.Errorf("no frame captured")
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func serveSnapshot(w http.ResponseWriter, image []byte, cacheControl string) {
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	io.Copy(w, bytes.NewReader(image))
+}
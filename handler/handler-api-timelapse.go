@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/julienschmidt/httprouter"
+)
+
+// APIGetTimelapses is handler for GET /api/timelapse
+func (h *WebHandler) APIGetTimelapses(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	jobs := h.Timelapser.Jobs()
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&jobs)
+	checkError(err)
+}
+
+// APIInsertTimelapse is handler for POST /api/timelapse
+func (h *WebHandler) APIInsertTimelapse(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Decode request
+	var request TimelapseRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	checkError(err)
+
+	cam := h.getCamera(request.Camera)
+	from, err := parseRequestTime(request.From)
+	checkError(err)
+
+	to, err := parseRequestTime(request.To)
+	checkError(err)
+
+	if request.Interval == 0 {
+		request.Interval = 5
+	}
+
+	if request.FPS == 0 {
+		request.FPS = 30
+	}
+
+	// Queue the job
+	job, err := h.Timelapser.Add(cam, from, to, request.Interval, request.FPS)
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&job)
+	checkError(err)
+}
+
+// APIDeleteTimelapse is handler for DELETE /api/timelapse/:id
+func (h *WebHandler) APIDeleteTimelapse(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	err = h.Timelapser.Remove(ps.ByName("id"))
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// ServeTimelapseVideo is handler for GET /api/timelapse/:id/video
+// which serve the finished timelapse video as downloadable file.
+func (h *WebHandler) ServeTimelapseVideo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	job, videoPath, err := h.Timelapser.Job(ps.ByName("id"))
+	checkError(err)
+
+	if job.Status != camera.TimelapseDone {
+		panic(fmt.Errorf("timelapse %s is not finished yet", job.ID))
+	}
+
+	fileName := fmt.Sprintf("%s-%s-timelapse.mp4", job.CameraID, job.From.Format("2006-01-02-15.04"))
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	http.ServeFile(w, r, videoPath)
+}
+
+// parseRequestTime parses time from request, which formatted either
+// as RFC3339 or as local time from HTML datetime input.
+func parseRequestTime(str string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q", str)
+}
@@ -8,13 +8,14 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/RadhiFadlillah/cygnus/camera"
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	bolt "go.etcd.io/bbolt"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var rxSavedVideo = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(\d{2}:\d{2}:\d{2})\.mp4$`)
+var rxSavedVideo = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(\d{2}:\d{2}:\d{2})(-motion)?\.mp4$`)
 
 // APILogin is handler for POST /api/login
 func (h *WebHandler) APILogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -125,25 +126,59 @@ func (h *WebHandler) APIGetStorageFiles(w http.ResponseWriter, r *http.Request,
 	err := h.validateSession(r)
 	checkError(err)
 
-	// Read storage dir
-	dirItems, err := ioutil.ReadDir(h.StorageDir)
-	checkError(err)
+	// Get list of day for each camera
+	cameras := make(map[string]map[string][]VideoFile)
+	for _, cam := range h.Cameras {
+		dirItems, err := ioutil.ReadDir(cam.StorageDir)
+		checkError(err)
+
+		days := make(map[string][]VideoFile)
+		for _, item := range dirItems {
+			if !rxSavedVideo.MatchString(item.Name()) {
+				continue
+			}
+
+			parts := rxSavedVideo.FindStringSubmatch(item.Name())
+			day := parts[1]
+			time := parts[2] + parts[3]
 
-	// Get list of day
-	days := make(map[string][]string)
-	for _, item := range dirItems {
-		if !rxSavedVideo.MatchString(item.Name()) {
-			continue
+			days[day] = append(days[day], VideoFile{
+				Time:      time,
+				Thumbnail: fmt.Sprintf("/video/%s/%s-%s/thumbnail", cam.ID, day, time),
+			})
 		}
 
-		parts := rxSavedVideo.FindStringSubmatch(item.Name())
-		day := parts[1]
-		time := parts[2]
+		cameras[cam.ID] = days
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&cameras)
+	checkError(err)
+}
+
+// APIGetMotionEvents is handler for GET /api/motion/:camera.
+// Query "day" (formatted as YYYY-MM-DD) is used to select the day
+// of motion events. If it's not specified, returns the last 24 hours.
+func (h *WebHandler) APIGetMotionEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Get time range
+	cam := h.getCamera(ps.ByName("camera"))
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
 
-		days[day] = append(days[day], time)
+	if day := r.URL.Query().Get("day"); day != "" {
+		from, err = time.ParseInLocation("2006-01-02", day, time.Local)
+		checkError(err)
+		to = from.AddDate(0, 0, 1)
 	}
 
+	// Get motion events from database
+	events := camera.MotionEvents(h.DB, cam.ID, from, to)
+
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(&days)
+	err = json.NewEncoder(w).Encode(&events)
 	checkError(err)
 }
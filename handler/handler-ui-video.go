@@ -4,61 +4,240 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"os/exec"
 	fp "path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/RadhiFadlillah/cygnus/logs"
 	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
 )
 
-// ServeLivePlaylist is handler for GET /live/playlist
-// which serve HLS playlist for live stream
+const (
+	mjpegBoundary = "cygnusframe"
+	maxSDPSize    = 64 * 1024
+)
+
+// ServeLivePlaylist is handler for GET /live/:camera/playlist
+// which serve HLS master playlist that lists variants of live stream,
+// so player can switch quality following the connection speed.
 func (h *WebHandler) ServeLivePlaylist(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Make sure session still valid
-	err := h.validateSession(r)
+	err := h.validateStreamSession(r)
 	checkError(err)
 
-	playlistPath := fp.Join(h.HlsSegmentsDir, "playlist.m3u8")
+	cam := h.getCamera(ps.ByName("camera"))
+
+	// Create master playlist
+	buffer := new(bytes.Buffer)
+	fmt.Fprintln(buffer, "#EXTM3U")
+	fmt.Fprintln(buffer, "#EXT-X-VERSION:3")
+	for _, variant := range cam.LiveVariants() {
+		fmt.Fprintf(buffer, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n",
+			variant.Bandwidth, variant.Width, variant.Height)
+		fmt.Fprintf(buffer, "/live/%s/%s/playlist\n", cam.ID, variant.Name)
+	}
 
+	// Serve playlist
 	w.Header().Set("Content-Type", "application/x-mpegURL")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	http.ServeFile(w, r, playlistPath)
+	_, err = buffer.WriteTo(w)
+	checkError(err)
 }
 
-// ServeLiveSegment is handler for GET /live/stream/:index
+// ServeLiveMainPlaylist is handler for GET /live/:camera/main/playlist
+// which serve HLS playlist for live stream in its original quality
+func (h *WebHandler) ServeLiveMainPlaylist(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	serveLiveFile(w, r, fp.Join(cam.HlsSegmentsDir, "playlist.m3u8"))
+}
+
+// ServeLiveSegment is handler for GET /live/:camera/stream/:index
 // which serve the HLS segment for live stream
 func (h *WebHandler) ServeLiveSegment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	serveLiveFile(w, r, fp.Join(cam.HlsSegmentsDir, ps.ByName("index")))
+}
+
+// ServeLiveLowPlaylist is handler for GET /live/:camera/low/playlist
+// which serve HLS playlist for live stream in low resolution
+func (h *WebHandler) ServeLiveLowPlaylist(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	serveLiveFile(w, r, fp.Join(cam.LowSegmentsDir(), "playlist.m3u8"))
+}
+
+// ServeLiveLowSegment is handler for GET /live/:camera/low/stream/:index
+// which serve the HLS segment for live stream in low resolution
+func (h *WebHandler) ServeLiveLowSegment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	serveLiveFile(w, r, fp.Join(cam.LowSegmentsDir(), ps.ByName("index")))
+}
+
+// ServeLiveLLPlaylist is handler for GET /live/:camera/ll/playlist
+// which serve the playlist of low latency live stream. Following LL-HLS,
+// query _HLS_msn and _HLS_part make the request blocked until the
+// specified segment or partial segment is ready.
+func (h *WebHandler) ServeLiveLLPlaylist(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+
+	// Parse blocking request
+	msn, part := -1, -1
+	query := r.URL.Query()
+	if strMSN := query.Get("_HLS_msn"); strMSN != "" {
+		msn, err = strconv.Atoi(strMSN)
+		if err != nil || msn < 0 {
+			panic(fmt.Errorf("_HLS_msn must be a non negative number"))
+		}
+	}
+
+	if strPart := query.Get("_HLS_part"); strPart != "" {
+		part, err = strconv.Atoi(strPart)
+		if err != nil || part < 0 {
+			panic(fmt.Errorf("_HLS_part must be a non negative number"))
+		}
+	}
+
+	playlist, err := cam.LowLatencyPlaylist(r.Context(), msn, part)
+	checkError(err)
+
+	// Serve playlist
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Write(playlist)
+}
+
+// ServeLiveLLInit is handler for GET /live/:camera/ll/init.mp4
+// which serve the initialization segment of low latency live stream
+func (h *WebHandler) ServeLiveLLInit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	initSegment, err := cam.LowLatencyInit(r.Context())
+	checkError(err)
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Write(initSegment)
+}
+
+// ServeLiveLLSegment is handler for GET /live/:camera/ll/stream/:name
+// which serve the segment or partial segment of low latency live stream
+func (h *WebHandler) ServeLiveLLSegment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	segment, err := cam.LowLatencySegment(r.Context(), ps.ByName("name"))
+	checkError(err)
+
+	// Part is only valid while it's listed in the current playlist
+	w.Header().Set("Content-Type", "video/iso.segment")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(segment)
+}
+
+// ServeLiveWebRTC is handler for POST /live/:camera/whep which receives
+// SDP offer from WHEP client, then answers it with WebRTC session that
+// sends the live stream. The session is located at the Location header.
+func (h *WebHandler) ServeLiveWebRTC(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Make sure session still valid
 	err := h.validateSession(r)
 	checkError(err)
 
-	segmentIndex := ps.ByName("index")
-	segmentPath := fp.Join(h.HlsSegmentsDir, segmentIndex)
+	cam := h.getCamera(ps.ByName("camera"))
+
+	// Read the offer
+	offer, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSDPSize))
+	checkError(err)
+
+	if len(offer) == 0 {
+		panic(fmt.Errorf("SDP offer is empty"))
+	}
+
+	// Create the session
+	sessionID, answer, err := cam.StartWebRTC(string(offer))
+	checkError(err)
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", fmt.Sprintf("/live/%s/whep/%s", cam.ID, sessionID))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, answer)
+}
+
+// ServeLiveWebRTCStop is handler for DELETE /live/:camera/whep/:session
+// which stops the WebRTC session
+func (h *WebHandler) ServeLiveWebRTCStop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	err = cam.StopWebRTC(ps.ByName("session"))
+	checkError(err)
+
+	fmt.Fprint(w, 1)
+}
+
+// serveLiveFile serves playlist or segment of live stream, which always changing.
+func serveLiveFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	if fp.Ext(filePath) == ".m3u8" {
+		w.Header().Set("Content-Type", "application/x-mpegURL")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Content-Type", "video/MP2T")
+	}
 
-	w.Header().Set("Content-Type", "video/MP2T")
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	http.ServeFile(w, r, segmentPath)
+	http.ServeFile(w, r, filePath)
 }
 
-// ServeVideoFile is handler for GET /video/:name.
+// ServeVideoFile is handler for GET /video/:camera/:name.
 // It serves the video file as it without any modifications.
 func (h *WebHandler) ServeVideoFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Make sure session still valid
 	err := h.validateSession(r)
 	checkError(err)
 
+	cam := h.getCamera(ps.ByName("camera"))
 	videoName := ps.ByName("name")
-	videoPath := fp.Join(h.StorageDir, videoName+".mp4")
+	videoPath := fp.Join(cam.StorageDir, videoName+".mp4")
 
 	w.Header().Set("Content-Type", "video/mp4")
 	w.Header().Set("Cache-Control", "max-age=3600")
 	http.ServeFile(w, r, videoPath)
 }
 
-// ServeVideoPlaylist is handler for GET /video/:name/playlist
+// ServeVideoPlaylist is handler for GET /video/:camera/:name/playlist
 // which serve the HLS playlist for specified video
 func (h *WebHandler) ServeVideoPlaylist(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Make sure session still valid
@@ -66,27 +245,12 @@ func (h *WebHandler) ServeVideoPlaylist(w http.ResponseWriter, r *http.Request,
 	checkError(err)
 
 	// Get path to video file
+	cam := h.getCamera(ps.ByName("camera"))
 	videoName := ps.ByName("name")
-	videoPath := fp.Join(h.StorageDir, videoName+".mp4")
-
-	// Run ffprobe
-	cmd := exec.Command("ffprobe",
-		"-loglevel", "fatal",
-		"-print_format", "compact",
-		"-show_entries", "format=duration",
-		videoPath)
-
-	output, err := cmd.CombinedOutput()
-	checkError(err)
-
-	// Parse video duration
-	output = bytes.TrimSpace(output)
-	outputParts := strings.SplitN(string(output), "=", 2)
-	if len(outputParts) != 2 || outputParts[0] != "format|duration" {
-		panic(fmt.Errorf("unable to parse video duration"))
-	}
+	videoPath := fp.Join(cam.StorageDir, videoName+".mp4")
 
-	vidDuration, err := strconv.ParseFloat(outputParts[1], 64)
+	// Get video duration
+	vidDuration, err := camera.VideoDuration(videoPath)
 	checkError(err)
 
 	// Create playlist file
@@ -106,7 +270,7 @@ func (h *WebHandler) ServeVideoPlaylist(w http.ResponseWriter, r *http.Request,
 		}
 
 		fmt.Fprintf(buffer, "#EXTINF:%f,\n", segmentLength)
-		fmt.Fprintf(buffer, "/video/%s/stream/%d.ts\n", videoName, segmentIndex)
+		fmt.Fprintf(buffer, "/video/%s/%s/stream/%d.ts\n", cam.ID, videoName, segmentIndex)
 		segmentIndex++
 	}
 
@@ -118,7 +282,7 @@ func (h *WebHandler) ServeVideoPlaylist(w http.ResponseWriter, r *http.Request,
 	io.Copy(w, buffer)
 }
 
-// ServeVideoSegment is handler for GET /video/:name/stream/:index
+// ServeVideoSegment is handler for GET /video/:camera/:name/stream/:index
 // which serve the HLS segment for specified video
 func (h *WebHandler) ServeVideoSegment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Make sure session still valid
@@ -126,8 +290,9 @@ func (h *WebHandler) ServeVideoSegment(w http.ResponseWriter, r *http.Request, p
 	checkError(err)
 
 	// Get path to video file
+	cam := h.getCamera(ps.ByName("camera"))
 	videoName := ps.ByName("name")
-	videoPath := fp.Join(h.StorageDir, videoName+".mp4")
+	videoPath := fp.Join(cam.StorageDir, videoName+".mp4")
 
 	// Calculate start time
 	strIndex := ps.ByName("index")
@@ -142,13 +307,19 @@ func (h *WebHandler) ServeVideoSegment(w http.ResponseWriter, r *http.Request, p
 
 	// Cut video using ffmpeg
 	buffer := new(bytes.Buffer)
+	stderr := logs.NewLineWriter(logrus.Fields{
+		"camera":    cam.ID,
+		"component": "vod-segment",
+	})
+	defer stderr.Close()
+
 	cmd := exec.Command("ffmpeg",
-		"-loglevel", "fatal",
+		"-loglevel", "error",
 		"-ss", fmt.Sprintf("%f", startTime),
 		"-i", videoPath,
 		"-t", "30.0",
 		"-codec", "copy",
-		"-bsf", "h264_mp4toannexb",
+		"-bsf:v", "h264_mp4toannexb",
 		"-map", "0",
 		"-f", "segment",
 		"-segment_time", "30.0",
@@ -157,6 +328,7 @@ func (h *WebHandler) ServeVideoSegment(w http.ResponseWriter, r *http.Request, p
 		"-initial_offset", fmt.Sprintf("%f", startTime),
 		"pipe:out%d.ts")
 	cmd.Stdout = buffer
+	cmd.Stderr = stderr
 
 	err = cmd.Run()
 	checkError(err)
@@ -167,3 +339,157 @@ func (h *WebHandler) ServeVideoSegment(w http.ResponseWriter, r *http.Request, p
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	io.Copy(w, buffer)
 }
+
+// ServeVideoSnapshot is handler for GET /video/:camera/:name/snapshot
+// which serve JPEG image of the specified video. Query "t" is the
+// position of the frame in seconds, default to the first frame.
+func (h *WebHandler) ServeVideoSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Get path to video file
+	cam := h.getCamera(ps.ByName("camera"))
+	videoName := ps.ByName("name")
+	videoPath := fp.Join(cam.StorageDir, videoName+".mp4")
+
+	if _, err = os.Stat(videoPath); err != nil {
+		panic(fmt.Errorf("video %s is not exist", videoName))
+	}
+
+	// Parse frame position
+	position := float64(0)
+	if strPosition := r.URL.Query().Get("t"); strPosition != "" {
+		position, err = strconv.ParseFloat(strPosition, 64)
+		checkError(err)
+
+		if position < 0 {
+			position = 0
+		}
+	}
+
+	// Capture the frame
+	image, err := captureFrame(cam.ID, nil, "-ss", fmt.Sprintf("%f", position), "-i", videoPath)
+	checkError(err)
+
+	serveSnapshot(w, image, "max-age=3600")
+}
+
+// ServeLiveMJPEG is handler for GET /live/:camera/mjpeg
+// which serve the live stream as MJPEG, for clients that can't play HLS.
+func (h *WebHandler) ServeLiveMJPEG(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateStreamSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		panic(fmt.Errorf("streaming is not supported"))
+	}
+
+	// Subscribe to the MJPEG transcoder
+	chFrame, unsubscribe := cam.SubscribeMJPEG()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	flusher.Flush()
+
+	// Stream the frames until client disconnected
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame := <-chFrame:
+			fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame))
+			w.Write(frame)
+			if _, err := fmt.Fprint(w, "\r\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ServeVideoThumbnail is handler for GET /video/:camera/:name/thumbnail
+// which serve the thumbnail of specified video. If the thumbnail
+// is not generated yet, it will be generated first.
+func (h *WebHandler) ServeVideoThumbnail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Get path to thumbnail file
+	cam := h.getCamera(ps.ByName("camera"))
+	videoName := ps.ByName("name")
+	thumbnailPath := cam.ThumbnailPath(videoName)
+
+	if _, err = os.Stat(thumbnailPath); err != nil {
+		if _, err = os.Stat(fp.Join(cam.StorageDir, videoName+".mp4")); err != nil {
+			panic(fmt.Errorf("video %s is not exist", videoName))
+		}
+
+		err = cam.GenerateThumbnail(videoName)
+		checkError(err)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, thumbnailPath)
+}
+
+// ServeVideoThumbnailsVTT is handler for GET /video/:camera/:name/thumbnails.vtt
+// which serve WebVTT track of preview thumbnails for seeking the specified video.
+func (h *WebHandler) ServeVideoThumbnailsVTT(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	// Get the sprite sheet of video
+	cam := h.getCamera(ps.ByName("camera"))
+	videoName := ps.ByName("name")
+	sprite, err := cam.VideoSprite(videoName)
+	checkError(err)
+
+	// Create the WebVTT, each cue points to a tile in sprite
+	formatTime := func(seconds int) string {
+		return fmt.Sprintf("%02d:%02d:%02d.000", seconds/3600, seconds/60%60, seconds%60)
+	}
+
+	spriteURL := fmt.Sprintf("/video/%s/%s/sprite.jpg", cam.ID, videoName)
+	buffer := new(bytes.Buffer)
+	fmt.Fprintln(buffer, "WEBVTT")
+
+	for i := 0; i < sprite.Count; i++ {
+		x := (i % sprite.Columns) * sprite.TileWidth
+		y := (i / sprite.Columns) * sprite.TileHeight
+		start := i * sprite.Interval
+
+		fmt.Fprintln(buffer)
+		fmt.Fprintf(buffer, "%s --> %s\n", formatTime(start), formatTime(start+sprite.Interval))
+		fmt.Fprintf(buffer, "%s#xywh=%d,%d,%d,%d\n", spriteURL, x, y, sprite.TileWidth, sprite.TileHeight)
+	}
+
+	// Serve WebVTT
+	w.Header().Set("Content-Type", "text/vtt")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.Copy(w, buffer)
+}
+
+// ServeVideoSprite is handler for GET /video/:camera/:name/sprite.jpg
+// which serve the sprite sheet that used by the thumbnails track.
+func (h *WebHandler) ServeVideoSprite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	err := h.validateSession(r)
+	checkError(err)
+
+	cam := h.getCamera(ps.ByName("camera"))
+	sprite, err := cam.VideoSprite(ps.ByName("name"))
+	checkError(err)
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, sprite.Path)
+}
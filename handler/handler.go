@@ -7,7 +7,10 @@ import (
 	"net/http"
 	"os"
 	fp "path/filepath"
+	"strings"
 
+	"github.com/RadhiFadlillah/cygnus/camera"
+	"github.com/RadhiFadlillah/cygnus/logs"
 	cch "github.com/patrickmn/go-cache"
 	bolt "go.etcd.io/bbolt"
 )
@@ -16,12 +19,17 @@ var developmentMode = false
 
 // WebHandler is handler for serving the web interface.
 type WebHandler struct {
-	DB             *bolt.DB
-	UserCache      *cch.Cache
-	SessionCache   *cch.Cache
-	StorageDir     string
-	HlsSegmentsDir string
-	ChRestart      chan bool
+	DB           *bolt.DB
+	UserCache    *cch.Cache
+	SessionCache *cch.Cache
+	Cameras      []*camera.Camera
+	SourceNames  []string
+	NewSource    func(cameraID, sourceName string) (camera.Source, error)
+	Logs         *logs.Ring
+	Timelapser   *camera.Timelapser
+	Scheduler    *camera.Scheduler
+	ChRestart    chan bool
+	RTSPPort     int
 }
 
 // PrepareLoginCache prepares cache for future use
@@ -45,22 +53,67 @@ func (h *WebHandler) PrepareLoginCache() {
 	})
 }
 
+// validateSession makes sure the request has valid session. For client that can't use
+// cookie (e.g. Home Assistant or chat bots), the session ID returned by login API can
+// be sent as bearer token instead. A stale cookie doesn't hide the valid bearer token.
 func (h *WebHandler) validateSession(r *http.Request) error {
-	// Get session-id from cookie
-	sessionID, err := r.Cookie("session-id")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			return fmt.Errorf("session is not exist")
+	return h.checkSessions(sessionCookie(r), bearerToken(r))
+}
+
+// validateStreamSession is like validateSession, but the session ID can also be sent as
+// query "token", for client that can only open URL (e.g. <img> tag and media player).
+// It's only used by the read only live stream and snapshot endpoints, so for the other
+// endpoints the session ID never ends up in URL, proxy logs and Referer header.
+func (h *WebHandler) validateStreamSession(r *http.Request) error {
+	return h.checkSessions(sessionCookie(r), bearerToken(r), r.URL.Query().Get("token"))
+}
+
+// checkSessions returns nil if any of the session IDs is valid.
+func (h *WebHandler) checkSessions(sessionIDs ...string) error {
+	exist := false
+	for _, sessionID := range sessionIDs {
+		if sessionID == "" {
+			continue
 		}
-		return err
+
+		exist = true
+		if _, found := h.SessionCache.Get(sessionID); found {
+			return nil
+		}
+	}
+
+	if !exist {
+		return fmt.Errorf("session is not exist")
 	}
 
-	// Make sure session is not expired yet
-	if _, found := h.SessionCache.Get(sessionID.Value); !found {
-		return fmt.Errorf("session has been expired")
+	return fmt.Errorf("session has been expired")
+}
+
+func sessionCookie(r *http.Request) string {
+	if cookie, err := r.Cookie("session-id"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+// getCamera returns camera with specified ID.
+// It panics if the camera doesn't exist.
+func (h *WebHandler) getCamera(id string) *camera.Camera {
+	for _, cam := range h.Cameras {
+		if cam.ID == id {
+			return cam
+		}
 	}
 
-	return nil
+	panic(fmt.Errorf("camera %s is not exist", id))
 }
 
 func serveFile(w http.ResponseWriter, filePath string, cache bool) error {
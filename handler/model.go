@@ -1,5 +1,7 @@
 package handler
 
+import "github.com/RadhiFadlillah/cygnus/camera"
+
 // User is person that given access to camera
 type User struct {
 	Username string `json:"username"`
@@ -12,3 +14,61 @@ type LoginRequest struct {
 	Password string `json:"password"`
 	Remember int    `json:"remember"`
 }
+
+// CameraSetting is setting of a camera, along with its capabilities
+type CameraSetting struct {
+	ID           string              `json:"id"`
+	Source       string              `json:"source"`
+	Setting      map[string]string   `json:"setting"`
+	Capabilities camera.Capabilities `json:"capabilities"`
+	Status       camera.Status       `json:"status"`
+}
+
+// CameraInfo is the summary of a camera and its running status
+type CameraInfo struct {
+	ID         string        `json:"id"`
+	Source     string        `json:"source"`
+	Armed      bool          `json:"armed"`
+	LowLatency bool          `json:"lowLatency"`
+	Status     camera.Status `json:"status"`
+}
+
+// VideoFile is a recorded video in storage
+type VideoFile struct {
+	Time      string `json:"time"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// TimelapseRequest is request for building timelapse video
+type TimelapseRequest struct {
+	Camera   string `json:"camera"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Interval int    `json:"interval"`
+	FPS      int    `json:"fps"`
+}
+
+// NewCamera is request for registering new camera
+type NewCamera struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+}
+
+// CameraProfiles is the profiles of a camera, along with its schedule
+type CameraProfiles struct {
+	Active   string                 `json:"active"`
+	Profiles []camera.Profile       `json:"profiles"`
+	Schedule []camera.ScheduleEntry `json:"schedule"`
+}
+
+// CameraArming is the arming setting of a camera, along with its current state
+type CameraArming struct {
+	camera.Arming
+	Armed bool `json:"armed"`
+}
+
+// CameraRestreams is the restream targets of a camera, along with their status
+type CameraRestreams struct {
+	Targets  []camera.RestreamTarget `json:"targets"`
+	Statuses []camera.RestreamStatus `json:"statuses"`
+}
@@ -0,0 +1,106 @@
+package logs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry is a single log entry that kept in ring buffer.
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Ring is logrus hook that keeps the last log entries in memory,
+// and broadcast the new entries to its subscribers.
+type Ring struct {
+	mutex       sync.RWMutex
+	size        int
+	entries     []Entry
+	subscribers map[chan Entry]struct{}
+}
+
+// NewRing returns new ring buffer that keeps at most size entries.
+func NewRing(size int) *Ring {
+	return &Ring{
+		size:        size,
+		entries:     make([]Entry, 0, size),
+		subscribers: make(map[chan Entry]struct{}),
+	}
+}
+
+// Levels returns the log levels that captured by this hook.
+func (r *Ring) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire saves the log entry and sends it to all subscribers.
+func (r *Ring) Fire(e *logrus.Entry) error {
+	entry := Entry{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+	}
+
+	if len(e.Data) > 0 {
+		entry.Fields = make(map[string]interface{})
+		for key, val := range e.Data {
+			if err, isError := val.(error); isError {
+				val = err.Error()
+			}
+			entry.Fields[key] = val
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.entries) >= r.size {
+		copy(r.entries, r.entries[1:])
+		r.entries = r.entries[:len(r.entries)-1]
+	}
+	r.entries = append(r.entries, entry)
+
+	// Subscriber that can't keep up will miss the entry,
+	// since logging must never be blocked.
+	for chEntry := range r.subscribers {
+		select {
+		case chEntry <- entry:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Entries returns copy of log entries that currently kept.
+func (r *Ring) Entries() []Entry {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	entries := make([]Entry, len(r.entries))
+	copy(entries, r.entries)
+	return entries
+}
+
+// Subscribe returns channel that receives the new log entries,
+// and function to stop the subscription.
+func (r *Ring) Subscribe() (chan Entry, func()) {
+	chEntry := make(chan Entry, 100)
+
+	r.mutex.Lock()
+	r.subscribers[chEntry] = struct{}{}
+	r.mutex.Unlock()
+
+	unsubscribe := func() {
+		r.mutex.Lock()
+		delete(r.subscribers, chEntry)
+		r.mutex.Unlock()
+	}
+
+	return chEntry, unsubscribe
+}
@@ -0,0 +1,81 @@
+package logs
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LineWriter is writer that logs every line written to it. It's used
+// to capture the stderr of child processes into structured logs.
+type LineWriter struct {
+	mutex    sync.Mutex
+	logger   *logrus.Entry
+	buf      []byte
+	replacer *strings.Replacer
+}
+
+// NewLineWriter returns new LineWriter whose logs contain the specified fields.
+func NewLineWriter(fields logrus.Fields) *LineWriter {
+	return &LineWriter{
+		logger: logrus.WithFields(fields),
+	}
+}
+
+// Redact hides the secrets, e.g. password in URL, from the logged lines.
+func (lw *LineWriter) Redact(secrets ...string) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	var oldnew []string
+	for _, secret := range secrets {
+		if secret != "" {
+			oldnew = append(oldnew, secret, "xxxxx")
+		}
+	}
+
+	lw.replacer = strings.NewReplacer(oldnew...)
+}
+
+// Write logs every complete line in p. The incomplete line
+// is kept until the rest of it is written.
+func (lw *LineWriter) Write(p []byte) (int, error) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	lw.buf = append(lw.buf, p...)
+	for {
+		idx := bytes.IndexAny(lw.buf, "\r\n")
+		if idx < 0 {
+			break
+		}
+
+		lw.log(string(lw.buf[:idx]))
+		lw.buf = lw.buf[idx+1:]
+	}
+
+	return len(p), nil
+}
+
+// Close logs the remaining incomplete line.
+func (lw *LineWriter) Close() error {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	lw.log(string(lw.buf))
+	lw.buf = nil
+	return nil
+}
+
+func (lw *LineWriter) log(line string) {
+	line = strings.TrimSpace(line)
+	if lw.replacer != nil {
+		line = lw.replacer.Replace(line)
+	}
+
+	if line != "" {
+		lw.logger.Warnln(line)
+	}
+}
@@ -10,10 +10,12 @@ import (
 	"net/http"
 	"os"
 	fp "path/filepath"
+	"strings"
 	"time"
 
 	"github.com/RadhiFadlillah/cygnus/camera"
 	"github.com/RadhiFadlillah/cygnus/handler"
+	"github.com/RadhiFadlillah/cygnus/logs"
 	"github.com/julienschmidt/httprouter"
 	cch "github.com/patrickmn/go-cache"
 	"github.com/shirou/gopsutil/disk"
@@ -23,12 +25,23 @@ import (
 
 var (
 	portNumber     = 8080
+	rtspPort       = 8554
 	maxStorageSize = uint64(1024)
 
+	camSource = "testsrc"
+	camDevice = "/dev/video0"
 	camWidth  = 800
 	camHeight = 600
 	camFlip   = false
 
+	camLocation *camera.Location
+
+	v4l2FormatsFile = ""
+
+	cameraSources   = []string{"raspivid", "v4l2", "rtsp", "testsrc", "file"}
+	defaultCameraID = "cam1"
+	maxLogEntries   = 1000
+
 	dbPath      = "cygnus.db"
 	storageDir  = "temp/storage"
 	segmentsDir = "temp/segments"
@@ -51,6 +64,10 @@ func main() {
 		logrus.Fatalln("failed to create live segments dir:", err)
 	}
 
+	// Keep recent logs in memory, so it can be viewed from web interface
+	logRing := logs.NewRing(maxLogEntries)
+	logrus.AddHook(logRing)
+
 	// Open database
 	db, err := prepareDatabase()
 	if err != nil {
@@ -58,9 +75,6 @@ func main() {
 	}
 	defer db.Close()
 
-	// Clean old videos in background
-	go cleanStorage()
-
 	// Prepare channels
 	chError := make(chan error)
 	chRestart := make(chan bool)
@@ -69,8 +83,20 @@ func main() {
 		close(chRestart)
 	}()
 
+	// Clean old videos, build timelapse and switch profiles in background
+	timelapser := camera.NewTimelapser(db)
+	scheduler := &camera.Scheduler{
+		DB:        db,
+		Location:  camLocation,
+		ChRestart: chRestart,
+	}
+
+	go timelapser.Run()
+	go scheduler.Run()
+	go cleanStorage(timelapser)
+
 	// Start CCTV system
-	startCctvSystem(db, chError, chRestart)
+	startCctvSystem(db, logRing, timelapser, scheduler, chError, chRestart)
 }
 
 func prepareDatabase() (*bolt.DB, error) {
@@ -80,7 +106,21 @@ func prepareDatabase() (*bolt.DB, error) {
 	}
 
 	db.Update(func(tx *bolt.Tx) error {
-		bucket, _ := tx.CreateBucketIfNotExists([]byte("camera"))
+		cameras, _ := tx.CreateBucketIfNotExists([]byte("cameras"))
+		if key, _ := cameras.Cursor().First(); key != nil {
+			return nil
+		}
+
+		// If there are no camera yet, create the default one.
+		// If the old single camera setting exists, move it there.
+		bucket, _ := cameras.CreateBucketIfNotExists([]byte(defaultCameraID))
+		if oldBucket := tx.Bucket([]byte("camera")); oldBucket != nil {
+			oldBucket.ForEach(func(key, val []byte) error {
+				return bucket.Put(key, val)
+			})
+			tx.DeleteBucket([]byte("camera"))
+		}
+
 		if bucket.Stats().KeyN == 0 {
 			bucket.Put([]byte("fps"), []byte("30"))
 			bucket.Put([]byte("rotation"), []byte("0"))
@@ -90,29 +130,134 @@ func prepareDatabase() (*bolt.DB, error) {
 		return nil
 	})
 
+	// Videos that recorded before multi camera supported
+	// are moved to the default camera's storage.
+	dirItems, _ := ioutil.ReadDir(storageDir)
+	for _, item := range dirItems {
+		if item.IsDir() || fp.Ext(item.Name()) != ".mp4" {
+			continue
+		}
+
+		dstDir := fp.Join(storageDir, defaultCameraID)
+		os.MkdirAll(dstDir, os.ModePerm)
+		os.Rename(fp.Join(storageDir, item.Name()), fp.Join(dstDir, item.Name()))
+	}
+
 	return db, nil
 }
 
-func startCctvSystem(db *bolt.DB, chError chan error, chRestart chan bool) {
-	// Prepare camera
-	cam := &camera.RaspiCam{
-		DB: db,
+// newCameraSource creates camera source. The source that selected in
+// camera setting takes precedence over the one from config.
+func newCameraSource(db *bolt.DB, cameraID string) (camera.Source, error) {
+	sourceName := camSource
+	db.View(func(tx *bolt.Tx) error {
+		bucket := camera.Bucket(tx, cameraID)
+		if bucket == nil {
+			return nil
+		}
+
+		if val := bucket.Get([]byte("source")); len(val) > 0 {
+			sourceName = string(val)
+		}
+		return nil
+	})
+
+	return createCameraSource(db, cameraID, sourceName)
+}
+
+// createCameraSource creates camera source with specified name.
+func createCameraSource(db *bolt.DB, cameraID, sourceName string) (camera.Source, error) {
+	switch sourceName {
+	case "raspivid":
+		return &camera.RaspiCam{DB: db, CameraID: cameraID}, nil
+	case "v4l2":
+		return &camera.V4L2Cam{
+			DB:          db,
+			CameraID:    cameraID,
+			Device:      camDevice,
+			FormatsFile: v4l2FormatsFile,
+		}, nil
+	case "rtsp":
+		return camera.NewIPCam(db, cameraID), nil
+	case "testsrc":
+		return &camera.TestPattern{DB: db, CameraID: cameraID}, nil
+	case "file":
+		return &camera.FileReplay{DB: db, CameraID: cameraID}, nil
+	default:
+		return nil, fmt.Errorf("unknown camera source: %s", sourceName)
+	}
+}
+
+func startCctvSystem(db *bolt.DB, logRing *logs.Ring, timelapser *camera.Timelapser, scheduler *camera.Scheduler, chError chan error, chRestart chan bool) {
+	// Prepare cameras
+	var cameras []*camera.Camera
+	for _, cameraID := range camera.IDs(db) {
+		// Camera with invalid source is kept, so its setting can be
+		// fixed from web interface without affecting the other cameras
+		source, err := newCameraSource(db, cameraID)
+		if err != nil {
+			logrus.Errorf("camera %s: %v", cameraID, err)
+			source = &camera.UnavailableSource{DB: db, CameraID: cameraID, Err: err}
+		}
+
+		cam := &camera.Camera{
+			ID:     cameraID,
+			DB:     db,
+			Source: source,
+
+			GenerateHlsSegments: true,
+			HlsSegmentsDir:      fp.Join(segmentsDir, cameraID),
+
+			SaveToStorage: true,
+			StorageDir:    fp.Join(storageDir, cameraID),
+		}
+
+		for _, dir := range []string{cam.HlsSegmentsDir, cam.StorageDir} {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				logrus.Fatalf("camera %s: failed to create dir: %v", cameraID, err)
+			}
+		}
 
-		GenerateHlsSegments: true,
-		HlsSegmentsDir:      segmentsDir,
+		cameras = append(cameras, cam)
+	}
+
+	// Re-publish camera streams through RTSP server. It's optional,
+	// so if it can't be started the CCTV keeps running without it.
+	var rtspServer *camera.RTSPServer
+	if rtspPort > 0 {
+		rtspServer = &camera.RTSPServer{
+			DB:      db,
+			Address: fmt.Sprintf(":%d", rtspPort),
+			Cameras: cameras,
+		}
 
-		SaveToStorage: true,
-		StorageDir:    storageDir,
+		if err := rtspServer.Start(); err != nil {
+			logrus.Warnln("failed to start rtsp server:", err)
+			rtspServer = nil
+		}
+	}
+
+	// Prepare web handler. The source is created when setting is changed
+	// to validate the setting, so it must be created in the same way.
+	newSource := func(cameraID, sourceName string) (camera.Source, error) {
+		return createCameraSource(db, cameraID, sourceName)
 	}
 
-	// Prepare web handler
 	hdl := handler.WebHandler{
-		DB:             db,
-		StorageDir:     storageDir,
-		HlsSegmentsDir: segmentsDir,
-		UserCache:      cch.New(time.Hour, 10*time.Minute),
-		SessionCache:   cch.New(time.Hour, 10*time.Minute),
-		ChRestart:      chRestart,
+		DB:           db,
+		Cameras:      cameras,
+		SourceNames:  cameraSources,
+		NewSource:    newSource,
+		Logs:         logRing,
+		Timelapser:   timelapser,
+		Scheduler:    scheduler,
+		UserCache:    cch.New(time.Hour, 10*time.Minute),
+		SessionCache: cch.New(time.Hour, 10*time.Minute),
+		ChRestart:    chRestart,
+	}
+
+	if rtspServer != nil {
+		hdl.RTSPPort = rtspPort
 	}
 
 	hdl.PrepareLoginCache()
@@ -127,23 +272,62 @@ func startCctvSystem(db *bolt.DB, chError chan error, chRestart chan bool) {
 
 	router.GET("/", hdl.ServeIndexPage)
 	router.GET("/login", hdl.ServeLoginPage)
-	router.GET("/live/playlist", hdl.ServeLivePlaylist)
-	router.GET("/live/stream/:index", hdl.ServeLiveSegment)
-	router.GET("/video/:name", hdl.ServeVideoFile)
-	router.GET("/video/:name/playlist", hdl.ServeVideoPlaylist)
-	router.GET("/video/:name/stream/:index", hdl.ServeVideoSegment)
+	router.GET("/live/:camera/playlist", hdl.ServeLivePlaylist)
+	router.GET("/live/:camera/main/playlist", hdl.ServeLiveMainPlaylist)
+	router.GET("/live/:camera/stream/:index", hdl.ServeLiveSegment)
+	router.GET("/live/:camera/low/playlist", hdl.ServeLiveLowPlaylist)
+	router.GET("/live/:camera/low/stream/:index", hdl.ServeLiveLowSegment)
+	router.GET("/live/:camera/ll/playlist", hdl.ServeLiveLLPlaylist)
+	router.GET("/live/:camera/ll/init.mp4", hdl.ServeLiveLLInit)
+	router.GET("/live/:camera/ll/stream/:name", hdl.ServeLiveLLSegment)
+	router.GET("/live/:camera/mjpeg", hdl.ServeLiveMJPEG)
+	router.POST("/live/:camera/whep", hdl.ServeLiveWebRTC)
+	router.DELETE("/live/:camera/whep/:session", hdl.ServeLiveWebRTCStop)
+	router.GET("/video/:camera/:name", hdl.ServeVideoFile)
+	router.GET("/video/:camera/:name/playlist", hdl.ServeVideoPlaylist)
+	router.GET("/video/:camera/:name/stream/:index", hdl.ServeVideoSegment)
+	router.GET("/video/:camera/:name/snapshot", hdl.ServeVideoSnapshot)
+	router.GET("/video/:camera/:name/thumbnail", hdl.ServeVideoThumbnail)
+	router.GET("/video/:camera/:name/thumbnails.vtt", hdl.ServeVideoThumbnailsVTT)
+	router.GET("/video/:camera/:name/sprite.jpg", hdl.ServeVideoSprite)
 
 	router.POST("/api/login", hdl.APILogin)
 	router.POST("/api/logout", hdl.APILogout)
 	router.GET("/api/storage", hdl.APIGetStorageFiles)
+	router.GET("/api/motion/:camera", hdl.APIGetMotionEvents)
+	router.GET("/api/snapshot", hdl.APIGetSnapshot)
+
+	router.GET("/api/timelapse", hdl.APIGetTimelapses)
+	router.POST("/api/timelapse", hdl.APIInsertTimelapse)
+	router.DELETE("/api/timelapse/:id", hdl.APIDeleteTimelapse)
+	router.GET("/api/timelapse/:id/video", hdl.ServeTimelapseVideo)
 
 	router.GET("/api/user", hdl.APIGetUsers)
 	router.POST("/api/user", hdl.APIInsertUser)
 	router.DELETE("/api/user/:username", hdl.APIDeleteUser)
 
+	router.GET("/api/camera", hdl.APIGetCameras)
+	router.POST("/api/camera", hdl.APIInsertCamera)
+	router.DELETE("/api/camera/:id", hdl.APIDeleteCamera)
+
+	router.GET("/api/profile/:camera", hdl.APIGetProfiles)
+	router.POST("/api/profile/:camera", hdl.APISaveProfile)
+	router.DELETE("/api/profile/:camera/:name", hdl.APIDeleteProfile)
+	router.POST("/api/profile/:camera/:name/activate", hdl.APIActivateProfile)
+	router.POST("/api/schedule/:camera", hdl.APISaveSchedule)
+	router.GET("/api/arming/:camera", hdl.APIGetArming)
+	router.POST("/api/arming/:camera", hdl.APISaveArming)
+	router.GET("/api/mask/:camera", hdl.APIGetMasks)
+	router.POST("/api/mask/:camera", hdl.APISaveMasks)
+	router.GET("/api/restream/:camera", hdl.APIGetRestreams)
+	router.POST("/api/restream/:camera", hdl.APISaveRestreams)
+
+	router.GET("/api/logs", hdl.APIGetLogs)
+	router.GET("/api/events", hdl.APIGetEvents)
+
 	router.GET("/api/setting", hdl.APIGetSetting)
-	router.GET("/api/setting/camera", hdl.APIGetCameraSetting)
-	router.POST("/api/setting/camera", hdl.APISaveCameraSetting)
+	router.GET("/api/setting/camera/:id", hdl.APIGetCameraSetting)
+	router.POST("/api/setting/camera/:id", hdl.APISaveCameraSetting)
 	router.POST("/api/setting/reboot", hdl.APIRebootCamera)
 
 	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, arg interface{}) {
@@ -157,13 +341,15 @@ func startCctvSystem(db *bolt.DB, chError chan error, chRestart chan bool) {
 		Handler: router,
 	}
 
-	// Capture camera stream in background thread
-	go func() {
-		err := cam.Start()
-		if err != nil {
-			chError <- fmt.Errorf("camera error: %v", err)
-		}
-	}()
+	// Capture camera streams in background thread
+	for _, cam := range cameras {
+		go func(cam *camera.Camera) {
+			err := cam.Start()
+			if err != nil {
+				chError <- fmt.Errorf("camera %s error: %v", cam.ID, err)
+			}
+		}(cam)
+	}
 
 	// Serve web app in background thread
 	go func() {
@@ -181,14 +367,29 @@ func startCctvSystem(db *bolt.DB, chError chan error, chRestart chan bool) {
 	case <-chRestart:
 		logrus.Println("restart request received")
 
-		cam.Stop()
-		if err := server.Shutdown(context.Background()); err != nil {
-			logrus.Fatalln("failed to shutdown server:", err)
+		for _, cam := range cameras {
+			cam.Stop()
+		}
+
+		if rtspServer != nil {
+			rtspServer.Close()
+			logrus.Println("rtsp server stopped")
 		}
+
+		// Streaming requests never finished by themselves,
+		// so after a while just close the remaining connections.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := server.Shutdown(ctx); err != nil {
+			logrus.Warnln("failed to shutdown server gracefully:", err)
+			if err := server.Close(); err != nil {
+				logrus.Fatalln("failed to close server:", err)
+			}
+		}
+		cancel()
 		logrus.Println("web server stopped")
 
 		time.Sleep(3 * time.Second)
-		startCctvSystem(db, chError, chRestart)
+		startCctvSystem(db, logRing, timelapser, scheduler, chError, chRestart)
 	}
 }
 
@@ -196,9 +397,12 @@ func startCctvSystem(db *bolt.DB, chError chan error, chRestart chan bool) {
 // - there are too many vids, which combined size > maxStorageSize
 // - free space is less than 500MB
 //
+// The timelapse videos are removed as well, compared with the
+// recordings by the time they are created.
+//
 // Unlike startCamera and serveWebView, it's fine if removal failed.
 // So, if error happened, we just add warning log.
-func cleanStorage() {
+func cleanStorage(timelapser *camera.Timelapser) {
 	logWarn := func(a ...interface{}) {
 		logrus.Warnln(a...)
 	}
@@ -215,29 +419,60 @@ func cleanStorage() {
 		// remove old recorded video.
 		mbFree := float64(stat.Free) / 1000 / 1000
 		if (maxStorageSize > 0 && stat.Used > maxStorageSize) || mbFree < 500 {
-			dirItems, err := ioutil.ReadDir(storageDir)
+			// Each camera has its own storage dir, so look for
+			// the oldest video from all of them.
+			videos, err := fp.Glob(fp.Join(storageDir, "*", "*.mp4"))
 			if err != nil {
 				logWarn("clean storage error: get items failed:", err)
 				continue
 			}
 
 			oldestVideo := ""
-			for _, item := range dirItems {
-				if !item.IsDir() && fp.Ext(item.Name()) == ".mp4" {
-					oldestVideo = item.Name()
-					break
+			for _, video := range videos {
+				if oldestVideo == "" || fp.Base(video) < fp.Base(oldestVideo) {
+					oldestVideo = video
 				}
 			}
 
-			err = os.Remove(fp.Join(storageDir, oldestVideo))
+			oldestTimelapse, hasTimelapse := timelapser.Oldest()
+			if hasTimelapse && (oldestVideo == "" || oldestTimelapse.Created.Before(recordingTime(oldestVideo))) {
+				err = timelapser.Remove(oldestTimelapse.ID)
+				if err != nil {
+					logWarn("clean storage error: remove timelapse failed:", err)
+				} else {
+					logrus.Printf("free space %.0f MB, removing old timelapse: %s", mbFree, oldestTimelapse.ID)
+				}
+
+				time.Sleep(time.Minute)
+				continue
+			}
+
+			if oldestVideo == "" {
+				logWarn("clean storage error: no video to remove")
+				time.Sleep(time.Minute)
+				continue
+			}
+
+			err = os.Remove(oldestVideo)
 			if err != nil {
 				logWarn("clean storage error: remove failed:", err)
 				continue
 			}
+			os.Remove(camera.VideoThumbnailPath(oldestVideo))
+			os.Remove(camera.VideoSpritePath(oldestVideo))
 
+			oldestVideo, _ = fp.Rel(storageDir, oldestVideo)
 			logrus.Printf("free space %.0f MB, removing old video: %s", mbFree, oldestVideo)
 		}
 
 		time.Sleep(time.Minute)
 	}
 }
+
+// recordingTime returns the start time of recording, which used as its name.
+func recordingTime(videoPath string) time.Time {
+	name := strings.TrimSuffix(fp.Base(videoPath), "-motion.mp4")
+	name = strings.TrimSuffix(name, ".mp4")
+	start, _ := time.ParseInLocation("2006-01-02-15:04:05", name, time.Local)
+	return start
+}
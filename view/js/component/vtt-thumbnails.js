@@ -0,0 +1,92 @@
+// Preview thumbnails on the seek bar of video.js player. The thumbnails are described
+// by WebVTT track, where each cue points to a tile of sprite image using
+// "#xywh=x,y,w,h" fragment.
+
+function parseTime(str) {
+    return str.split(":")
+        .map(part => parseFloat(part))
+        .reduce((total, part) => total * 60 + part, 0);
+}
+
+function parseVTT(text) {
+    var cues = [];
+
+    text.split(/\r?\n\r?\n/).forEach(block => {
+        var lines = block.trim().split(/\r?\n/),
+            idx = lines.findIndex(line => line.includes("-->"));
+        if (idx < 0 || idx + 1 >= lines.length) return;
+
+        var times = lines[idx].split("-->").map(str => parseTime(str.trim())),
+            match = lines[idx + 1].trim().match(/^(.*)#xywh=(\d+),(\d+),(\d+),(\d+)$/);
+        if (match == null) return;
+
+        cues.push({
+            start: times[0],
+            end: times[1],
+            url: match[1],
+            x: parseInt(match[2], 10),
+            y: parseInt(match[3], 10),
+            w: parseInt(match[4], 10),
+            h: parseInt(match[5], 10),
+        });
+    });
+
+    return cues;
+}
+
+export default function vttThumbnails(player) {
+    var cues = [],
+        progressControl = player.controlBar.progressControl.el(),
+        seekBar = player.controlBar.progressControl.seekBar.el(),
+        preview = document.createElement("div");
+
+    preview.className = "vjs-vtt-thumbnail";
+    progressControl.appendChild(preview);
+
+    progressControl.addEventListener("mousemove", e => {
+        var rect = seekBar.getBoundingClientRect(),
+            position = Math.min(Math.max(e.clientX - rect.left, 0), rect.width),
+            time = position / rect.width * player.duration(),
+            cue = cues.find(item => time >= item.start && time < item.end);
+
+        if (cue == null) {
+            preview.style.display = "none";
+            return;
+        }
+
+        var left = position + seekBar.offsetLeft - cue.w / 2;
+        left = Math.min(Math.max(left, 0), progressControl.clientWidth - cue.w);
+
+        preview.style.display = "block";
+        preview.style.left = `${left}px`;
+        preview.style.width = `${cue.w}px`;
+        preview.style.height = `${cue.h}px`;
+        preview.style.backgroundImage = `url("${cue.url}")`;
+        preview.style.backgroundPosition = `-${cue.x}px -${cue.y}px`;
+    });
+
+    progressControl.addEventListener("mouseleave", () => {
+        preview.style.display = "none";
+    });
+
+    return {
+        load(url) {
+            cues = [];
+            fetch(url)
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.text();
+                })
+                .then(text => {
+                    cues = parseVTT(text);
+                })
+                .catch(() => {
+                    cues = [];
+                });
+        },
+        clear() {
+            cues = [];
+            preview.style.display = "none";
+        }
+    };
+}
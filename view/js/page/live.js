@@ -1,30 +1,167 @@
 var template = `
 <div id="page-live">
-    <h1 class="page-header">
-        Live Stream
-    </h1>
-    <div class="video-container">
-        <video id="live-viewer" class="cygnus-video video-js">
-            <source src="/live/playlist" type="application/x-mpegURL">
-            <p class="vjs-no-js">
-                To view this video please enable JavaScript, and consider upgrading to a web browser that
-                <a href="https://videojs.com/html5-video-support/" target="_blank">supports HTML5 video</a>
-            </p>
-        </video>
+    <div class="page-header">
+        <p>Live Stream</p>
+        <div class="camera-select">
+            <select v-model="player" title="Player">
+                <option value="hls">HLS</option>
+                <option value="webrtc">WebRTC</option>
+            </select>
+        </div>
     </div>
+    <div class="video-grid" :style="{gridTemplateColumns: gridColumns}">
+        <div class="video-container" v-for="camera in cameras" :key="camera.id">
+            <p class="video-title">{{camera.id}}</p>
+            <video v-if="player === 'webrtc'" :key="'webrtc-' + camera.id" :id="'webrtc-viewer-' + camera.id" class="live-viewer cygnus-video" controls autoplay muted playsinline></video>
+            <video v-else :key="'hls-' + camera.id" :id="'live-viewer-' + camera.id" class="live-viewer cygnus-video video-js">
+                <source :src="liveURL(camera)" type="application/x-mpegURL">
+                <p class="vjs-no-js">
+                    To view this video please enable JavaScript, and consider upgrading to a web browser that
+                    <a href="https://videojs.com/html5-video-support/" target="_blank">supports HTML5 video</a>
+                </p>
+            </video>
+        </div>
+    </div>
+    <cygnus-dialog v-bind="dialog"/>
 </div>`;
 
+import cygnusDialog from "../component/dialog.js";
+import basePage from "./base.js";
+
 export default {
     template: template,
+    mixins: [basePage],
+    components: {
+        cygnusDialog
+    },
+    data() {
+        return {
+            cameras: [],
+            player: localStorage.getItem("live-player") || "hls",
+        }
+    },
+    computed: {
+        gridColumns() {
+            var nColumns = Math.ceil(Math.sqrt(this.cameras.length)) || 1;
+            return `repeat(${nColumns}, minmax(0, 1fr))`;
+        }
+    },
+    watch: {
+        player(val, oldVal) {
+            localStorage.setItem("live-player", val);
+            this.stopPlayers(oldVal);
+            this.$nextTick(() => this.startPlayers());
+        }
+    },
+    methods: {
+        liveURL(camera) {
+            if (camera.lowLatency) return `/live/${camera.id}/ll/playlist`;
+            return `/live/${camera.id}/playlist`;
+        },
+        loadCameras() {
+            fetch("/api/camera")
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.cameras = json;
+                    this.$nextTick(() => this.startPlayers());
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        startPlayers() {
+            this.cameras.forEach(camera => {
+                if (this.player === "webrtc") {
+                    this.startWebRTC(camera);
+                    return;
+                }
+
+                videojs(`live-viewer-${camera.id}`, {
+                    controls: true,
+                    preload: "auto",
+                    autoplay: true,
+                    muted: true,
+                    html5: {
+                        hls: { overrideNative: true }
+                    },
+                });
+            });
+        },
+        stopPlayers(player) {
+            if (player === "webrtc") {
+                this.sessions.forEach(session => {
+                    session.pc.close();
+                    if (session.location) fetch(session.location, { method: "DELETE" });
+                });
+                this.sessions = [];
+                return;
+            }
+
+            this.cameras.forEach(camera => {
+                var hlsPlayer = videojs.getPlayer(`live-viewer-${camera.id}`);
+                if (hlsPlayer) hlsPlayer.dispose();
+            });
+        },
+        startWebRTC(camera) {
+            // The stream is requested using WHEP, with all ICE candidates in the offer
+            var video = document.getElementById(`webrtc-viewer-${camera.id}`),
+                pc = new RTCPeerConnection(),
+                session = { pc: pc, location: "" };
+
+            this.sessions.push(session);
+            pc.addTransceiver("video", { direction: "recvonly" });
+            pc.ontrack = event => {
+                video.srcObject = event.streams[0] || new MediaStream([event.track]);
+            };
+
+            pc.createOffer()
+                .then(offer => pc.setLocalDescription(offer))
+                .then(() => this.waitIceGathering(pc))
+                .then(() => fetch(`/live/${camera.id}/whep`, {
+                    method: "POST",
+                    headers: { "Content-Type": "application/sdp" },
+                    body: pc.localDescription.sdp,
+                }))
+                .then(response => {
+                    if (!response.ok) throw response;
+                    session.location = response.headers.get("Location");
+                    return response.text();
+                })
+                .then(answer => pc.setRemoteDescription({ type: "answer", sdp: answer }))
+                .catch(err => {
+                    if (!(err instanceof Response)) {
+                        this.showErrorDialog(err.message);
+                        return;
+                    }
+
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        waitIceGathering(pc) {
+            if (pc.iceGatheringState === "complete") return Promise.resolve();
+
+            return new Promise(resolve => {
+                pc.addEventListener("icegatheringstatechange", () => {
+                    if (pc.iceGatheringState === "complete") resolve();
+                });
+            });
+        }
+    },
+    created() {
+        // Peer connections are not reactive
+        this.sessions = [];
+    },
     mounted() {
-        videojs("live-viewer", {
-            controls: true,
-            preload: "auto",
-            autoplay: true,
-            muted: true,
-            html5: {
-                hls: { overrideNative: true }
-            },
-        });
+        this.loadCameras();
+    },
+    beforeDestroy() {
+        this.stopPlayers(this.player);
     }
-}
\ No newline at end of file
+}
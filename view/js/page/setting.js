@@ -11,9 +11,13 @@ var template = `
             <summary>Users</summary>
             <ul>
                 <li v-if="users.length === 0">No user registered</li>
-                <li v-for="(user, idx) in users">{{user}} <a title="Delete user" @click="showDialogDeleteUser(user, idx)">
-                    <i class="fa fas fa-fw fa-trash-alt"></i>
-                </a></li>
+                <li v-for="(user, idx) in users">{{user}}
+                    <span v-if="rtspPort > 0 && rtspReset.includes(user)"
+                        title="User is added before RTSP server available. To access RTSP stream, delete then add this user again to reset the password.">(no RTSP access)</span>
+                    <a title="Delete user" @click="showDialogDeleteUser(user, idx)">
+                        <i class="fa fas fa-fw fa-trash-alt"></i>
+                    </a>
+                </li>
             </ul>
             <div class="setting-group-footer">
                 <a @click="showDialogNewUser">Add new user</a>
@@ -22,35 +26,316 @@ var template = `
         <details open class="setting-group" id="setting-camera">
             <summary>Camera</summary>
             <div class="setting-group-form">
-                <label for="select-resolution">Resolution</label>
+                <label for="select-camera">Camera</label>
                 <div class="setting-group-select">
-                    <select id="select-resolution" v-model="camera.resolution">
-                        <option>640x480</option>
-                        <option>800x600</option>
-                        <option>960x720</option>
-                        <option>1024x768</option>
-                        <option>1280x960</option>
-                        <option>1296x972</option>
-                        <option>1440x1080</option>
+                    <select id="select-camera" v-model="selectedCameraID">
+                        <option v-for="item in cameras">{{item.id}}</option>
                     </select>
                 </div>
+                <label>Status</label>
+                <p class="setting-group-text" :title="status.lastError">{{statusText}}</p>
+                <label for="select-source">Source</label>
+                <div class="setting-group-select">
+                    <select id="select-source" v-model="camera.source">
+                        <option v-for="name in sources" :value="name">{{sourceLabels[name] || name}}</option>
+                    </select>
+                </div>
+                <template v-if="camera.source === 'file'">
+                    <label for="input-file">Video file</label>
+                    <input type="text" id="input-file" placeholder="/path/to/video.mp4" v-model="camera.file"/>
+                </template>
+                <template v-if="camera.source === 'rtsp'">
+                    <label for="input-url">Stream URL</label>
+                    <input type="text" id="input-url" placeholder="rtsp://192.168.1.10:554/stream" v-model="camera.url"/>
+                    <label for="input-username">Username</label>
+                    <input type="text" id="input-username" v-model="camera.username"/>
+                    <label for="input-password">Password</label>
+                    <input type="password" id="input-password" placeholder="Unchanged" v-model="camera.password"/>
+                </template>
+                <template v-if="formats.length > 0">
+                    <label for="select-format">Pixel format</label>
+                    <div class="setting-group-select">
+                        <select id="select-format" v-model="camera.format">
+                            <option v-for="format in formats" :value="format.name">{{format.description || format.name}}</option>
+                        </select>
+                    </div>
+                </template>
+                <template v-if="resolutions.length > 0">
+                    <label for="select-resolution">Resolution</label>
+                    <div class="setting-group-select">
+                        <select id="select-resolution" v-model="camera.resolution">
+                            <option v-for="resolution in resolutions">{{resolution}}</option>
+                        </select>
+                    </div>
+                </template>
                 <label for="input-fps">Framerate</label>
-                <input type="number" id="input-fps" v-model="camera.fps"/>
-                <label for="select-rotation">Rotation</label>
+                <div class="setting-group-select" v-if="frameRates.length > 0">
+                    <select id="input-fps" v-model="camera.fps">
+                        <option v-for="frameRate in frameRates">{{frameRate}}</option>
+                    </select>
+                </div>
+                <input v-else type="number" id="input-fps" min="1" :max="capabilities.maxFps" v-model="camera.fps"/>
+                <template v-if="rotations.length > 0">
+                    <label for="select-rotation">Rotation</label>
+                    <div class="setting-group-select">
+                        <select id="select-rotation" v-model="camera.rotation">
+                            <option v-for="rotation in rotations">{{rotation}}</option>
+                        </select>
+                    </div>
+                </template>
+                <template v-for="param in parameters">
+                    <label :for="'input-param-' + param.key" :title="param.description">{{param.label}}</label>
+                    <div class="setting-group-select" v-if="param.type === 'enum' || param.type === 'bool'">
+                        <select :id="'input-param-' + param.key" v-model="camera[param.key]">
+                            <template v-if="param.type === 'bool'">
+                                <option value="off">No</option>
+                                <option value="on">Yes</option>
+                            </template>
+                            <option v-else v-for="option in param.options">{{option}}</option>
+                        </select>
+                    </div>
+                    <input v-else-if="param.type === 'int'" type="number" :id="'input-param-' + param.key" :min="param.min" :max="param.max" :placeholder="param.default" v-model="camera[param.key]"/>
+                    <input v-else type="text" :id="'input-param-' + param.key" :placeholder="param.default || param.description" v-model="camera[param.key]"/>
+                </template>
+                <label for="select-recording">Recording</label>
+                <div class="setting-group-select">
+                    <select id="select-recording" v-model="camera.recording">
+                        <option value="continuous">Continuous</option>
+                        <option value="motion">Motion only</option>
+                        <option value="both">Continuous and motion</option>
+                    </select>
+                </div>
+                <template v-if="camera.recording !== 'continuous'">
+                    <label for="input-pre-roll">Pre-roll (seconds)</label>
+                    <input type="number" id="input-pre-roll" min="0" max="60" placeholder="10" v-model="camera.preRoll"/>
+                    <label for="input-post-roll">Post-roll (seconds)</label>
+                    <input type="number" id="input-post-roll" min="0" max="60" placeholder="10" v-model="camera.postRoll"/>
+                </template>
+                <label for="select-low-rendition">Low quality stream</label>
+                <div class="setting-group-select">
+                    <select id="select-low-rendition" v-model="camera.lowRendition">
+                        <option value="off">Disabled</option>
+                        <option value="on">Enabled</option>
+                    </select>
+                </div>
+                <template v-if="camera.lowRendition === 'on'">
+                    <label for="input-low-width">Low quality width</label>
+                    <input type="number" id="input-low-width" min="160" max="1920" placeholder="480" v-model="camera.lowWidth"/>
+                    <label for="input-low-bitrate">Low quality bitrate (kbps)</label>
+                    <input type="number" id="input-low-bitrate" min="100" max="5000" placeholder="300" v-model="camera.lowBitrate"/>
+                </template>
+                <label for="select-low-latency">Low latency stream</label>
+                <div class="setting-group-select">
+                    <select id="select-low-latency" v-model="camera.lowLatency">
+                        <option value="off">Disabled</option>
+                        <option value="on">Enabled (video only)</option>
+                    </select>
+                </div>
+                <template v-if="rtspPort > 0">
+                    <label for="input-rtsp-url">RTSP URL</label>
+                    <input type="text" id="input-rtsp-url" readonly :value="rtspURL"/>
+                </template>
+                <label for="input-mjpeg-fps">MJPEG framerate</label>
+                <input type="number" id="input-mjpeg-fps" min="1" max="15" placeholder="2" v-model="camera.mjpegFps"/>
+                <label for="input-audio-device">Audio device</label>
+                <input type="text" id="input-audio-device" placeholder="ALSA device, e.g. hw:1" v-model="camera.audioDevice"/>
+                <template v-if="camera.audioDevice">
+                    <label for="select-audio-sample-rate">Audio sample rate</label>
+                    <div class="setting-group-select">
+                        <select id="select-audio-sample-rate" v-model="camera.audioSampleRate">
+                            <option v-for="rate in [8000, 16000, 22050, 32000, 44100, 48000]">{{rate}}</option>
+                        </select>
+                    </div>
+                    <label for="input-audio-bitrate">Audio bitrate (kbps)</label>
+                    <input type="number" id="input-audio-bitrate" min="16" max="320" placeholder="64" v-model="camera.audioBitrate"/>
+                    <label for="select-audio-mute">Mute audio</label>
+                    <div class="setting-group-select">
+                        <select id="select-audio-mute" v-model="camera.audioMute">
+                            <option value="off">No</option>
+                            <option value="on">Yes</option>
+                        </select>
+                    </div>
+                </template>
+                <label for="select-motion">Motion detection</label>
                 <div class="setting-group-select">
-                    <select id="select-rotation" v-model="camera.rotation">
-                        <option>0</option>
-                        <option>90</option>
-                        <option>180</option>
-                        <option>270</option>
+                    <select id="select-motion" v-model="camera.motion">
+                        <option value="off">Disabled</option>
+                        <option value="on">Enabled</option>
                     </select>
                 </div>
+                <template v-if="camera.motion === 'on'">
+                    <label for="input-motion-threshold">Motion threshold (%)</label>
+                    <input type="number" id="input-motion-threshold" min="0.1" max="100" step="0.1" placeholder="2" v-model="camera.motionThreshold"/>
+                </template>
             </div>
             <div class="setting-group-footer">
+                <a @click="showDialogNewCamera">Add Camera</a>
+                <a @click="showDialogDeleteCamera">Delete Camera</a>
                 <a @click="showDialogSaveCamera">Save Setting</a>
                 <a @click="showDialogReboot">Reboot Camera</a>
             </div>
         </details>
+        <details class="setting-group" id="setting-profiles">
+            <summary>Profiles</summary>
+            <ul>
+                <li v-if="profiles.length === 0">No profile yet</li>
+                <li v-for="profile in profiles">
+                    {{profile.name}}{{profile.name === activeProfile ? " (active)" : ""}}
+                    <a title="Delete profile" @click="showDialogDeleteProfile(profile)">
+                        <i class="fa fas fa-fw fa-trash-alt"></i>
+                    </a>
+                    <a title="Activate profile" v-if="profile.name !== activeProfile" @click="showDialogActivateProfile(profile)">
+                        <i class="fa fas fa-fw fa-check"></i>
+                    </a>
+                </li>
+            </ul>
+            <ul>
+                <li v-if="schedule.length === 0">No schedule yet</li>
+                <li v-for="(entry, idx) in schedule">
+                    {{scheduleLabel(entry)}}
+                    <a title="Remove from schedule" @click="schedule.splice(idx, 1)">
+                        <i class="fa fas fa-fw fa-trash-alt"></i>
+                    </a>
+                </li>
+            </ul>
+            <div class="setting-group-form">
+                <label for="input-schedule-time">Time</label>
+                <input type="text" id="input-schedule-time" placeholder="HH:MM, sunrise or sunset" v-model="scheduleEntry.time"/>
+                <label for="input-schedule-offset">Offset (minutes)</label>
+                <input type="number" id="input-schedule-offset" v-model.number="scheduleEntry.offset"/>
+                <label for="select-schedule-profile">Profile</label>
+                <div class="setting-group-select">
+                    <select id="select-schedule-profile" v-model="scheduleEntry.profile">
+                        <option v-for="profile in profiles">{{profile.name}}</option>
+                    </select>
+                </div>
+            </div>
+            <div class="setting-group-footer">
+                <a @click="showDialogNewProfile">Save Camera as Profile</a>
+                <a @click="addScheduleEntry">Add to Schedule</a>
+                <a @click="saveSchedule">Save Schedule</a>
+            </div>
+        </details>
+        <details class="setting-group" id="setting-arming">
+            <summary>Recording Schedule</summary>
+            <div class="setting-group-form">
+                <label>Status</label>
+                <p class="setting-group-text">{{arming.armed ? "Armed, stream is saved to storage" : "Disarmed, only live view available"}}</p>
+                <label for="select-arming-mode">Mode</label>
+                <div class="setting-group-select">
+                    <select id="select-arming-mode" v-model="arming.mode">
+                        <option value="armed">Always armed</option>
+                        <option value="disarmed">Always disarmed</option>
+                        <option value="schedule">Follow schedule</option>
+                    </select>
+                </div>
+                <template v-if="arming.mode === 'schedule'">
+                    <template v-for="(day, idx) in weekdays">
+                        <label :for="'input-arming-' + idx">{{day}}</label>
+                        <input type="text" :id="'input-arming-' + idx" placeholder="08:00-17:00, 22:00-06:00" v-model="armingRanges[idx]"/>
+                    </template>
+                </template>
+            </div>
+            <div class="setting-group-footer">
+                <a @click="saveArming">Save Recording Schedule</a>
+            </div>
+        </details>
+        <details class="setting-group" id="setting-masks" @toggle="toggleMasks">
+            <summary>Privacy Masks</summary>
+            <div class="mask-editor" @mousedown="startMask" @mousemove="moveMask" @mouseup="endMask" @mouseleave="endMask">
+                <img :src="maskSnapshot" draggable="false"/>
+                <div class="mask" v-for="mask in maskRegions" :style="maskStyle(mask)"></div>
+            </div>
+            <ul>
+                <li v-if="masks.length === 0">No privacy mask, drag on the image to add one</li>
+                <li v-for="(mask, idx) in masks">
+                    {{maskLabel(mask)}}
+                    <a title="Remove mask" @click="masks.splice(idx, 1)">
+                        <i class="fa fas fa-fw fa-trash-alt"></i>
+                    </a>
+                </li>
+            </ul>
+            <div class="setting-group-footer">
+                <a @click="showDialogSaveMasks">Save Privacy Masks</a>
+            </div>
+        </details>
+        <details class="setting-group" id="setting-restreams" @toggle="toggleRestreams">
+            <summary>Restream</summary>
+            <ul>
+                <li v-if="restreams.length === 0">No restream target yet</li>
+                <li v-for="(target, idx) in restreams" :title="restreamError(target)">
+                    {{target.name}} ({{restreamStatus(target)}})
+                    <a title="Remove target" @click="restreams.splice(idx, 1)">
+                        <i class="fa fas fa-fw fa-trash-alt"></i>
+                    </a>
+                    <a :title="target.enabled ? 'Disable target' : 'Enable target'" @click="target.enabled = !target.enabled">
+                        <i :class="['fa', 'fas', 'fa-fw', target.enabled ? 'fa-pause' : 'fa-play']"></i>
+                    </a>
+                </li>
+            </ul>
+            <div class="setting-group-form">
+                <label for="input-restream-name">Name</label>
+                <input type="text" id="input-restream-name" placeholder="YouTube" v-model="restreamTarget.name"/>
+                <label for="input-restream-url">URL</label>
+                <input type="text" id="input-restream-url" placeholder="rtmp://a.rtmp.youtube.com/live2" v-model="restreamTarget.url"/>
+                <label for="input-restream-key">Stream key</label>
+                <input type="password" id="input-restream-key" v-model="restreamTarget.key"/>
+                <label>Note</label>
+                <p class="setting-group-text">Stream key is hidden from the logs, but it's passed to ffmpeg, so it's visible in the process list of this device to anyone who can log in to it.</p>
+            </div>
+            <div class="setting-group-footer">
+                <a @click="addRestreamTarget">Add Target</a>
+                <a @click="saveRestreams">Save Restream</a>
+            </div>
+        </details>
+        <details class="setting-group" id="setting-timelapse" @toggle="toggleTimelapse">
+            <summary>Timelapse</summary>
+            <div class="setting-group-form">
+                <label for="select-timelapse-camera">Camera</label>
+                <div class="setting-group-select">
+                    <select id="select-timelapse-camera" v-model="timelapse.camera">
+                        <option v-for="item in cameras">{{item.id}}</option>
+                    </select>
+                </div>
+                <label for="input-timelapse-from">From</label>
+                <input type="datetime-local" id="input-timelapse-from" v-model="timelapse.from"/>
+                <label for="input-timelapse-to">To</label>
+                <input type="datetime-local" id="input-timelapse-to" v-model="timelapse.to"/>
+                <label for="input-timelapse-interval">Interval (seconds)</label>
+                <input type="number" id="input-timelapse-interval" min="1" v-model.number="timelapse.interval"/>
+                <label for="input-timelapse-fps">Framerate</label>
+                <input type="number" id="input-timelapse-fps" min="1" max="60" v-model.number="timelapse.fps"/>
+            </div>
+            <ul>
+                <li v-if="timelapseJobs.length === 0">No timelapse yet</li>
+                <li v-for="job in timelapseJobs" :title="job.error">
+                    {{timelapseLabel(job)}}
+                    <a title="Delete timelapse" v-if="job.status === 'done' || job.status === 'failed'" @click="deleteTimelapse(job)">
+                        <i class="fa fas fa-fw fa-trash-alt"></i>
+                    </a>
+                    <a title="Download timelapse" v-if="job.status === 'done'" :href="'/api/timelapse/' + job.id + '/video'" download>
+                        <i class="fa fas fa-fw fa-save"></i>
+                    </a>
+                </li>
+            </ul>
+            <div class="setting-group-footer">
+                <a @click="buildTimelapse">Build Timelapse</a>
+            </div>
+        </details>
+        <details class="setting-group" id="setting-events" @toggle="toggleEvents">
+            <summary>Events</summary>
+            <ul>
+                <li v-if="events.length === 0">No event yet</li>
+                <li v-for="event in events">{{eventLabel(event)}}</li>
+            </ul>
+        </details>
+        <details class="setting-group" id="setting-logs" @toggle="toggleLogs">
+            <summary>Logs</summary>
+            <pre ref="logs"><template v-for="entry in logs">{{formatLogEntry(entry)}}\n</template></pre>
+            <div class="setting-group-footer">
+                <a @click="toggleFollowLogs">{{followLogs ? "Stop Following" : "Follow Logs"}}</a>
+            </div>
+        </details>
     </div>
     <div class="loading-overlay" v-if="loading"><i class="fas fa-fw fa-spin fa-spinner"></i></div>
     <cygnus-dialog v-bind="dialog"/>
@@ -68,11 +353,544 @@ export default {
     data() {
         return {
             users: [],
-            camera: {},
+            cameras: [],
+            selectedCameraID: "",
+            sources: [],
+            rtspPort: 0,
+            rtspReset: [],
+            sourceLabels: {
+                raspivid: "Raspberry Pi camera",
+                v4l2: "USB webcam (V4L2)",
+                rtsp: "IP camera (RTSP/HTTP)",
+                testsrc: "Test pattern",
+                file: "Video file",
+            },
+            timelapse: {
+                camera: "",
+                from: "",
+                to: "",
+                interval: 5,
+                fps: 30,
+            },
+            timelapseJobs: [],
+            timelapseTimer: null,
+            logs: [],
+            profiles: [],
+            activeProfile: "",
+            schedule: [],
+            scheduleEntry: {
+                time: "",
+                offset: 0,
+                profile: "",
+            },
+            events: [],
+            arming: {
+                mode: "armed",
+                armed: false,
+            },
+            armingRanges: ["", "", "", "", "", "", ""],
+            weekdays: ["Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"],
+            masks: [],
+            maskSnapshot: "",
+            maskDrawing: null,
+            masksOpen: false,
+            restreams: [],
+            restreamStatuses: [],
+            restreamTarget: {
+                name: "",
+                url: "",
+                key: "",
+            },
+            restreamsOpen: false,
+            followLogs: false,
+            logSource: null,
             loading: false,
         }
     },
+    computed: {
+        rtspURL() {
+            return `rtsp://${location.hostname}:${this.rtspPort}/${this.selectedCameraID}`;
+        },
+        selectedCamera() {
+            return this.cameras.find(item => item.id === this.selectedCameraID) || {
+                setting: {},
+                capabilities: {},
+            };
+        },
+        camera() {
+            return this.selectedCamera.setting;
+        },
+        source() {
+            return this.selectedCamera.source;
+        },
+        capabilities() {
+            return this.selectedCamera.capabilities;
+        },
+        status() {
+            return this.selectedCamera.status || {};
+        },
+        statusText() {
+            var text = this.status.running ? "Running" : "Stopped";
+            if (this.status.restarts > 0) {
+                var errorTime = new Date(this.status.lastErrorTime).toLocaleString();
+                text += `, restarted ${this.status.restarts} times, last error at ${errorTime}`;
+            }
+            return text;
+        },
+        sourceChanged() {
+            return this.camera.source !== this.source;
+        },
+        formats() {
+            if (this.sourceChanged) return [];
+            return this.capabilities.formats || [];
+        },
+        selectedFormat() {
+            return this.formats.find(format => format.name === this.camera.format) || null;
+        },
+        rotations() {
+            if (this.sourceChanged) return [];
+            return this.capabilities.rotations || [];
+        },
+        resolutions() {
+            if (this.sourceChanged) return [];
+            if (this.selectedFormat == null) return this.capabilities.resolutions || [];
+            return this.selectedFormat.sizes.map(size => `${size.width}x${size.height}`);
+        },
+        parameters() {
+            if (this.sourceChanged) return [];
+            return this.capabilities.parameters || [];
+        },
+        maskRegions() {
+            if (this.maskDrawing == null) return this.masks;
+            return this.masks.concat([this.maskDrawing]);
+        },
+        frameRates() {
+            if (this.selectedFormat == null) return [];
+            var size = this.selectedFormat.sizes.find(size => `${size.width}x${size.height}` === this.camera.resolution);
+            return size ? size.frameRates : [];
+        }
+    },
+    watch: {
+        selectedCameraID() {
+            this.loadProfiles();
+            this.loadArming();
+            if (this.masksOpen) this.loadMasks();
+            if (this.restreamsOpen) this.loadRestreams();
+        }
+    },
     methods: {
+        loadProfiles() {
+            if (this.selectedCameraID === "") return;
+
+            fetch(`/api/profile/${this.selectedCameraID}`)
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.profiles = json.profiles;
+                    this.activeProfile = json.active;
+                    this.schedule = json.schedule;
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        scheduleLabel(entry) {
+            var time = entry.time;
+            if (entry.offset > 0) time += ` +${entry.offset} minutes`;
+            if (entry.offset < 0) time += ` ${entry.offset} minutes`;
+            return `${time}, switch to ${entry.profile}`;
+        },
+        addScheduleEntry() {
+            if (this.scheduleEntry.time === "" || this.scheduleEntry.profile === "") {
+                this.showErrorDialog("Time and profile must not empty");
+                return;
+            }
+
+            this.schedule.push(Object.assign({}, this.scheduleEntry));
+        },
+        saveSchedule() {
+            this.loading = true;
+            fetch(`/api/schedule/${this.selectedCameraID}`, {
+                    method: "post",
+                    body: JSON.stringify(this.schedule),
+                    headers: {
+                        "Content-Type": "application/json",
+                    },
+                })
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response;
+                })
+                .then(() => {
+                    this.loading = false;
+                    this.loadProfiles();
+                })
+                .catch(err => {
+                    this.loading = false;
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        loadArming() {
+            if (this.selectedCameraID === "") return;
+
+            fetch(`/api/arming/${this.selectedCameraID}`)
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.arming = json;
+                    this.armingRanges = json.schedule.map(ranges => {
+                        return (ranges || []).map(range => `${range.start}-${range.end}`).join(", ");
+                    });
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        saveArming() {
+            var schedule = this.armingRanges.map(text => {
+                return text.split(",")
+                    .map(part => part.trim())
+                    .filter(part => part !== "")
+                    .map(part => {
+                        var times = part.split("-").map(time => time.trim());
+                        return { start: times[0], end: times[1] || "" };
+                    });
+            });
+
+            this.loading = true;
+            fetch(`/api/arming/${this.selectedCameraID}`, {
+                    method: "post",
+                    body: JSON.stringify({
+                        mode: this.arming.mode,
+                        schedule: schedule,
+                    }),
+                    headers: {
+                        "Content-Type": "application/json",
+                    },
+                })
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response;
+                })
+                .then(() => {
+                    this.loading = false;
+                    this.loadArming();
+                })
+                .catch(err => {
+                    this.loading = false;
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        loadMasks() {
+            if (this.selectedCameraID === "") return;
+
+            this.maskSnapshot = `/api/snapshot?camera=${this.selectedCameraID}&t=${Date.now()}`;
+            fetch(`/api/mask/${this.selectedCameraID}`)
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.masks = json;
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        toggleMasks(e) {
+            this.masksOpen = e.target.open;
+            if (this.masksOpen) this.loadMasks();
+        },
+        maskPoint(e) {
+            var rect = e.currentTarget.getBoundingClientRect();
+            return {
+                x: Math.min(Math.max((e.clientX - rect.left) / rect.width, 0), 1),
+                y: Math.min(Math.max((e.clientY - rect.top) / rect.height, 0), 1),
+            };
+        },
+        startMask(e) {
+            var point = this.maskPoint(e);
+            this.maskDrawing = { x: point.x, y: point.y, width: 0, height: 0, originX: point.x, originY: point.y };
+        },
+        moveMask(e) {
+            if (this.maskDrawing == null) return;
+
+            var point = this.maskPoint(e),
+                drawing = this.maskDrawing;
+            drawing.x = Math.min(point.x, drawing.originX);
+            drawing.y = Math.min(point.y, drawing.originY);
+            drawing.width = Math.abs(point.x - drawing.originX);
+            drawing.height = Math.abs(point.y - drawing.originY);
+        },
+        endMask() {
+            var drawing = this.maskDrawing;
+            this.maskDrawing = null;
+
+            // Ignore accidental click
+            if (drawing == null || drawing.width < 0.01 || drawing.height < 0.01) return;
+
+            // Round down, so the mask never exceeds the frame
+            var floor = val => Math.floor(val * 1000) / 1000,
+                x = floor(drawing.x),
+                y = floor(drawing.y);
+            this.masks.push({
+                x: x,
+                y: y,
+                width: floor(Math.min(drawing.x + drawing.width, 1) - x),
+                height: floor(Math.min(drawing.y + drawing.height, 1) - y),
+            });
+        },
+        maskStyle(mask) {
+            return {
+                left: `${mask.x * 100}%`,
+                top: `${mask.y * 100}%`,
+                width: `${mask.width * 100}%`,
+                height: `${mask.height * 100}%`,
+            };
+        },
+        maskLabel(mask) {
+            var percent = val => `${(val * 100).toFixed(1)}%`;
+            return `${percent(mask.width)} x ${percent(mask.height)} at ${percent(mask.x)}, ${percent(mask.y)}`;
+        },
+        eventLabel(event) {
+            var time = new Date(event.time).toLocaleString();
+            return `${time}, ${event.camera}: ${event.message}`;
+        },
+        loadEvents() {
+            fetch("/api/events")
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.events = json;
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        toggleEvents(e) {
+            if (e.target.open) this.loadEvents();
+        },
+        timelapseLabel(job) {
+            var from = new Date(job.from).toLocaleString(),
+                to = new Date(job.to).toLocaleString(),
+                status = job.status === "running" ? `${job.progress.toFixed(0)}%` : job.status;
+            return `${job.camera}, ${from} - ${to} (${status})`;
+        },
+        loadTimelapses() {
+            fetch("/api/timelapse")
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.timelapseJobs = json;
+
+                    // Keep updating while there are unfinished jobs
+                    clearTimeout(this.timelapseTimer);
+                    if (json.some(job => job.status === "queued" || job.status === "running")) {
+                        this.timelapseTimer = setTimeout(() => this.loadTimelapses(), 2000);
+                    }
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        loadRestreams() {
+            if (this.selectedCameraID === "") return;
+
+            fetch(`/api/restream/${this.selectedCameraID}`)
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.restreams = json.targets;
+                    this.restreamStatuses = json.statuses;
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        toggleRestreams(e) {
+            this.restreamsOpen = e.target.open;
+            if (this.restreamsOpen) this.loadRestreams();
+        },
+        restreamStatus(target) {
+            var status = this.restreamStatuses.find(item => item.name === target.name);
+            if (status == null || status.enabled !== target.enabled) return "not saved";
+            if (!status.enabled) return "disabled";
+
+            var text = status.running ? "streaming" : "reconnecting";
+            if (status.reconnects > 0) text += `, reconnected ${status.reconnects} times`;
+            return text;
+        },
+        restreamError(target) {
+            var status = this.restreamStatuses.find(item => item.name === target.name);
+            if (status == null || status.lastError === "") return "";
+
+            var errorTime = new Date(status.lastErrorTime).toLocaleString();
+            return `Last error at ${errorTime}: ${status.lastError}`;
+        },
+        addRestreamTarget() {
+            if (this.restreamTarget.name === "" || this.restreamTarget.url === "") {
+                this.showErrorDialog("Name and URL must not empty");
+                return;
+            }
+
+            this.restreams.push(Object.assign({ enabled: true }, this.restreamTarget));
+            this.restreamTarget = { name: "", url: "", key: "" };
+        },
+        saveRestreams() {
+            this.loading = true;
+            fetch(`/api/restream/${this.selectedCameraID}`, {
+                    method: "post",
+                    body: JSON.stringify(this.restreams),
+                    headers: {
+                        "Content-Type": "application/json",
+                    },
+                })
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response;
+                })
+                .then(() => {
+                    this.loading = false;
+                    this.loadRestreams();
+                })
+                .catch(err => {
+                    this.loading = false;
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        toggleTimelapse(e) {
+            if (e.target.open) {
+                if (this.timelapse.camera === "") this.timelapse.camera = this.selectedCameraID;
+                this.loadTimelapses();
+            } else {
+                clearTimeout(this.timelapseTimer);
+            }
+        },
+        buildTimelapse() {
+            if (this.timelapse.from === "" || this.timelapse.to === "") {
+                this.showErrorDialog("Time range must not empty");
+                return;
+            }
+
+            this.loading = true;
+            fetch("/api/timelapse", {
+                    method: "post",
+                    body: JSON.stringify(this.timelapse),
+                    headers: {
+                        "Content-Type": "application/json",
+                    },
+                })
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response;
+                })
+                .then(() => {
+                    this.loading = false;
+                    this.loadTimelapses();
+                })
+                .catch(err => {
+                    this.loading = false;
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        deleteTimelapse(job) {
+            fetch(`/api/timelapse/${job.id}`, { method: "delete" })
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response;
+                })
+                .then(() => {
+                    this.loadTimelapses();
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        formatLogEntry(entry) {
+            var time = new Date(entry.time).toLocaleString(),
+                fields = Object.keys(entry.fields || {})
+                .map(key => `${key}=${entry.fields[key]}`)
+                .join(" ");
+            return `${time} [${entry.level}] ${fields} ${entry.message}`;
+        },
+        scrollLogs() {
+            this.$nextTick(() => {
+                var pre = this.$refs.logs;
+                if (pre) pre.scrollTop = pre.scrollHeight;
+            });
+        },
+        loadLogs() {
+            fetch("/api/logs")
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.logs = json;
+                    this.scrollLogs();
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        toggleLogs(e) {
+            if (e.target.open) this.loadLogs();
+            else this.stopFollowLogs();
+        },
+        toggleFollowLogs() {
+            if (this.followLogs) {
+                this.stopFollowLogs();
+                return;
+            }
+
+            this.logs = [];
+            this.followLogs = true;
+            this.logSource = new EventSource("/api/logs?follow=1");
+            this.logSource.onmessage = e => {
+                this.logs.push(JSON.parse(e.data));
+                if (this.logs.length > 1000) this.logs.shift();
+                this.scrollLogs();
+            };
+        },
+        stopFollowLogs() {
+            if (this.logSource != null) this.logSource.close();
+            this.logSource = null;
+            this.followLogs = false;
+        },
         loadSetting() {
             this.loading = true;
 
@@ -82,8 +900,30 @@ export default {
                     return response.json();
                 })
                 .then(json => {
+                    json.cameras.forEach(item => {
+                        if (!item.setting.source) item.setting.source = item.source;
+                        if (!item.setting.motion) item.setting.motion = "off";
+                        if (!item.setting.recording) item.setting.recording = "continuous";
+                        if (!item.setting.audioSampleRate) item.setting.audioSampleRate = "44100";
+                        if (!item.setting.audioMute) item.setting.audioMute = "off";
+                        if (!item.setting.lowRendition) item.setting.lowRendition = "off";
+                        if (!item.setting.lowLatency) item.setting.lowLatency = "off";
+
+                        // Text parameters are left empty, so their default is shown as placeholder
+                        (item.capabilities.parameters || []).forEach(param => {
+                            if (item.setting[param.key] || param.type === "text" || param.type === "roi") return;
+                            item.setting[param.key] = param.default;
+                        });
+                    });
+
                     this.users = json.users;
-                    this.camera = json.camera;
+                    this.cameras = json.cameras;
+                    this.sources = json.sources;
+                    this.rtspPort = json.rtspPort;
+                    this.rtspReset = json.rtspReset;
+                    if (this.cameras.find(item => item.id === this.selectedCameraID) == null) {
+                        this.selectedCameraID = this.cameras.length > 0 ? this.cameras[0].id : "";
+                    }
                     this.loading = false;
                 })
                 .catch(err => {
@@ -147,6 +987,7 @@ export default {
                             this.dialog.visible = false;
                             this.users.push(data.username);
                             this.users.sort();
+                            this.rtspReset = this.rtspReset.filter(user => user !== data.username);
                         })
                         .catch(err => {
                             this.dialog.loading = false;
@@ -184,6 +1025,75 @@ export default {
                 }
             });
         },
+        showDialogNewCamera() {
+            this.showDialog({
+                title: "New Camera",
+                content: "Input ID for the new camera :",
+                fields: [{
+                    name: "id",
+                    label: "Camera ID",
+                    value: "",
+                }],
+                mainText: "OK",
+                secondText: "Cancel",
+                mainClick: (data) => {
+                    if (data.id === "") {
+                        this.showErrorDialog("Camera ID must not empty");
+                        return;
+                    }
+
+                    this.dialog.loading = true;
+                    fetch("/api/camera", {
+                            method: "post",
+                            body: JSON.stringify({
+                                id: data.id,
+                                source: this.source || this.sources[0],
+                            }),
+                            headers: {
+                                "Content-Type": "application/json",
+                            },
+                        })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response;
+                        })
+                        .then(() => {
+                            setTimeout(() => location.href = "/login", 3500);
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
+        showDialogDeleteCamera() {
+            this.showDialog({
+                title: "Delete Camera",
+                content: `Delete camera "${this.selectedCameraID}" ? Its recorded videos will be kept.`,
+                mainText: "Yes",
+                secondText: "No",
+                mainClick: () => {
+                    this.dialog.loading = true;
+                    fetch(`/api/camera/${this.selectedCameraID}`, { method: "delete" })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response;
+                        })
+                        .then(() => {
+                            setTimeout(() => location.href = "/login", 3500);
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
         showDialogSaveCamera() {
             this.showDialog({
                 title: "Camera Setting",
@@ -192,7 +1102,7 @@ export default {
                 secondText: "No",
                 mainClick: () => {
                     this.dialog.loading = true;
-                    fetch("/api/setting/camera", {
+                    fetch(`/api/setting/camera/${this.selectedCameraID}`, {
                             method: "post",
                             body: JSON.stringify(this.camera),
                             headers: {
@@ -215,6 +1125,135 @@ export default {
                 }
             });
         },
+        showDialogNewProfile() {
+            this.showDialog({
+                title: "New Profile",
+                content: "Save the current camera setting as profile :",
+                fields: [{
+                    name: "name",
+                    label: "Profile name",
+                    value: this.activeProfile,
+                }],
+                mainText: "OK",
+                secondText: "Cancel",
+                mainClick: (data) => {
+                    if (data.name === "") {
+                        this.showErrorDialog("Profile name must not empty");
+                        return;
+                    }
+
+                    this.dialog.loading = true;
+                    fetch(`/api/profile/${this.selectedCameraID}`, {
+                            method: "post",
+                            body: JSON.stringify({
+                                name: data.name,
+                                setting: this.camera,
+                            }),
+                            headers: {
+                                "Content-Type": "application/json",
+                            },
+                        })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response;
+                        })
+                        .then(() => {
+                            this.dialog.loading = false;
+                            this.dialog.visible = false;
+                            this.loadProfiles();
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
+        showDialogDeleteProfile(profile) {
+            this.showDialog({
+                title: "Delete Profile",
+                content: `Delete profile "${profile.name}" ?`,
+                mainText: "Yes",
+                secondText: "No",
+                mainClick: () => {
+                    this.dialog.loading = true;
+                    fetch(`/api/profile/${this.selectedCameraID}/${profile.name}`, { method: "delete" })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response;
+                        })
+                        .then(() => {
+                            this.dialog.loading = false;
+                            this.dialog.visible = false;
+                            this.loadProfiles();
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
+        showDialogActivateProfile(profile) {
+            this.showDialog({
+                title: "Activate Profile",
+                content: `Switch camera to profile "${profile.name}" ? The camera will be restarted.`,
+                mainText: "Yes",
+                secondText: "No",
+                mainClick: () => {
+                    this.dialog.loading = true;
+                    fetch(`/api/profile/${this.selectedCameraID}/${profile.name}/activate`, { method: "post" })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response;
+                        })
+                        .then(() => {
+                            setTimeout(() => location.href = "/login", 3500);
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
+        showDialogSaveMasks() {
+            this.showDialog({
+                title: "Privacy Masks",
+                content: "Save privacy masks ? The camera will be restarted.",
+                mainText: "Yes",
+                secondText: "No",
+                mainClick: () => {
+                    this.dialog.loading = true;
+                    fetch(`/api/mask/${this.selectedCameraID}`, {
+                            method: "post",
+                            body: JSON.stringify(this.masks),
+                            headers: {
+                                "Content-Type": "application/json",
+                            },
+                        })
+                        .then(response => {
+                            if (!response.ok) throw response;
+                            return response;
+                        })
+                        .then(() => {
+                            setTimeout(() => location.href = "/login", 3500);
+                        })
+                        .catch(err => {
+                            this.dialog.loading = false;
+                            err.text().then(msg => {
+                                this.showErrorDialog(`${msg} (${err.status})`);
+                            })
+                        });
+                }
+            });
+        },
         showDialogReboot() {
             this.showDialog({
                 title: "Reboot Camera",
@@ -245,5 +1284,9 @@ export default {
     },
     mounted() {
         this.loadSetting();
+    },
+    destroyed() {
+        this.stopFollowLogs();
+        clearTimeout(this.timelapseTimer);
     }
 }
\ No newline at end of file
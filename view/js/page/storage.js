@@ -5,6 +5,11 @@ var template = `
             <i class="fas fa-fw fa-arrow-left"></i>
         </a>
         <p>{{headerTitle}}</p>
+        <div class="camera-select" v-if="selectedFile === '' && cameraIDs.length > 1">
+            <select v-model="selectedCamera" title="Camera">
+                <option v-for="id in cameraIDs">{{id}}</option>
+            </select>
+        </div>
         <a title="Save video" v-if="selectedFile !== ''" :href="downloadURL" target="_blank" download>
             <i class="fas fa-fw fa-save"></i>
         </a>
@@ -16,9 +21,13 @@ var template = `
         <div v-for="(files, date) in fileGroups" class="file-group" :class="{expanded: selectedDate === date}">
             <a class="file-group-parent" @click="toggleFileGroup(date)">{{date}}</a>
             <div class="file-group-children">
-                <a v-for="time in files" 
-                    @click="selectFile(date, time)" 
-                    :class="{active: date+'-'+time === selectedFile}">{{time}}</a>
+                <a v-for="(file, idx) in files" 
+                    @click="selectFile(date, file.time)" 
+                    :title="motionTitle(date, idx)"
+                    :class="{active: date+'-'+file.time === selectedFile, motion: fileMotion(date, idx) > 0}">
+                    <img :src="file.thumbnail" loading="lazy" alt="">
+                    <span>{{fileLabel(file.time)}}</span>
+                </a>
             </div>
         </div>
     </div>
@@ -36,6 +45,7 @@ var template = `
 </div>`;
 
 import cygnusDialog from "../component/dialog.js";
+import vttThumbnails from "../component/vtt-thumbnails.js";
 import basePage from "./base.js";
 
 export default {
@@ -47,19 +57,28 @@ export default {
     data() {
         return {
             player: null,
-            fileGroups: {},
+            thumbnails: null,
+            cameraGroups: {},
+            selectedCamera: "",
             selectedDate: "",
             selectedFile: "",
+            motionEvents: {},
             loading: false,
         }
     },
     computed: {
+        cameraIDs() {
+            return Object.keys(this.cameraGroups).sort();
+        },
+        fileGroups() {
+            return this.cameraGroups[this.selectedCamera] || {};
+        },
         headerTitle() {
             if (this.selectedFile === "") return "Storage";
-            return this.selectedFile;
+            return `${this.selectedCamera} / ${this.selectedFile}`;
         },
         downloadURL() {
-            return `/video/${this.selectedFile}`;
+            return `/video/${this.selectedCamera}/${this.selectedFile}`;
         },
         listIsEmpty() {
             return Object.getOwnPropertyNames(this.fileGroups).length <= 1;
@@ -74,13 +93,59 @@ export default {
                 this.selectedDate = "";
             } else {
                 this.selectedDate = date;
+                this.loadMotionEvents(date);
             }
         },
+        loadMotionEvents(date) {
+            fetch(`/api/motion/${this.selectedCamera}?day=${date}`)
+                .then(response => {
+                    if (!response.ok) throw response;
+                    return response.json();
+                })
+                .then(json => {
+                    this.$set(this.motionEvents, date, json);
+                })
+                .catch(err => {
+                    err.text().then(msg => {
+                        this.showErrorDialog(`${msg} (${err.status})`);
+                    })
+                });
+        },
+        isMotionClip(time) {
+            return time.endsWith("-motion");
+        },
+        fileLabel(time) {
+            return this.isMotionClip(time) ? `${time.slice(0, 8)} (clip)` : time;
+        },
+        fileMotion(date, idx) {
+            // Each continuous video lasts until the next one started, at most 15 minutes.
+            // Motion clip is started a while before the motion, so check its first minute.
+            var files = this.fileGroups[date].map(file => file.time),
+                start = new Date(`${date}T${files[idx].slice(0, 8)}`),
+                end = new Date(start.getTime() + 60 * 1000);
+
+            if (!this.isMotionClip(files[idx])) {
+                end = new Date(start.getTime() + 15 * 60 * 1000);
+                var next = files.slice(idx + 1).find(time => !this.isMotionClip(time));
+                if (next != null && new Date(`${date}T${next}`) < end) {
+                    end = new Date(`${date}T${next}`);
+                }
+            }
+
+            return (this.motionEvents[date] || [])
+                .filter(event => new Date(event.start) < end && new Date(event.end) >= start)
+                .reduce((score, event) => Math.max(score, event.score), 0);
+        },
+        motionTitle(date, idx) {
+            var score = this.fileMotion(date, idx);
+            return score > 0 ? `Motion detected (${score.toFixed(1)}%)` : "";
+        },
         selectFile(date, time) {
             this.selectedFile = `${date}-${time}`;
         },
         loadListFile() {
-            this.fileGroups = {};
+            this.cameraGroups = {};
+            this.motionEvents = {};
             this.selectedDate = "";
             this.selectedFile = "";
             this.loading = true;
@@ -91,7 +156,10 @@ export default {
                     return response.json();
                 })
                 .then(json => {
-                    this.fileGroups = json;
+                    this.cameraGroups = json;
+                    if (!(this.selectedCamera in json)) {
+                        this.selectedCamera = this.cameraIDs[0] || "";
+                    }
                     this.loading = false;
                 })
                 .catch(err => {
@@ -103,13 +171,20 @@ export default {
         }
     },
     watch: {
+        selectedCamera() {
+            this.motionEvents = {};
+            this.selectedDate = "";
+            this.selectedFile = "";
+        },
         selectedFile(val) {
             if (val === "") {
                 this.player.pause();
                 this.player.hide();
+                this.thumbnails.clear();
             } else {
+                this.thumbnails.load(`/video/${this.selectedCamera}/${val}/thumbnails.vtt`);
                 this.player.src({
-                    src: `/video/${val}/playlist`,
+                    src: `/video/${this.selectedCamera}/${val}/playlist`,
                     type: "application/x-mpegURL"
                 });
                 this.player.show();
@@ -129,5 +204,6 @@ export default {
             },
         });
         this.player.hide();
+        this.thumbnails = vttThumbnails(this.player);
     }
 }
\ No newline at end of file